@@ -0,0 +1,401 @@
+// ##############################################################################################
+// Hier steht die Client-Authentifizierung am Token- und Revoke-Endpunkt. Die Methode ist austauschbar
+// (token_endpoint_auth_method, https://datatracker.ietf.org/doc/html/rfc7591#section-2):
+//
+//   - client_secret_post:  client_id und client_secret im Formular (Standard)
+//   - client_secret_basic: client_id und client_secret im Authorization Header (RFC 6749 Abschnitt 2.3.1)
+//   - private_key_jwt:     eine mit dem Schlüssel des Clients signierte Client Assertion (RFC 7523),
+//     der öffentliche Schlüssel wird unter /oa/jwks veröffentlicht und beim Provider hinterlegt
+//   - tls_client_auth / self_signed_tls_client_auth: das Client-Zertifikat der TLS-Verbindung (RFC 8705),
+//     über eine PKI bzw. selbst signiert und beim Provider hinterlegt. Der Provider kann die Access
+//     Tokens dann an das Zertifikat binden, der Resource Server prüft die Bindung
+// ##############################################################################################
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"jwk"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Namen der Methoden nach RFC 7591
+const (
+	ClientSecretPost  = "client_secret_post"
+	ClientSecretBasic = "client_secret_basic"
+	PrivateKeyJwt     = "private_key_jwt"
+
+	TlsClientAuth           = "tls_client_auth"
+	SelfSignedTlsClientAuth = "self_signed_tls_client_auth"
+)
+
+// Typ der Client Assertion nach RFC 7523 Abschnitt 2.2
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// ClientAuthenticator fügt den Anfragen an Token- und Revoke-Endpunkt die Client-Authentifizierung hinzu.
+type ClientAuthenticator interface {
+	// Method ist der Name der Methode nach RFC 7591
+	Method() string
+
+	// Attempts gibt die Varianten zurück, die nacheinander probiert werden, solange der Authorization
+	// Server mit invalid_client antwortet (mehrere nur während einer Rotation der Secrets)
+	Attempts() []ClientAuthAttempt
+}
+
+// ClientAuthAttempt ist eine Variante der Authentifizierung. Apply schreibt sie in Formular oder Header,
+// Accepted wird aufgerufen, wenn der Authorization Server die Anfrage angenommen hat (darf nil sein).
+type ClientAuthAttempt struct {
+	Apply    func(form url.Values, header http.Header) error
+	Accepted func()
+}
+
+// newClientAuthenticator erstellt die Authentifizierung für method. Die Secrets werden für die
+// client_secret_* Methoden benutzt, keyFile (PEM) für private_key_jwt.
+func newClientAuthenticator(method string, secrets *ClientSecrets, keyFile string) (ClientAuthenticator, error) {
+	switch method {
+	case ClientSecretPost, ClientSecretBasic:
+		return NewSecretAuthenticator(method, secrets), nil
+	case PrivateKeyJwt:
+		return LoadPrivateKeyJwtAuthenticator(keyFile)
+	case TlsClientAuth, SelfSignedTlsClientAuth:
+		return NewTlsClientAuthenticator(method), nil
+	default:
+		return nil, fmt.Errorf("unsupported token endpoint auth method %q", method)
+	}
+}
+
+// authMethodSupported prüft, ob der Provider die Methode in seinen Metadaten nennt. Fehlt die Liste,
+// gilt nach RFC 8414 Abschnitt 2 client_secret_basic als Standard.
+func authMethodSupported(metadata ProviderMetadata, method string) bool {
+	supported := metadata.TokenEndpointAuthMethodsSupported
+	if len(supported) == 0 {
+		return method == ClientSecretBasic
+	}
+	for _, m := range supported {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// ##############################################################################################
+// client_secret_post und client_secret_basic
+// ##############################################################################################
+
+// SecretAuthenticator authentifiziert den Client mit den Client Secrets (aktuelles und nächstes).
+type SecretAuthenticator struct {
+	method  string
+	secrets *ClientSecrets
+}
+
+// NewSecretAuthenticator erstellt die Authentifizierung mit Secrets für method (client_secret_post oder _basic).
+func NewSecretAuthenticator(method string, secrets *ClientSecrets) *SecretAuthenticator {
+	return &SecretAuthenticator{method: method, secrets: secrets}
+}
+
+func (auth *SecretAuthenticator) Method() string {
+	return auth.method
+}
+
+func (auth *SecretAuthenticator) Attempts() []ClientAuthAttempt {
+	candidates := auth.secrets.Candidates()
+	if len(candidates) == 0 {
+		// Ohne Secret nur mit client_id (öffentlicher Client)
+		return []ClientAuthAttempt{{Apply: func(form url.Values, header http.Header) error {
+			form.Set("client_id", ClientId)
+			return nil
+		}}}
+	}
+
+	attempts := make([]ClientAuthAttempt, len(candidates))
+	for i, secret := range candidates {
+		secret := secret
+		attempts[i] = ClientAuthAttempt{
+			Apply: func(form url.Values, header http.Header) error {
+				if auth.method == ClientSecretBasic {
+					// Beide Teile werden vorher form-kodiert (RFC 6749 Abschnitt 2.3.1)
+					req := http.Request{Header: header}
+					req.SetBasicAuth(url.QueryEscape(ClientId), url.QueryEscape(secret))
+					return nil
+				}
+				form.Set("client_id", ClientId)
+				form.Set("client_secret", secret)
+				return nil
+			},
+			Accepted: func() { auth.secrets.accepted(secret) },
+		}
+	}
+	return attempts
+}
+
+// ##############################################################################################
+// private_key_jwt
+// ##############################################################################################
+
+// PrivateKeyJwtAuthenticator signiert für jede Anfrage eine kurzlebige Client Assertion (RFC 7523 Abschnitt 3).
+type PrivateKeyJwtAuthenticator struct {
+	key    crypto.Signer
+	method jwt.SigningMethod
+	jwk    jwk.Key
+}
+
+// LoadPrivateKeyJwtAuthenticator liest den privaten Schlüssel (PKCS#8, PKCS#1 oder SEC 1 im PEM Format)
+// aus path. Der Algorithmus folgt aus dem Schlüssel: RS256, ES256/ES384/ES512 oder EdDSA.
+func LoadPrivateKeyJwtAuthenticator(path string) (*PrivateKeyJwtAuthenticator, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading client assertion key: %v", err)
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("client assertion key %s: %v", path, err)
+	}
+	return NewPrivateKeyJwtAuthenticator(key)
+}
+
+// NewPrivateKeyJwtAuthenticator erstellt die Authentifizierung mit dem Schlüssel key.
+func NewPrivateKeyJwtAuthenticator(key crypto.Signer) (*PrivateKeyJwtAuthenticator, error) {
+	var method jwt.SigningMethod
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			method = jwt.SigningMethodES256
+		case 384:
+			method = jwt.SigningMethodES384
+		case 521:
+			method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+		}
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	publicJwk, err := jwk.New(key.Public(), method.Alg())
+	if err != nil {
+		return nil, err
+	}
+	return &PrivateKeyJwtAuthenticator{key: key, method: method, jwk: publicJwk}, nil
+}
+
+// parsePrivateKey liest den ersten privaten Schlüssel aus einer PEM Datei.
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no private key found")
+		}
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				return nil, fmt.Errorf("unsupported key type %T", key)
+			}
+			return signer, nil
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(block.Bytes)
+		}
+	}
+}
+
+func (auth *PrivateKeyJwtAuthenticator) Method() string {
+	return PrivateKeyJwt
+}
+
+// Attempts gibt genau eine Variante zurück: die Client Assertion mit dem einen Schlüssel.
+func (auth *PrivateKeyJwtAuthenticator) Attempts() []ClientAuthAttempt {
+	return []ClientAuthAttempt{{Apply: func(form url.Values, header http.Header) error {
+		assertion, err := auth.Assertion(Provider.Metadata().TokenEndpoint, time.Now())
+		if err != nil {
+			return err
+		}
+		form.Set("client_id", ClientId)
+		form.Set("client_assertion_type", clientAssertionType)
+		form.Set("client_assertion", assertion)
+		return nil
+	}}}
+}
+
+// Assertion signiert eine Client Assertion für audience (nach OpenID Connect Core Abschnitt 9 der
+// Token-Endpunkt, auch für den Revoke-Endpunkt). iss und sub sind die Client Id, jti ist einmalig.
+func (auth *PrivateKeyJwtAuthenticator) Assertion(audience string, now time.Time) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Issuer:    ClientId,
+		Subject:   ClientId,
+		Audience:  jwt.ClaimStrings{audience},
+		ID:        generateJti(),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ClientAssertionLifetime)),
+	}
+	token := jwt.NewWithClaims(auth.method, claims)
+	token.Header["kid"] = auth.jwk.Kid
+	signed, err := token.SignedString(auth.key)
+	if err != nil {
+		return "", fmt.Errorf("signing client assertion: %v", err)
+	}
+	return signed, nil
+}
+
+// KeySet ist das öffentliche JWKS des Clients, das der Provider zur Prüfung der Assertions lädt.
+func (auth *PrivateKeyJwtAuthenticator) KeySet() jwk.Set {
+	return jwk.Set{Keys: []jwk.Key{auth.jwk}}
+}
+
+// ##############################################################################################
+// tls_client_auth und self_signed_tls_client_auth
+// ##############################################################################################
+
+// TlsClientAuthenticator authentifiziert den Client über das Zertifikat, das der HTTP-Client (siehe
+// InitHTTPClient) im TLS-Handshake vorlegt. In der Anfrage steht nur die client_id.
+type TlsClientAuthenticator struct {
+	method string
+}
+
+// NewTlsClientAuthenticator erstellt die mTLS-Authentifizierung für method.
+func NewTlsClientAuthenticator(method string) *TlsClientAuthenticator {
+	return &TlsClientAuthenticator{method: method}
+}
+
+func (auth *TlsClientAuthenticator) Method() string {
+	return auth.method
+}
+
+func (auth *TlsClientAuthenticator) Attempts() []ClientAuthAttempt {
+	return []ClientAuthAttempt{{Apply: func(form url.Values, header http.Header) error {
+		form.Set("client_id", ClientId)
+		return nil
+	}}}
+}
+
+// usesMutualTls prüft, ob sich der Client mit seinem Zertifikat authentifiziert.
+func usesMutualTls(auth ClientAuthenticator) bool {
+	_, ok := auth.(*TlsClientAuthenticator)
+	return ok
+}
+
+// ##############################################################################################
+// Anfragen mit Client-Authentifizierung
+// ##############################################################################################
+
+// mtlsAlias gibt bei mTLS den Alias des Endpunkts aus mtls_endpoint_aliases zurück (RFC 8705 Abschnitt 5),
+// falls der Provider einen angibt, sonst den gewöhnlichen Endpunkt plain.
+func mtlsAlias(plain string, alias func(*MtlsEndpointAliases) string) string {
+	aliases := Provider.Metadata().MtlsEndpointAliases
+	if usesMutualTls(ClientAuth) && aliases != nil && alias(aliases) != "" {
+		return alias(aliases)
+	}
+	return plain
+}
+
+// tokenEndpoint gibt den Token-Endpunkt zurück (siehe mtlsAlias).
+func tokenEndpoint() string {
+	return mtlsAlias(Provider.Metadata().TokenEndpoint, func(aliases *MtlsEndpointAliases) string { return aliases.TokenEndpoint })
+}
+
+// revocationEndpoint gibt den Revoke-Endpunkt zurück (siehe mtlsAlias).
+func revocationEndpoint() string {
+	return mtlsAlias(Provider.Metadata().RevocationEndpoint, func(aliases *MtlsEndpointAliases) string { return aliases.RevocationEndpoint })
+}
+
+// postClientRequest sendet params als Formular mit der Client-Authentifizierung aus ClientAuth an endpoint
+// (Token-, Revoke-, PAR- und Device Authorization Endpunkt). Antwortet der Authorization Server mit invalid_client, wird die Anfrage mit
+// der nächsten Variante wiederholt. Ist dpopKey gesetzt, bekommt jede Anfrage einen DPoP Proof (siehe dpop.go).
+// Der Aufrufer muss den Body der Antwort schließen.
+func postClientRequest(endpoint string, params url.Values, dpopKey *DPoPKey) (*http.Response, error) {
+	attempts := ClientAuth.Attempts()
+
+	var resp *http.Response
+	for i, attempt := range attempts {
+		// Die Anfrage wird für eine Wiederholung mit DPoP-Nonce neu gebaut (mit neuer Client Assertion)
+		newRequest := func() (*http.Request, error) {
+			form := url.Values{}
+			for key, values := range params {
+				form[key] = values
+			}
+			header := http.Header{}
+			header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if err := attempt.Apply(form, header); err != nil {
+				return nil, fmt.Errorf("client authentication: %v", err)
+			}
+			req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+			if err != nil {
+				return nil, err
+			}
+			req.Header = header
+			return req, nil
+		}
+
+		var err error
+		resp, err = doWithDPoP(newRequest, dpopKey, "")
+		if err != nil {
+			return nil, err
+		}
+		// Der PAR-Endpunkt antwortet mit 201 Created
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+			if attempt.Accepted != nil {
+				attempt.Accepted()
+			}
+			return resp, nil
+		}
+		if i == len(attempts)-1 {
+			break
+		}
+
+		// Der Body wird gelesen, um den Fehler zu prüfen, und für den Aufrufer wieder eingesetzt
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		var oauthErr *OAuthError
+		if !errors.As(readOAuthError(resp), &oauthErr) || oauthErr.Code != ErrorInvalidClient {
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+			return resp, nil
+		}
+		log.Println("Authorization server rejected the client credentials, retrying with the next ones")
+	}
+	return resp, nil
+}
+
+// ##############################################################################################
+// clientJwksHandler veröffentlicht das JWKS des Clients für private_key_jwt.
+// Bei den anderen Methoden gibt es keine Schlüssel, die Antwort ist dann 404.
+// ##############################################################################################
+
+func clientJwksHandler(w http.ResponseWriter, r *http.Request) {
+	auth, ok := ClientAuth.(*PrivateKeyJwtAuthenticator)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(auth.KeySet())
+}
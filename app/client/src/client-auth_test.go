@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"jwk"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ##############################################################################################
+// Tests für die Methoden der Client-Authentifizierung
+// ##############################################################################################
+
+func TestClientSecretBasic(t *testing.T) {
+	defer func(previous ClientAuthenticator) { ClientAuth = previous }(ClientAuth)
+	ClientAuth = NewSecretAuthenticator(ClientSecretBasic, NewClientSecrets("s3cr:t", ""))
+
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != url.QueryEscape(ClientId) || secret != url.QueryEscape("s3cr:t") {
+			t.Errorf("Expected basic auth with the form-encoded secret, got %q %q", id, secret)
+		}
+		if r.FormValue("client_secret") != "" {
+			t.Errorf("client_secret must not be sent in the body with client_secret_basic")
+		}
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: "fresh"})
+	})
+	defer server.Close()
+
+	if _, err := consumeRefreshToken("refresh", nil); err != nil {
+		t.Fatalf("Expected refresh, got %v", err)
+	}
+}
+
+func TestPrivateKeyJwt(t *testing.T) {
+	defer func(previous ClientAuthenticator) { ClientAuth = previous }(ClientAuth)
+	auth, err := LoadPrivateKeyJwtAuthenticator("../certs/server.key")
+	if err != nil {
+		t.Fatalf("Failed to load client assertion key: %v", err)
+	}
+	ClientAuth = auth
+
+	// Das veröffentlichte JWKS enthält den öffentlichen Schlüssel, mit dem der Provider prüft
+	recorder := httptest.NewRecorder()
+	clientJwksHandler(recorder, httptest.NewRequest("GET", "/oa/jwks", nil))
+	var keySet jwk.Set
+	if err := json.NewDecoder(recorder.Body).Decode(&keySet); err != nil || len(keySet.Keys) != 1 {
+		t.Fatalf("Expected one published key, got %v, %v", keySet, err)
+	}
+	publicKey, err := keySet.Keys[0].PublicKey()
+	if err != nil {
+		t.Fatalf("Failed to read published key: %v", err)
+	}
+
+	var tokenEndpoint string
+	jtis := make(map[string]bool)
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_secret") != "" {
+			t.Errorf("client_secret must not be sent with private_key_jwt")
+		}
+		if r.FormValue("client_assertion_type") != clientAssertionType {
+			t.Errorf("Unexpected client_assertion_type %q", r.FormValue("client_assertion_type"))
+		}
+		var claims jwt.RegisteredClaims
+		token, err := jwt.ParseWithClaims(r.FormValue("client_assertion"), &claims, func(token *jwt.Token) (interface{}, error) {
+			if token.Header["kid"] != keySet.Keys[0].Kid {
+				t.Errorf("Unexpected kid %v", token.Header["kid"])
+			}
+			return publicKey, nil
+		}, jwt.WithValidMethods([]string{"RS256"}))
+		if err != nil || !token.Valid {
+			t.Fatalf("Invalid client assertion: %v", err)
+		}
+		if claims.Issuer != ClientId || claims.Subject != ClientId || !claims.VerifyAudience(tokenEndpoint, true) {
+			t.Errorf("Unexpected assertion claims %+v", claims)
+		}
+		if claims.ExpiresAt.Sub(claims.IssuedAt.Time) > time.Minute || jtis[claims.ID] {
+			t.Errorf("Expected a short-lived assertion with a fresh jti, got %+v", claims)
+		}
+		jtis[claims.ID] = true
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: "fresh"})
+	})
+	defer server.Close()
+	tokenEndpoint = Provider.Metadata().TokenEndpoint
+
+	for i := 0; i < 2; i++ {
+		if _, err := consumeRefreshToken("refresh", nil); err != nil {
+			t.Fatalf("Expected refresh, got %v", err)
+		}
+	}
+}
+
+func TestTlsClientAuth(t *testing.T) {
+	defer func(previous ClientAuthenticator) { ClientAuth = previous }(ClientAuth)
+	ClientAuth = NewTlsClientAuthenticator(SelfSignedTlsClientAuth)
+
+	mux := http.NewServeMux()
+	server := httptest.NewUnstartedServer(mux)
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+	issuer := server.URL + "/"
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ProviderMetadata{
+			Issuer:                issuer,
+			AuthorizationEndpoint: issuer + "authorize/",
+			TokenEndpoint:         issuer + "token/",
+			MtlsEndpointAliases:   &MtlsEndpointAliases{TokenEndpoint: issuer + "mtls/token/"},
+		})
+	})
+	mux.HandleFunc("/token/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected the mtls alias of the token endpoint to be used")
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	mux.HandleFunc("/mtls/token/", func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			t.Errorf("Expected a client certificate")
+		}
+		if r.FormValue("client_id") != ClientId || r.FormValue("client_secret") != "" {
+			t.Errorf("Expected only client_id in the body")
+		}
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: "bound"})
+	})
+
+	// Der HTTP-Client legt sein Zertifikat vor wie in InitHTTPClient
+	cert, err := tls.LoadX509KeyPair(CertFile, KeyFile)
+	if err != nil {
+		t.Fatalf("Failed to load key pair: %v", err)
+	}
+	Client = *server.Client()
+	Client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{cert}
+	Provider = NewProviderDiscovery(issuer, time.Hour)
+	if err := Provider.Load(); err != nil {
+		t.Fatalf("Failed to load mock provider metadata: %v", err)
+	}
+
+	token, err := consumeRefreshToken("refresh", nil)
+	if err != nil || token.AccessToken != "bound" {
+		t.Fatalf("Expected refresh with mtls, got %v, %v", token, err)
+	}
+}
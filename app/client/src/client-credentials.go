@@ -0,0 +1,130 @@
+// ##############################################################################################
+// Hier steht der Client Credentials Grant (https://datatracker.ietf.org/doc/html/rfc6749#section-4.4) für
+// Batch-Jobs und Hintergrunddienste, die ohne Benutzer auf die notes-Api zugreifen. Der Token gehört dem
+// Client selbst (sub ist die Client Id), der Resource Server behandelt ihn als Service Principal mit eigenen
+// Notizen. Ein ClientCredentialsProvider speichert den Token und holt erst kurz vor dem Ablauf einen neuen.
+// ##############################################################################################
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ClientCredentialsProvider liefert einen Access Token des Clients für scope und resource. Er kann von
+// mehreren Goroutinen gleichzeitig benutzt werden, es läuft immer höchstens eine Anfrage an den Provider.
+type ClientCredentialsProvider struct {
+	scope     string
+	resource  string
+	mu        sync.Mutex
+	token     *OAuthToken
+	expiresAt time.Time
+	fetching  *clientTokenFetch
+}
+
+// clientTokenFetch ist eine laufende Anfrage an den Token-Endpunkt, done wird nach der Antwort geschlossen.
+type clientTokenFetch struct {
+	done  chan struct{}
+	token *OAuthToken
+	err   error
+}
+
+// NewClientCredentialsProvider erstellt einen Provider ohne Token, der erste wird beim ersten Aufruf von Token geholt.
+func NewClientCredentialsProvider(scope string, resource string) *ClientCredentialsProvider {
+	return &ClientCredentialsProvider{scope: scope, resource: resource}
+}
+
+// Token gibt den gespeicherten Access Token zurück oder holt einen neuen, wenn er bald abläuft
+// (Lebensdauer minus AccessTokenMargin, siehe token-lifetime.go). Wartende Aufrufer bekommen den neuen Token.
+// Der Mutex wird während der Anfrage an den Provider nicht gehalten, Invalidate blockiert also nie.
+func (p *ClientCredentialsProvider) Token() (OAuthToken, error) {
+	p.mu.Lock()
+	now := time.Now()
+	if p.token != nil && now.Before(p.expiresAt) {
+		token := *p.token
+		p.mu.Unlock()
+		return token, nil
+	}
+	if fetch := p.fetching; fetch != nil {
+		p.mu.Unlock()
+		<-fetch.done
+		if fetch.err != nil {
+			return OAuthToken{}, fetch.err
+		}
+		return *fetch.token, nil
+	}
+	fetch := &clientTokenFetch{done: make(chan struct{})}
+	p.fetching = fetch
+	p.mu.Unlock()
+
+	fetch.token, fetch.err = requestClientCredentialsToken(p.scope, p.resource)
+
+	p.mu.Lock()
+	if fetch.err == nil {
+		p.token = fetch.token
+		p.expiresAt = accessTokenExpiry(*fetch.token, now)
+	}
+	p.fetching = nil
+	p.mu.Unlock()
+	close(fetch.done)
+
+	if fetch.err != nil {
+		return OAuthToken{}, fetch.err
+	}
+	return *fetch.token, nil
+}
+
+// Invalidate verwirft den gespeicherten Token, z.B. wenn der Resource Server ihn mit 401 abgelehnt hat.
+func (p *ClientCredentialsProvider) Invalidate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.token = nil
+}
+
+// Session gibt den Token als SessionTokenData zurück, damit die Funktionen aus notes-adapter.go auch für
+// den Client selbst benutzt werden können.
+func (p *ClientCredentialsProvider) Session() (*SessionTokenData, error) {
+	token, err := p.Token()
+	if err != nil {
+		return nil, err
+	}
+	return &SessionTokenData{Token: token}, nil
+}
+
+// requestClientCredentialsToken fordert am Token-Endpunkt einen Access Token für den Client selbst an.
+// Lehnt der Authorization Server ab, wird ein *OAuthError zurückgegeben.
+func requestClientCredentialsToken(scope string, resource string) (*OAuthToken, error) {
+	params := url.Values{}
+	params.Set("grant_type", "client_credentials")
+	if scope != "" {
+		params.Set("scope", scope)
+	}
+	if resource != "" {
+		params.Set("resource", resource)
+	}
+
+	// Sendet die Anfrage mit Client-Authentifizierung (siehe client-auth.go)
+	resp, err := postClientRequest(tokenEndpoint(), params, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readOAuthError(resp)
+	}
+
+	var token OAuthToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("decoding response: %v", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("token response without access_token")
+	}
+	return &token, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ##############################################################################################
+// Tests für den Client Credentials Grant
+// ##############################################################################################
+
+func TestClientCredentialsProvider(t *testing.T) {
+	defer func(previous ClientAuthenticator) { ClientAuth = previous }(ClientAuth)
+	ClientAuth = NewSecretAuthenticator(ClientSecretPost, NewClientSecrets("secret", ""))
+
+	var calls int32
+	var expiresIn int32 = 3600
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("client_secret") != "secret" {
+			t.Errorf("Expected an authenticated client credentials request, got %v", r.Form)
+		}
+		if r.FormValue("scope") != "notes:read" || r.FormValue("resource") != "https://notes.example/notes" {
+			t.Errorf("Unexpected scope or resource %v", r.Form)
+		}
+		// Langsam, damit parallele Aufrufer gleichzeitig warten
+		time.Sleep(20 * time.Millisecond)
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: fmt.Sprintf("service-%d", n), TokenType: "Bearer", ExpiresIn: int(atomic.LoadInt32(&expiresIn))})
+	})
+	defer server.Close()
+
+	provider := NewClientCredentialsProvider("notes:read", "https://notes.example/notes")
+
+	// Parallele Aufrufer teilen sich eine Anfrage und bekommen denselben Token
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := provider.Token()
+			if err != nil {
+				t.Errorf("Expected token, got %v", err)
+			}
+			tokens[i] = token.AccessToken
+		}(i)
+	}
+	wg.Wait()
+	for _, token := range tokens {
+		if token != "service-1" {
+			t.Errorf("Expected the shared token service-1, got %v", tokens)
+			break
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected one token request, got %d", calls)
+	}
+
+	// Nach Invalidate wird ein neuer Token geholt
+	provider.Invalidate()
+	if token, _ := provider.Token(); token.AccessToken != "service-2" {
+		t.Errorf("Expected a new token after invalidate, got %q", token.AccessToken)
+	}
+
+	// Ein Token, der bald abläuft, wird vor dem Ablauf erneuert
+	atomic.StoreInt32(&expiresIn, 1)
+	provider.Invalidate()
+	provider.Token()
+	time.Sleep(600 * time.Millisecond)
+	if token, _ := provider.Token(); token.AccessToken != "service-4" {
+		t.Errorf("Expected a refreshed token before expiry, got %q", token.AccessToken)
+	}
+
+	session, err := provider.Session()
+	if err != nil || session.Token.AccessToken != "service-4" || session.DPoPKey != "" {
+		t.Errorf("Expected the token as session data, got %+v, %v", session, err)
+	}
+}
+
+func TestClientCredentialsError(t *testing.T) {
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "unauthorized_client"}`))
+	})
+	defer server.Close()
+
+	_, err := NewClientCredentialsProvider("notes:read", "").Token()
+	oauthErr, ok := err.(*OAuthError)
+	if !ok || oauthErr.Code != "unauthorized_client" {
+		t.Errorf("Expected unauthorized_client, got %v", err)
+	}
+}
+
+func TestClientCredentialsInvalidateDuringFetch(t *testing.T) {
+	defer func(previous ClientAuthenticator) { ClientAuth = previous }(ClientAuth)
+	ClientAuth = NewSecretAuthenticator(ClientSecretPost, NewClientSecrets("secret", ""))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: "service-1", TokenType: "Bearer", ExpiresIn: 3600})
+	})
+	defer server.Close()
+	defer close(release)
+
+	provider := NewClientCredentialsProvider("notes:read", "")
+	go provider.Token()
+	<-started
+
+	// Während der langsamen Anfrage an den Token-Endpunkt blockiert Invalidate nicht
+	invalidated := make(chan struct{})
+	go func() {
+		provider.Invalidate()
+		close(invalidated)
+	}()
+	select {
+	case <-invalidated:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected Invalidate not to wait for the token request")
+	}
+}
+
+func TestServiceRequestRetriesOnUnauthorized(t *testing.T) {
+	defer func(previous ClientAuthenticator) { ClientAuth = previous }(ClientAuth)
+	ClientAuth = NewSecretAuthenticator(ClientSecretPost, NewClientSecrets("secret", ""))
+
+	// Der Resource Server lehnt den ersten Token ab (z.B. widerrufen), mit reject alle Tokens
+	var reject bool
+	var requests []string
+	resource := mockResourceServer(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("Authorization"))
+		if reject || r.Header.Get("Authorization") == "Bearer service-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode([]Note{})
+	})
+	defer resource.Close()
+	var calls int32
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: fmt.Sprintf("service-%d", n), TokenType: "Bearer", ExpiresIn: 3600})
+	})
+	defer server.Close()
+
+	// Nach dem 401 wird der Token verworfen und die Anfrage einmal mit einem neuen Token wiederholt
+	provider := NewClientCredentialsProvider("notes:read", ResourceServer)
+	resp, err := doServiceRequest(http.MethodGet, ResourceServer, nil, provider)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the retried request to succeed, got %v", err)
+	}
+	resp.Body.Close()
+	if len(requests) != 2 || requests[1] != "Bearer service-2" {
+		t.Errorf("Expected a retry with a new token, got %v", requests)
+	}
+
+	// Der neue Token bleibt gespeichert
+	if token, _ := provider.Token(); token.AccessToken != "service-2" {
+		t.Errorf("Expected the new token to be cached, got %q", token.AccessToken)
+	}
+
+	// Wird auch der neue Token abgelehnt, kommt das 401 zurück, ohne weitere Versuche
+	reject = true
+	requests = nil
+	resp, err = doServiceRequest(http.MethodGet, ResourceServer, nil, provider)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || len(requests) != 2 {
+		t.Fatalf("Expected one retry and then the 401, got %v after %v", err, requests)
+	}
+	resp.Body.Close()
+}
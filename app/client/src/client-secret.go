@@ -0,0 +1,140 @@
+// ##############################################################################################
+// Hier wird das Client Secret verwaltet. Es steht nicht mehr im Quellcode, sondern kommt aus der
+// Umgebung (CLIENT_SECRET, CLIENT_SECRET_NEXT) oder aus einer Datei, die bei Änderungen neu geladen wird.
+// Während einer Rotation gibt es zwei gültige Secrets: lehnt der Authorization Server das aktuelle mit
+// invalid_client ab, wird die Anfrage mit dem nächsten wiederholt.
+// Die Secrets werden nie ausgegeben, auch nicht in Fehlermeldungen.
+// ##############################################################################################
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientSecrets hält das aktuelle und (während einer Rotation) das nächste Client Secret.
+// Ist path gesetzt, stehen beide in einer Datei: in der ersten Zeile das aktuelle, in der zweiten
+// optional das nächste. So wechseln bei einem Neuladen immer beide gleichzeitig.
+type ClientSecrets struct {
+	mu      sync.RWMutex
+	current string
+	next    string
+
+	// Hat der Authorization Server das nächste Secret akzeptiert, wird es bis zum Neuladen zuerst benutzt
+	preferNext bool
+
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// NewClientSecrets erstellt die Secrets aus festen Werten (z.B. aus der Umgebung).
+func NewClientSecrets(current string, next string) *ClientSecrets {
+	return &ClientSecrets{current: current, next: next}
+}
+
+// LoadClientSecretsFile liest die Secrets aus der Datei path (siehe Reload).
+func LoadClientSecretsFile(path string) (*ClientSecrets, error) {
+	secrets := &ClientSecrets{path: path}
+	if _, err := secrets.Reload(); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// Candidates gibt die Secrets in der Reihenfolge zurück, in der sie probiert werden.
+func (secrets *ClientSecrets) Candidates() []string {
+	secrets.mu.RLock()
+	defer secrets.mu.RUnlock()
+	var list []string
+	first, second := secrets.current, secrets.next
+	if secrets.preferNext {
+		first, second = second, first
+	}
+	for _, secret := range []string{first, second} {
+		if secret != "" && (len(list) == 0 || list[0] != secret) {
+			list = append(list, secret)
+		}
+	}
+	return list
+}
+
+// accepted merkt sich, welches Secret der Authorization Server zuletzt akzeptiert hat.
+func (secrets *ClientSecrets) accepted(secret string) {
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	if secret == secrets.next && secret != secrets.current && !secrets.preferNext {
+		log.Println("Authorization server accepted the next client secret, using it from now on")
+		secrets.preferNext = true
+	}
+}
+
+// Reload liest die Datei neu, wenn sich Änderungszeit oder Größe geändert haben, und meldet, ob neu
+// geladen wurde. Bei einem Fehler bleiben die bisherigen Secrets gültig. Der Inhalt der Datei
+// erscheint nie in einem Fehler.
+func (secrets *ClientSecrets) Reload() (bool, error) {
+	if secrets.path == "" {
+		return false, nil
+	}
+	info, err := os.Stat(secrets.path)
+	if err != nil {
+		return false, fmt.Errorf("client secret file: %v", err)
+	}
+	secrets.mu.RLock()
+	unchanged := info.ModTime().Equal(secrets.modTime) && info.Size() == secrets.size
+	secrets.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(secrets.path)
+	if err != nil {
+		return false, fmt.Errorf("client secret file: %v", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 || len(lines) > 2 {
+		return false, fmt.Errorf("client secret file %s must contain the current and optionally the next secret, one per line", secrets.path)
+	}
+
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	secrets.current = lines[0]
+	secrets.next = ""
+	if len(lines) == 2 {
+		secrets.next = lines[1]
+	}
+	secrets.preferNext = false
+	secrets.modTime = info.ModTime()
+	secrets.size = info.Size()
+	return true, nil
+}
+
+// Watch prüft die Datei alle interval auf Änderungen, bis stop geschlossen wird.
+func (secrets *ClientSecrets) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			reloaded, err := secrets.Reload()
+			if err != nil {
+				log.Printf("Keeping previous client secrets: %v\n", err)
+			} else if reloaded {
+				log.Println("Reloaded client secrets")
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ##############################################################################################
+// Tests für die Client Secrets und die Rotation
+// ##############################################################################################
+
+func TestClientSecretsFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client_secret")
+	if err := os.WriteFile(path, []byte("current\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secrets, err := LoadClientSecretsFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load client secrets: %v", err)
+	}
+	if got := secrets.Candidates(); !reflect.DeepEqual(got, []string{"current"}) {
+		t.Errorf("Expected [current], got %v", got)
+	}
+
+	// Rotation beginnt: das nächste Secret kommt in die zweite Zeile
+	if err := os.WriteFile(path, []byte("current\nnext-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := secrets.Reload(); err != nil || !reloaded {
+		t.Fatalf("Expected reload, got %v, %v", reloaded, err)
+	}
+	if got := secrets.Candidates(); !reflect.DeepEqual(got, []string{"current", "next-secret"}) {
+		t.Errorf("Expected [current next-secret], got %v", got)
+	}
+	if reloaded, _ := secrets.Reload(); reloaded {
+		t.Errorf("Expected no reload for an unchanged file")
+	}
+
+	// Eine ungültige Datei ändert nichts und verrät keine Secrets
+	if err := os.WriteFile(path, []byte("a-secret\nb-secret\nc-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = secrets.Reload()
+	if err == nil {
+		t.Fatalf("Expected an error for three secrets")
+	}
+	if strings.Contains(err.Error(), "secret\n") || strings.Contains(err.Error(), "a-secret") {
+		t.Errorf("Error must not contain secrets: %v", err)
+	}
+	if got := secrets.Candidates(); !reflect.DeepEqual(got, []string{"current", "next-secret"}) {
+		t.Errorf("Expected previous secrets to stay, got %v", got)
+	}
+}
+
+func TestClientSecretRotation(t *testing.T) {
+	defer func(previous ClientAuthenticator) { ClientAuth = previous }(ClientAuth)
+
+	var used []string
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		secret := r.FormValue("client_secret")
+		used = append(used, secret)
+		switch {
+		case r.FormValue("refresh_token") == "echo":
+			// Ein fehlerhafter Server, der die Anfrage zurückgibt
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(r.Form.Encode()))
+		case r.FormValue("refresh_token") == "revoked":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(OAuthError{Code: ErrorInvalidGrant})
+		case secret != "new":
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(OAuthError{Code: ErrorInvalidClient, Description: "client authentication failed"})
+		default:
+			json.NewEncoder(w).Encode(OAuthToken{AccessToken: "fresh", TokenType: "Bearer"})
+		}
+	})
+	defer server.Close()
+
+	// Das aktuelle Secret wird abgelehnt, die Anfrage mit dem nächsten wiederholt
+	ClientAuth = NewSecretAuthenticator(ClientSecretPost, NewClientSecrets("old", "new"))
+	token, err := consumeRefreshToken("refresh", nil)
+	if err != nil || token.AccessToken != "fresh" {
+		t.Fatalf("Expected refresh with the next secret, got %v, %v", token, err)
+	}
+	if !reflect.DeepEqual(used, []string{"old", "new"}) {
+		t.Errorf("Expected secrets [old new], got %v", used)
+	}
+
+	// Danach wird das akzeptierte Secret zuerst benutzt
+	used = nil
+	if _, err := consumeRefreshToken("refresh", nil); err != nil {
+		t.Fatalf("Expected refresh, got %v", err)
+	}
+	if !reflect.DeepEqual(used, []string{"new"}) {
+		t.Errorf("Expected secrets [new], got %v", used)
+	}
+
+	// Andere Fehler werden nicht wiederholt
+	used = nil
+	if _, err := consumeRefreshToken("revoked", nil); !isInvalidGrant(err) {
+		t.Errorf("Expected invalid_grant, got %v", err)
+	}
+	if len(used) != 1 {
+		t.Errorf("Expected one request, got %d", len(used))
+	}
+
+	// Lehnt der Server beide ab, kommt invalid_client beim Aufrufer an
+	ClientAuth = NewSecretAuthenticator(ClientSecretPost, NewClientSecrets("wrong-current", "wrong-next"))
+	_, err = consumeRefreshToken("refresh", nil)
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != ErrorInvalidClient {
+		t.Fatalf("Expected invalid_client, got %v", err)
+	}
+
+	// Der Body einer Fehlerantwort landet nie im Fehler, auch wenn er das Secret enthält
+	_, err = consumeRefreshToken("echo", nil)
+	if err == nil || strings.Contains(err.Error(), "wrong-current") {
+		t.Errorf("Error must not contain the client secret: %v", err)
+	}
+}
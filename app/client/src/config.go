@@ -0,0 +1,171 @@
+// ##############################################################################################
+// Hier wird die Konfiguration des Clients geladen (siehe Paket config): Die Variablen aus main.go
+// sind die Standardwerte, sie werden von Konfigurationsdatei, Umgebungsvariablen und Flags überschrieben.
+// ##############################################################################################
+
+package main
+
+import (
+	"config"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+// loadConfig registriert alle Einstellungen des Clients, lädt sie und gibt die wirksame Konfiguration aus.
+// Bei einer ungültigen Konfiguration startet der Client nicht. Zurückgegeben werden die übrigen Argumente
+// (z.B. "notes list").
+func loadConfig(args []string) ([]string, error) {
+	cfg := config.New("client")
+
+	// OAuth Client und Provider
+	cfg.String(&ClientId, "client_id", "CLIENT_ID", "OAuth client id").Required()
+	cfg.String(&ClientSecret, "client_secret", "CLIENT_SECRET", "OAuth client secret").Secret()
+	cfg.String(&ClientSecretNext, "client_secret_next", "CLIENT_SECRET_NEXT", "next client secret during a rotation").Secret()
+	cfg.String(&ClientSecretFile, "client_secret_file", "CLIENT_SECRET_FILE", "file with the current and optionally the next client secret, reloaded on change")
+	cfg.Duration(&ClientSecretReload, "client_secret_reload", "CLIENT_SECRET_RELOAD", "interval to check the client secret file for changes")
+	cfg.String(&TokenEndpointAuthMethod, "token_endpoint_auth_method", "TOKEN_ENDPOINT_AUTH_METHOD", "client_secret_post, client_secret_basic, private_key_jwt, tls_client_auth or self_signed_tls_client_auth")
+	cfg.String(&ClientAssertionKey, "client_assertion_key", "CLIENT_ASSERTION_KEY", "private key (PEM) for private_key_jwt, published under /oa/jwks")
+	cfg.String(&DPoPMode, "dpop_mode", "DPOP_MODE", "DPoP proof of possession: off, optional or required")
+	cfg.String(&ParMode, "par_mode", "PAR_MODE", "pushed authorization requests: off, preferred or required")
+	cfg.String(&ResponseMode, "response_mode", "RESPONSE_MODE", "response mode of the callback: query, form_post, query.jwt or form_post.jwt")
+	cfg.Duration(&ClientAssertionLifetime, "client_assertion_lifetime", "CLIENT_ASSERTION_LIFETIME", "lifetime of a client assertion")
+	cfg.String(&Issuer, "issuer", "ISSUER", "issuer url of the provider, endpoints are discovered from it").Required()
+	cfg.Duration(&IdTokenLeeway, "id_token_leeway", "ID_TOKEN_LEEWAY", "allowed clock skew for id tokens")
+
+	// URLs, Port und Cookie
+	cfg.String(&ResourceServer, "resource_server", "RESOURCE_SERVER", "notes endpoint of the resource server").Required()
+	cfg.String(&ResourceIndicator, "resource_indicator", "RESOURCE_INDICATOR", "resource parameter for notes tokens (defaults to resource_server)")
+	cfg.String(&ApplicationUrl, "application_url", "APPLICATION_URL", "url of the notes page of this client").Required()
+	cfg.String(&RedirectUrl, "redirect_url", "REDIRECT_URL", "redirect uri registered at the provider").Required()
+	cfg.String(&Port, "port", "PORT", "port the client listens on").Required()
+	cfg.String(&CookieDomain, "cookie_domain", "COOKIE_DOMAIN", "domain of the session cookie")
+
+	// Zertifikate
+	cfg.String(&CertFile, "cert_file", "CERT_FILE", "tls certificate of the client").Required()
+	cfg.String(&KeyFile, "key_file", "KEY_FILE", "tls private key of the client").Required()
+	cfg.String(&CaCertFile, "ca_cert_file", "CA_CERT_FILE", "ca certificate of the provider").Required()
+	cfg.String(&AuthentikCA, "authentik_ca", "AUTHENTIK_CA", "default certificate of authentik")
+
+	// Lebensdauern und Refresh
+	cfg.Duration(&AccessTokenMargin, "access_token_margin", "ACCESS_TOKEN_MARGIN", "refresh access tokens this long before they expire")
+	cfg.Duration(&DefaultAccessTokenLifetime, "default_access_token_lifetime", "DEFAULT_ACCESS_TOKEN_LIFETIME", "access token lifetime if the provider sends none")
+	cfg.Duration(&RefreshTokenLifetime, "refresh_token_lifetime", "REFRESH_TOKEN_LIFETIME", "refresh token lifetime if the provider sends none")
+	cfg.Bool(&DemoMode, "demo_mode", "DEMO_MODE", "force short token lifetimes to demonstrate refresh")
+	cfg.Duration(&DemoAccessTokenLifetime, "demo_access_token_lifetime", "DEMO_ACCESS_TOKEN_LIFETIME", "access token lifetime in demo mode")
+	cfg.Duration(&DemoSessionLifetime, "demo_session_lifetime", "DEMO_SESSION_LIFETIME", "session lifetime in demo mode")
+	cfg.Bool(&RefreshSchedulerEnabled, "refresh_scheduler", "REFRESH_SCHEDULER", "refresh active sessions in the background")
+	cfg.Duration(&RefreshInterval, "refresh_interval", "REFRESH_INTERVAL", "interval of the background refresh")
+	cfg.Duration(&RefreshLead, "refresh_lead", "REFRESH_LEAD", "background refresh this long before expiry")
+	cfg.Duration(&RefreshJitter, "refresh_jitter", "REFRESH_JITTER", "random extra lead of the background refresh")
+	cfg.Duration(&RefreshIdleAfter, "refresh_idle_after", "REFRESH_IDLE_AFTER", "stop background refresh for sessions idle this long")
+	cfg.Int(&RefreshConcurrency, "refresh_concurrency", "REFRESH_CONCURRENCY", "maximum parallel background refreshes")
+	cfg.Duration(&LoginStateTTL, "login_state_ttl", "LOGIN_STATE_TTL", "lifetime of a started login")
+
+	// Speicher für Sessions
+	cfg.String(&SessionStorage, "session_storage", "SESSION_STORAGE", "memory, file or postgres")
+	cfg.String(&StoreDirectory, "store_directory", "STORE_DIRECTORY", "directory of the file session storage")
+	cfg.String(&StoreDbHost, "store_db_host", "STORE_DB_HOST", "postgres host of the session storage")
+	cfg.String(&StoreDbPort, "store_db_port", "STORE_DB_PORT", "postgres port of the session storage")
+	cfg.String(&StoreDbUser, "store_db_user", "STORE_DB_USER", "postgres user of the session storage")
+	cfg.String(&StoreDbPassword, "store_db_password", "STORE_DB_PASSWORD", "postgres password of the session storage").Secret()
+	cfg.String(&StoreDbName, "store_db_name", "STORE_DB_NAME", "postgres database of the session storage")
+	cfg.String(&StoreDbSchema, "store_db_schema", "STORE_DB_SCHEMA", "postgres schema of the session storage")
+
+	// Token-Datei der Kommandozeile
+	cfg.String(&TokenFilePath, "token_file", "TOKEN_FILE", "encrypted token file of the notes command")
+	cfg.String(&TokenKeyFile, "token_key_file", "TOKEN_KEY_FILE", "key file of the token file, created on first login (default: in the user config directory)")
+	cfg.String(&TokenKey, "token_key", "TOKEN_KEY", "base64 encoded 32 byte key of the token file (instead of token_key_file)").Secret()
+
+	cfg.Validate(validateConfig)
+
+	rest, err := cfg.Load(args)
+	if err != nil {
+		return nil, err
+	}
+	// Der Resource Indicator ist die URL des Resource Servers, solange er nicht extra gesetzt ist
+	if cfg.IsSet("resource_server") && !cfg.IsSet("resource_indicator") {
+		ResourceIndicator = ResourceServer
+	}
+	// Auf stderr, damit die Ausgabe des Unterbefehls "notes" (z.B. notes list) nur die Notizen enthält
+	cfg.Print(os.Stderr)
+	return rest, nil
+}
+
+// validateConfig prüft die Werte, die nicht nur gesetzt sein müssen.
+func validateConfig() error {
+	var errs []error
+	for name, value := range map[string]string{
+		"issuer":          Issuer,
+		"resource_server": ResourceServer,
+		"application_url": ApplicationUrl,
+		"redirect_url":    RedirectUrl,
+	} {
+		if parsed, err := url.Parse(value); value != "" && (err != nil || parsed.Scheme != "https" || parsed.Host == "") {
+			errs = append(errs, fmt.Errorf("%s must be an absolute https url, got %q", name, value))
+		}
+	}
+	// Ein Resource Indicator ist eine absolute URI ohne Fragment (RFC 8707 Abschnitt 2)
+	if parsed, err := url.Parse(ResourceIndicator); ResourceIndicator != "" && (err != nil || !parsed.IsAbs() || parsed.Fragment != "") {
+		errs = append(errs, fmt.Errorf("resource_indicator must be an absolute uri without fragment, got %q", ResourceIndicator))
+	}
+	switch TokenEndpointAuthMethod {
+	case ClientSecretPost, ClientSecretBasic:
+		if ClientSecret == "" && ClientSecretFile == "" {
+			errs = append(errs, fmt.Errorf("client_secret or client_secret_file is required for %s", TokenEndpointAuthMethod))
+		}
+	case PrivateKeyJwt:
+		if ClientAssertionKey == "" || ClientAssertionLifetime <= 0 {
+			errs = append(errs, fmt.Errorf("client_assertion_key and a positive client_assertion_lifetime are required for %s", PrivateKeyJwt))
+		}
+	case TlsClientAuth, SelfSignedTlsClientAuth:
+		// Das Zertifikat ist cert_file/key_file des HTTP-Clients
+	default:
+		errs = append(errs, fmt.Errorf("token_endpoint_auth_method must be one of %s, %s, %s, %s or %s, got %q",
+			ClientSecretPost, ClientSecretBasic, PrivateKeyJwt, TlsClientAuth, SelfSignedTlsClientAuth, TokenEndpointAuthMethod))
+	}
+	if ClientSecretFile != "" && (ClientSecret != "" || ClientSecretNext != "") {
+		errs = append(errs, fmt.Errorf("client_secret_file cannot be combined with client_secret or client_secret_next"))
+	}
+	if ClientSecretReload <= 0 {
+		errs = append(errs, fmt.Errorf("client_secret_reload must be positive"))
+	}
+	switch DPoPMode {
+	case DPoPOff, DPoPOptional, DPoPRequired:
+	default:
+		errs = append(errs, fmt.Errorf("dpop_mode must be off, optional or required, got %q", DPoPMode))
+	}
+	switch ParMode {
+	case ParOff, ParPreferred, ParRequired:
+	default:
+		errs = append(errs, fmt.Errorf("par_mode must be off, preferred or required, got %q", ParMode))
+	}
+	switch ResponseMode {
+	case ResponseModeQuery, ResponseModeFormPost, ResponseModeQueryJwt, ResponseModeFormPostJwt:
+	default:
+		errs = append(errs, fmt.Errorf("response_mode must be query, form_post, query.jwt or form_post.jwt, got %q", ResponseMode))
+	}
+	switch SessionStorage {
+	case "memory", "file", "postgres":
+	default:
+		errs = append(errs, fmt.Errorf("session_storage must be memory, file or postgres, got %q", SessionStorage))
+	}
+	if TokenFilePath == "" || (TokenKey == "" && TokenKeyFile == "") {
+		errs = append(errs, fmt.Errorf("token_file and token_key or token_key_file are required"))
+	}
+	for name, value := range map[string]time.Duration{
+		"refresh_token_lifetime":        RefreshTokenLifetime,
+		"default_access_token_lifetime": DefaultAccessTokenLifetime,
+		"login_state_ttl":               LoginStateTTL,
+	} {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", name))
+		}
+	}
+	if RefreshSchedulerEnabled && (RefreshInterval <= 0 || RefreshConcurrency < 1) {
+		errs = append(errs, fmt.Errorf("refresh_interval and refresh_concurrency must be positive"))
+	}
+	return errors.Join(errs...)
+}
@@ -5,6 +5,7 @@
 package main
 
 import (
+	cryptorand "crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
@@ -24,12 +25,26 @@ func generateState() string {
 	return fmt.Sprintf("st-%s", generateCodeVerifier())
 }
 
+// Generiert eine nonce für den ID Token.
+// Die nonce bindet den ID Token an den Login-Versuch und verhindert Replay-Angriffe.
+func generateNonce() string {
+	return fmt.Sprintf("n-%s", generateCodeVerifier())
+}
+
 // Generiert ein Sitzungs-Token, indem ein Code-Verifier verwendet wird.
 // Dies stellt sicher, dass jede Sitzung eindeutig und sicher ist.
 func generateSessionToken() string {
 	return generateCodeVerifier()
 }
 
+// Generiert eine einmalige Id (jti) für Client Assertions.
+// Der Authorization Server kann damit wiederholte Assertions erkennen, deswegen aus crypto/rand.
+func generateJti() string {
+	b := make([]byte, 16)
+	cryptorand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
 // Generiert einen Code-Verifier
 // Dies ist ein Bestandteil des OAuth PKCE-Prozesses (Proof Key for Code Exchange).
 func generateCodeVerifier() string {
@@ -0,0 +1,142 @@
+// ##############################################################################################
+// Hier steht der Device Authorization Grant (https://datatracker.ietf.org/doc/html/rfc8628) für Geräte
+// ohne Browser, z.B. die Kommandozeile. Der Client fordert beim Provider einen device_code und einen
+// user_code an, der Benutzer gibt den user_code auf einem anderen Gerät unter der verification_uri ein.
+// In der Zwischenzeit fragt der Client den Token-Endpunkt im vorgegebenen Intervall ab.
+// ##############################################################################################
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// grant_type des Device Authorization Grant
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Fehlercodes beim Abfragen des Token-Endpunkts (RFC 8628 Abschnitt 3.5)
+const (
+	ErrorAuthorizationPending = "authorization_pending"
+	ErrorSlowDown             = "slow_down"
+	ErrorExpiredToken         = "expired_token"
+)
+
+// zur Darstellung der Antwort des Device Authorization Endpunkts (RFC 8628 Abschnitt 3.2)
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationUri         string `json:"verification_uri"`
+	VerificationUriComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval,omitempty"`
+}
+
+// deviceAuthorizationEndpoint gibt den Device Authorization Endpunkt zurück (siehe mtlsAlias), ohne Device Authorization
+// Endpunkt in den Metadaten ist das Ergebnis leer.
+func deviceAuthorizationEndpoint() string {
+	return mtlsAlias(Provider.Metadata().DeviceAuthorizationEndpoint, func(aliases *MtlsEndpointAliases) string {
+		return aliases.DeviceAuthorizationEndpoint
+	})
+}
+
+// startDeviceAuthorization fordert device_code und user_code für scope an. Lehnt der Authorization Server ab,
+// wird ein *OAuthError zurückgegeben.
+func startDeviceAuthorization(scope string) (*DeviceAuthorization, error) {
+	endpoint := deviceAuthorizationEndpoint()
+	if endpoint == "" {
+		return nil, fmt.Errorf("provider does not announce a device authorization endpoint")
+	}
+
+	params := url.Values{}
+	params.Set("scope", scope)
+	addResource(params)
+
+	// Sendet die Anfrage mit Client-Authentifizierung (siehe client-auth.go)
+	resp, err := postClientRequest(endpoint, params, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readOAuthError(resp)
+	}
+
+	var device DeviceAuthorization
+	if err := json.NewDecoder(resp.Body).Decode(&device); err != nil {
+		return nil, fmt.Errorf("decoding response: %v", err)
+	}
+	if device.DeviceCode == "" || device.UserCode == "" || device.VerificationUri == "" {
+		return nil, fmt.Errorf("incomplete device authorization response")
+	}
+	return &device, nil
+}
+
+// ##############################################################################################
+// pollDeviceToken fragt den Token-Endpunkt ab, bis der Benutzer den Zugriff erlaubt oder abgelehnt hat
+// oder der device_code abläuft. Zwischen zwei Anfragen wird mit wait das Intervall des Providers gewartet
+// (ohne Angabe 5 Sekunden), bei slow_down wird es um 5 Sekunden verlängert (RFC 8628 Abschnitt 3.5).
+// Mit dpopKey werden die Tokens an den Schlüssel gebunden.
+// ##############################################################################################
+
+func pollDeviceToken(device *DeviceAuthorization, dpopKey *DPoPKey, wait func(time.Duration)) (*OAuthToken, error) {
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	// Die Wartezeiten zählen, damit auch ein wait ohne echte Pause (Tests) den Ablauf erreicht
+	remaining := time.Duration(device.ExpiresIn) * time.Second
+
+	params := url.Values{}
+	params.Set("grant_type", deviceCodeGrantType)
+	params.Set("device_code", device.DeviceCode)
+
+	for {
+		if device.ExpiresIn > 0 && remaining <= 0 {
+			return nil, fmt.Errorf("the device code expired before the login was completed")
+		}
+		wait(interval)
+		remaining -= interval
+
+		token, err := requestDeviceToken(params, dpopKey)
+		var oauthErr *OAuthError
+		if err == nil {
+			return token, nil
+		}
+		if !errors.As(err, &oauthErr) {
+			return nil, err
+		}
+		switch oauthErr.Code {
+		case ErrorAuthorizationPending:
+		case ErrorSlowDown:
+			interval += 5 * time.Second
+		default:
+			// access_denied, expired_token und alle anderen Fehler beenden den Login
+			return nil, err
+		}
+	}
+}
+
+// requestDeviceToken sendet eine Abfrage an den Token-Endpunkt.
+func requestDeviceToken(params url.Values, dpopKey *DPoPKey) (*OAuthToken, error) {
+	resp, err := postClientRequest(tokenEndpoint(), params, dpopKey)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readOAuthError(resp)
+	}
+
+	var token OAuthToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("decoding response: %v", err)
+	}
+	return &token, nil
+}
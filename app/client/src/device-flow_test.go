@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// ##############################################################################################
+// Tests für den Device Authorization Grant
+// ##############################################################################################
+
+func TestDeviceFlowPolling(t *testing.T) {
+	defer func(previous ClientAuthenticator) { ClientAuth = previous }(ClientAuth)
+	ClientAuth = NewSecretAuthenticator(ClientSecretPost, NewClientSecrets("", ""))
+
+	// Antworten des Token-Endpunkts der Reihe nach: zweimal pending, dann slow_down, dann der Token
+	responses := []string{ErrorAuthorizationPending, ErrorAuthorizationPending, ErrorSlowDown, ""}
+	var polls int
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		// Derselbe Endpunkt dient im Test auch als Device Authorization Endpunkt
+		if r.FormValue("grant_type") == "" {
+			if r.FormValue("scope") != "notes offline_access" || r.FormValue("client_id") != ClientId {
+				t.Errorf("Unexpected device authorization request %v", r.Form)
+			}
+			json.NewEncoder(w).Encode(DeviceAuthorization{DeviceCode: "device-code", UserCode: "ABCD-EFGH", VerificationUri: "https://provider.example/device", ExpiresIn: 600, Interval: 2})
+			return
+		}
+		if r.FormValue("grant_type") != deviceCodeGrantType || r.FormValue("device_code") != "device-code" {
+			t.Errorf("Unexpected token request %v", r.Form)
+		}
+		response := responses[polls]
+		polls++
+		if response != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": response})
+			return
+		}
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: "device-access", TokenType: "Bearer", RefreshToken: "device-refresh", ExpiresIn: 300})
+	})
+	defer server.Close()
+	Provider.metadata.DeviceAuthorizationEndpoint = Provider.Metadata().TokenEndpoint
+
+	device, err := startDeviceAuthorization("notes offline_access")
+	if err != nil {
+		t.Fatalf("Expected device authorization, got %v", err)
+	}
+
+	// Gewartet wird vor jeder Abfrage, nach slow_down 5 Sekunden länger
+	var waits []time.Duration
+	token, err := pollDeviceToken(device, nil, func(d time.Duration) { waits = append(waits, d) })
+	if err != nil || token.AccessToken != "device-access" || token.RefreshToken != "device-refresh" {
+		t.Fatalf("Expected the device token, got %+v, %v", token, err)
+	}
+	expected := []time.Duration{2 * time.Second, 2 * time.Second, 2 * time.Second, 7 * time.Second}
+	if len(waits) != len(expected) {
+		t.Fatalf("Expected waits %v, got %v", expected, waits)
+	}
+	for i := range expected {
+		if waits[i] != expected[i] {
+			t.Errorf("Expected waits %v, got %v", expected, waits)
+			break
+		}
+	}
+}
+
+func TestDeviceFlowErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		expected string
+	}{
+		{"access denied", "access_denied", "access_denied"},
+		{"expired token", ErrorExpiredToken, ErrorExpiredToken},
+		{"still pending at expiry", ErrorAuthorizationPending, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var polls int
+			server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+				polls++
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": tt.response})
+			})
+			defer server.Close()
+
+			// Ohne interval gilt der Standard von 5 Sekunden, nach 10 Sekunden ist der device_code abgelaufen
+			device := &DeviceAuthorization{DeviceCode: "device-code", ExpiresIn: 10}
+			_, err := pollDeviceToken(device, nil, func(time.Duration) {})
+			if err == nil {
+				t.Fatalf("Expected an error")
+			}
+			oauthErr, ok := err.(*OAuthError)
+			if tt.expected != "" && (!ok || oauthErr.Code != tt.expected || polls != 1) {
+				t.Errorf("Expected %s after one poll, got %v after %d", tt.expected, err, polls)
+			}
+			if tt.expected == "" && (ok || polls != 2) {
+				t.Errorf("Expected expiry after two polls, got %v after %d", err, polls)
+			}
+		})
+	}
+}
+
+func TestDeviceFlowWithoutEndpoint(t *testing.T) {
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected no request without device authorization endpoint")
+	})
+	defer server.Close()
+
+	if _, err := startDeviceAuthorization("notes"); err == nil {
+		t.Errorf("Expected an error without device authorization endpoint")
+	}
+}
@@ -0,0 +1,233 @@
+// ##############################################################################################
+// Hier werden die Endpunkte des OAuth Providers per Discovery ermittelt:
+// OpenID Connect Discovery (https://openid.net/specs/openid-connect-discovery-1_0.html) und
+// OAuth 2.0 Authorization Server Metadata (https://datatracker.ietf.org/doc/html/rfc8414).
+// Die Metadaten werden zwischengespeichert und periodisch neu geladen.
+// ##############################################################################################
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// zur Darstellung der Metadaten eines Authorization Servers. Es werden nur die Felder übernommen,
+// die der Client tatsächlich benutzt.
+type ProviderMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint,omitempty"`
+	EndSessionEndpoint                string   `json:"end_session_endpoint,omitempty"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint,omitempty"`
+	JwksUri                           string   `json:"jwks_uri,omitempty"`
+	ScopesSupported                   []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported            []string `json:"response_types_supported,omitempty"`
+	GrantTypesSupported               []string `json:"grant_types_supported,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported,omitempty"`
+
+	// Response Modes und die Algorithmen für signierte Antworten (JARM)
+	ResponseModesSupported                 []string `json:"response_modes_supported,omitempty"`
+	AuthorizationSigningAlgValuesSupported []string `json:"authorization_signing_alg_values_supported,omitempty"`
+
+	// RFC 9207: der Provider sendet seinen Issuer als iss Parameter mit der Antwort an den Callback
+	AuthorizationResponseIssParameterSupported bool `json:"authorization_response_iss_parameter_supported,omitempty"`
+
+	// PAR (https://datatracker.ietf.org/doc/html/rfc9126#section-5): Endpunkt für Pushed Authorization Requests
+	// und ob der Provider sie für alle Clients verlangt
+	PushedAuthorizationRequestEndpoint string `json:"pushed_authorization_request_endpoint,omitempty"`
+	RequirePushedAuthorizationRequests bool   `json:"require_pushed_authorization_requests,omitempty"`
+
+	// Device Authorization Grant (https://datatracker.ietf.org/doc/html/rfc8628#section-4): Endpunkt für device_code und user_code
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint,omitempty"`
+
+	// mTLS (https://datatracker.ietf.org/doc/html/rfc8705#section-5): eigene Endpunkte für Anfragen mit
+	// Client-Zertifikat und ob der Provider zertifikatsgebundene Access Tokens ausstellt
+	MtlsEndpointAliases                   *MtlsEndpointAliases `json:"mtls_endpoint_aliases,omitempty"`
+	TlsClientCertificateBoundAccessTokens bool                 `json:"tls_client_certificate_bound_access_tokens,omitempty"`
+}
+
+// zur Darstellung der mtls_endpoint_aliases, nur die vom Client benutzten Endpunkte
+type MtlsEndpointAliases struct {
+	TokenEndpoint                      string `json:"token_endpoint,omitempty"`
+	RevocationEndpoint                 string `json:"revocation_endpoint,omitempty"`
+	PushedAuthorizationRequestEndpoint string `json:"pushed_authorization_request_endpoint,omitempty"`
+	DeviceAuthorizationEndpoint        string `json:"device_authorization_endpoint,omitempty"`
+}
+
+// merge übernimmt alle Felder aus other, die in m noch leer sind.
+// So ergänzen sich die OpenID- und die RFC 8414-Metadaten gegenseitig.
+func (m *ProviderMetadata) merge(other *ProviderMetadata) {
+	fillString := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	fillList := func(dst *[]string, src []string) {
+		if len(*dst) == 0 {
+			*dst = src
+		}
+	}
+	fillString(&m.AuthorizationEndpoint, other.AuthorizationEndpoint)
+	fillString(&m.TokenEndpoint, other.TokenEndpoint)
+	fillString(&m.RevocationEndpoint, other.RevocationEndpoint)
+	fillString(&m.EndSessionEndpoint, other.EndSessionEndpoint)
+	fillString(&m.UserinfoEndpoint, other.UserinfoEndpoint)
+	fillString(&m.JwksUri, other.JwksUri)
+	fillString(&m.PushedAuthorizationRequestEndpoint, other.PushedAuthorizationRequestEndpoint)
+	fillString(&m.DeviceAuthorizationEndpoint, other.DeviceAuthorizationEndpoint)
+	fillList(&m.ScopesSupported, other.ScopesSupported)
+	fillList(&m.ResponseTypesSupported, other.ResponseTypesSupported)
+	fillList(&m.GrantTypesSupported, other.GrantTypesSupported)
+	fillList(&m.CodeChallengeMethodsSupported, other.CodeChallengeMethodsSupported)
+	fillList(&m.TokenEndpointAuthMethodsSupported, other.TokenEndpointAuthMethodsSupported)
+	fillList(&m.IdTokenSigningAlgValuesSupported, other.IdTokenSigningAlgValuesSupported)
+	fillList(&m.ResponseModesSupported, other.ResponseModesSupported)
+	fillList(&m.AuthorizationSigningAlgValuesSupported, other.AuthorizationSigningAlgValuesSupported)
+	if m.MtlsEndpointAliases == nil {
+		m.MtlsEndpointAliases = other.MtlsEndpointAliases
+	}
+	m.TlsClientCertificateBoundAccessTokens = m.TlsClientCertificateBoundAccessTokens || other.TlsClientCertificateBoundAccessTokens
+	m.RequirePushedAuthorizationRequests = m.RequirePushedAuthorizationRequests || other.RequirePushedAuthorizationRequests
+	m.AuthorizationResponseIssParameterSupported = m.AuthorizationResponseIssParameterSupported || other.AuthorizationResponseIssParameterSupported
+}
+
+// validate prüft, ob die Metadaten zum erwarteten Issuer gehören und die Pflicht-Endpunkte enthalten.
+// Nach https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationValidation und
+// https://datatracker.ietf.org/doc/html/rfc8414#section-3.3 muss der Issuer exakt übereinstimmen.
+func (m *ProviderMetadata) validate(expectedIssuer string) error {
+	if m.Issuer != expectedIssuer {
+		return fmt.Errorf("issuer mismatch: expected '%s', provider reports '%s'", expectedIssuer, m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" {
+		return fmt.Errorf("provider metadata has no authorization_endpoint")
+	}
+	if m.TokenEndpoint == "" {
+		return fmt.Errorf("provider metadata has no token_endpoint")
+	}
+	return nil
+}
+
+// zur Verwaltung der zwischengespeicherten Provider-Metadaten. Enthält den konfigurierten Issuer,
+// die zuletzt erfolgreich geladenen Metadaten, einen Mutex zur Synchronisierung und das Intervall,
+// in dem die Metadaten neu geladen werden.
+type ProviderDiscovery struct {
+	issuer   string
+	metadata ProviderMetadata
+	mu       sync.RWMutex
+	interval time.Duration
+}
+
+// NewProviderDiscovery erstellt eine neue Instanz von ProviderDiscovery für den gegebenen Issuer.
+// Die Metadaten müssen danach einmal mit Load geladen werden.
+func NewProviderDiscovery(issuer string, interval time.Duration) *ProviderDiscovery {
+	return &ProviderDiscovery{
+		issuer:   issuer,
+		interval: interval,
+	}
+}
+
+// Metadata gibt eine Kopie der aktuell gültigen Metadaten zurück.
+func (d *ProviderDiscovery) Metadata() ProviderMetadata {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.metadata
+}
+
+// Load lädt die Metadaten vom Provider und ersetzt den Cache, wenn sie gültig sind.
+// Zuerst wird /.well-known/openid-configuration abgefragt, danach ergänzend die RFC 8414 Metadaten.
+// Es reicht, wenn eines der beiden Dokumente verfügbar ist.
+func (d *ProviderDiscovery) Load() error {
+	oidcMetadata, oidcErr := fetchProviderMetadata(openIDConfigurationUrl(d.issuer))
+	oauthMetadata, oauthErr := fetchProviderMetadata(authorizationServerMetadataUrl(d.issuer))
+
+	var metadata *ProviderMetadata
+	switch {
+	case oidcErr == nil && oauthErr == nil:
+		// Beide Dokumente müssen zum gleichen Issuer gehören, sonst wird nichts gemischt
+		if oauthMetadata.Issuer != oidcMetadata.Issuer {
+			return fmt.Errorf("issuer mismatch between openid-configuration ('%s') and oauth-authorization-server ('%s')",
+				oidcMetadata.Issuer, oauthMetadata.Issuer)
+		}
+		metadata = oidcMetadata
+		metadata.merge(oauthMetadata)
+	case oidcErr == nil:
+		metadata = oidcMetadata
+	case oauthErr == nil:
+		metadata = oauthMetadata
+	default:
+		return fmt.Errorf("discovery for issuer '%s' failed: %v; %v", d.issuer, oidcErr, oauthErr)
+	}
+
+	if err := metadata.validate(d.issuer); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.metadata = *metadata
+	return nil
+}
+
+// fetchProviderMetadata ruft ein Metadaten-Dokument mit dem TLS-Client ab und dekodiert es.
+func fetchProviderMetadata(metadataUrl string) (*ProviderMetadata, error) {
+	req, err := http.NewRequest(http.MethodGet, metadataUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad response status from %s: %s", metadataUrl, resp.Status)
+	}
+
+	var metadata ProviderMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", metadataUrl, err)
+	}
+	return &metadata, nil
+}
+
+// openIDConfigurationUrl hängt den well-known Pfad an den Issuer an
+// (https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationRequest).
+func openIDConfigurationUrl(issuer string) string {
+	return strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+}
+
+// authorizationServerMetadataUrl fügt den well-known Pfad zwischen Host und Pfad des Issuers ein
+// (https://datatracker.ietf.org/doc/html/rfc8414#section-3.1).
+func authorizationServerMetadataUrl(issuer string) string {
+	parsed, err := url.Parse(issuer)
+	if err != nil {
+		return strings.TrimSuffix(issuer, "/") + "/.well-known/oauth-authorization-server"
+	}
+	path := strings.TrimSuffix(parsed.Path, "/")
+	parsed.Path = "/.well-known/oauth-authorization-server" + path
+	return parsed.String()
+}
+
+// discoveryRefreshRoutine lädt die Metadaten im konfigurierten Intervall neu.
+// Schlägt das Neuladen fehl, bleiben die zuletzt gültigen Metadaten erhalten.
+func discoveryRefreshRoutine() {
+	for {
+		time.Sleep(Provider.interval)
+		if err := Provider.Load(); err != nil {
+			log.Printf("cannot refresh provider metadata: %s\n", err.Error())
+		}
+	}
+}
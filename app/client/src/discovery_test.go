@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Startet einen Mock Provider, der die Metadaten-Dokumente ausliefert.
+// Ist eines der Dokumente nil, antwortet der Provider an dieser Stelle mit 404
+func mockProvider(t *testing.T, oidc func(issuer string) *ProviderMetadata, oauth func(issuer string) *ProviderMetadata) (*httptest.Server, string) {
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	issuer := server.URL + "/application/o/notes/"
+
+	serve := func(build func(string) *ProviderMetadata) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if build == nil {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(build(issuer))
+		}
+	}
+	mux.HandleFunc("/application/o/notes/.well-known/openid-configuration", serve(oidc))
+	mux.HandleFunc("/.well-known/oauth-authorization-server/application/o/notes", serve(oauth))
+
+	Client = *server.Client()
+	return server, issuer
+}
+
+func TestDiscoveryMergesMetadata(t *testing.T) {
+	server, issuer := mockProvider(t,
+		func(issuer string) *ProviderMetadata {
+			return &ProviderMetadata{
+				Issuer:                issuer,
+				AuthorizationEndpoint: issuer + "authorize/",
+				TokenEndpoint:         issuer + "token/",
+				EndSessionEndpoint:    issuer + "end-session/",
+			}
+		},
+		func(issuer string) *ProviderMetadata {
+			return &ProviderMetadata{
+				Issuer:             issuer,
+				TokenEndpoint:      issuer + "other-token/",
+				RevocationEndpoint: issuer + "revoke/",
+			}
+		})
+	defer server.Close()
+
+	discovery := NewProviderDiscovery(issuer, time.Hour)
+	if err := discovery.Load(); err != nil {
+		t.Fatalf("Expected discovery to succeed, got %v", err)
+	}
+
+	metadata := discovery.Metadata()
+	// Die OpenID Metadaten haben Vorrang, fehlende Felder kommen aus RFC 8414
+	if metadata.TokenEndpoint != issuer+"token/" {
+		t.Errorf("Expected TokenEndpoint %v, got %v", issuer+"token/", metadata.TokenEndpoint)
+	}
+	if metadata.RevocationEndpoint != issuer+"revoke/" {
+		t.Errorf("Expected RevocationEndpoint %v, got %v", issuer+"revoke/", metadata.RevocationEndpoint)
+	}
+}
+
+func TestDiscoveryWithoutOpenIDConfiguration(t *testing.T) {
+	server, issuer := mockProvider(t, nil,
+		func(issuer string) *ProviderMetadata {
+			return &ProviderMetadata{
+				Issuer:                issuer,
+				AuthorizationEndpoint: issuer + "authorize/",
+				TokenEndpoint:         issuer + "token/",
+			}
+		})
+	defer server.Close()
+
+	discovery := NewProviderDiscovery(issuer, time.Hour)
+	if err := discovery.Load(); err != nil {
+		t.Fatalf("Expected discovery to succeed, got %v", err)
+	}
+	if discovery.Metadata().AuthorizationEndpoint != issuer+"authorize/" {
+		t.Errorf("Expected AuthorizationEndpoint from RFC 8414 metadata, got %v", discovery.Metadata().AuthorizationEndpoint)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	server, issuer := mockProvider(t,
+		func(issuer string) *ProviderMetadata {
+			return &ProviderMetadata{
+				Issuer:                "https://evil.example/",
+				AuthorizationEndpoint: issuer + "authorize/",
+				TokenEndpoint:         issuer + "token/",
+			}
+		}, nil)
+	defer server.Close()
+
+	discovery := NewProviderDiscovery(issuer, time.Hour)
+	if err := discovery.Load(); err == nil {
+		t.Errorf("Expected discovery to fail on issuer mismatch")
+	}
+
+	// Der Cache darf bei einem Fehler nicht befüllt werden
+	if discovery.Metadata().TokenEndpoint != "" {
+		t.Errorf("Expected empty metadata after failed discovery")
+	}
+}
+
+func TestLogoutRedirect(t *testing.T) {
+	defer func(previous ClientAuthenticator) { ClientAuth = previous }(ClientAuth)
+	defer func(previous *SessionTokenStore) { Sessions = previous }(Sessions)
+	defer func(previous string) { ApplicationUrl = previous }(ApplicationUrl)
+	ClientAuth = NewSecretAuthenticator(ClientSecretPost, NewClientSecrets("secret", ""))
+	ApplicationUrl = "https://client.example/notes"
+
+	tests := []struct {
+		name             string
+		endSession       bool
+		expectedLocation func(issuer string) string
+	}{
+		{"end session endpoint", true, func(issuer string) string { return issuer + "end-session/" }},
+		// Nicht jeder Provider bietet RP-Initiated Logout an, dann geht es zurück zur Anwendung
+		{"without end session endpoint", false, func(issuer string) string { return ApplicationUrl }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			server := httptest.NewTLSServer(mux)
+			defer server.Close()
+			issuer := server.URL + "/"
+			mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+				metadata := ProviderMetadata{
+					Issuer:                issuer,
+					AuthorizationEndpoint: issuer + "authorize/",
+					TokenEndpoint:         issuer + "token/",
+					RevocationEndpoint:    issuer + "revoke/",
+				}
+				if tt.endSession {
+					metadata.EndSessionEndpoint = issuer + "end-session/"
+				}
+				json.NewEncoder(w).Encode(metadata)
+			})
+			mux.HandleFunc("/revoke/", func(w http.ResponseWriter, r *http.Request) {})
+
+			Client = *server.Client()
+			Provider = NewProviderDiscovery(issuer, time.Hour)
+			if err := Provider.Load(); err != nil {
+				t.Fatalf("Failed to load mock provider metadata: %v", err)
+			}
+
+			Sessions = NewSessionTokenStoreWithBackend(NewMemoryStoreBackend(), time.Minute)
+			sessionToken, csrfToken := Sessions.AddToken(OAuthToken{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 300})
+
+			req := httptest.NewRequest(http.MethodPost, "/oa/logout", strings.NewReader(url.Values{"csrf_token": {csrfToken}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.AddCookie(&http.Cookie{Name: "GoNotesSessionToken", Value: sessionToken})
+			rec := httptest.NewRecorder()
+			handleLogout(rec, req)
+
+			if rec.Code != http.StatusSeeOther {
+				t.Fatalf("Expected status %v, got %v: %s", http.StatusSeeOther, rec.Code, rec.Body.String())
+			}
+			if location := rec.Header().Get("Location"); location != tt.expectedLocation(issuer) {
+				t.Errorf("Expected redirect to %q, got %q", tt.expectedLocation(issuer), location)
+			}
+			if _, exists := Sessions.GetData(sessionToken); exists {
+				t.Errorf("Expected the session to be removed")
+			}
+		})
+	}
+}
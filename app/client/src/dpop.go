@@ -0,0 +1,243 @@
+// ##############################################################################################
+// Hier steht DPoP (Demonstrating Proof of Possession, https://datatracker.ietf.org/doc/html/rfc9449).
+// Jede Session bekommt ein eigenes Schlüsselpaar. Mit jeder Anfrage an Token-Endpunkt und Resource Server
+// wird ein Proof (ein mit dem Schlüssel signiertes JWT über Methode und URL) im DPoP Header gesendet.
+// Der Provider bindet den Access Token an den Schlüssel (cnf.jkt), ein gestohlener Token ist ohne den
+// Schlüssel wertlos. Verlangt ein Server eine nonce (DPoP-Nonce), wird die Anfrage damit wiederholt.
+// ##############################################################################################
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"jwk"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Modi für DPoP
+const (
+	DPoPOff      = "off"      // keine Proofs, Bearer Tokens
+	DPoPOptional = "optional" // Proofs senden, Bearer Tokens werden aber akzeptiert, wenn der Provider kein DPoP kann
+	DPoPRequired = "required" // der Provider muss DPoP Tokens ausstellen, sonst schlägt der Login fehl
+)
+
+// Fehlercode, mit dem ein Server eine nonce im Proof verlangt (RFC 9449 Abschnitt 8 und 9)
+const ErrorUseDPoPNonce = "use_dpop_nonce"
+
+// DPoPKey ist das Schlüsselpaar einer Session (ECDSA P-256, signiert mit ES256).
+type DPoPKey struct {
+	key *ecdsa.PrivateKey
+	jwk jwk.Key
+}
+
+// NewDPoPKey erzeugt ein neues Schlüsselpaar.
+func NewDPoPKey() (*DPoPKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating dpop key: %v", err)
+	}
+	return newDPoPKey(key)
+}
+
+func newDPoPKey(key *ecdsa.PrivateKey) (*DPoPKey, error) {
+	publicJwk, err := jwk.New(&key.PublicKey, "")
+	if err != nil {
+		return nil, err
+	}
+	// Im Proof steht nur der öffentliche Schlüssel selbst
+	publicJwk.Kid, publicJwk.Use = "", ""
+	return &DPoPKey{key: key, jwk: publicJwk}, nil
+}
+
+// ParseDPoPKey liest einen mit Encode gespeicherten Schlüssel.
+func ParseDPoPKey(encoded string) (*DPoPKey, error) {
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decoding dpop key: %v", err)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing dpop key: %v", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("dpop key is not an ECDSA key")
+	}
+	return newDPoPKey(key)
+}
+
+// Encode kodiert den privaten Schlüssel (PKCS#8, base64) zum Speichern in der Session.
+func (k *DPoPKey) Encode() (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.key)
+	if err != nil {
+		return "", fmt.Errorf("encoding dpop key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+// Thumbprint ist der JWK Thumbprint des öffentlichen Schlüssels, im Token steht er als cnf.jkt.
+func (k *DPoPKey) Thumbprint() string {
+	return k.jwk.Thumbprint()
+}
+
+// dpopProofClaims sind die Claims eines Proofs (RFC 9449 Abschnitt 4.2).
+type dpopProofClaims struct {
+	Jti   string `json:"jti"`
+	Htm   string `json:"htm"`
+	Htu   string `json:"htu"`
+	Iat   int64  `json:"iat"`
+	Ath   string `json:"ath,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+}
+
+func (claims dpopProofClaims) Valid() error {
+	return nil
+}
+
+// Proof erstellt einen Proof für eine Anfrage mit method an target. Query und Fragment gehören nicht
+// zur htu. Bei Anfragen an den Resource Server ist accessToken gesetzt, sein Hash steht dann in ath.
+func (k *DPoPKey) Proof(method string, target string, accessToken string, nonce string) (string, error) {
+	htu, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("parsing dpop target: %v", err)
+	}
+	htu.RawQuery, htu.Fragment = "", ""
+
+	claims := dpopProofClaims{
+		Jti:   generateJti(),
+		Htm:   method,
+		Htu:   htu.String(),
+		Iat:   time.Now().Unix(),
+		Nonce: nonce,
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims.Ath = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = k.jwk
+	proof, err := token.SignedString(k.key)
+	if err != nil {
+		return "", fmt.Errorf("signing dpop proof: %v", err)
+	}
+	return proof, nil
+}
+
+// ##############################################################################################
+// Nonces der Server
+// ##############################################################################################
+
+// DPoPNonceCache merkt sich die zuletzt gesendete DPoP-Nonce pro Server (Schema und Host).
+type DPoPNonceCache struct {
+	mu     sync.Mutex
+	nonces map[string]string
+}
+
+// NewDPoPNonceCache erstellt einen leeren Cache.
+func NewDPoPNonceCache() *DPoPNonceCache {
+	return &DPoPNonceCache{nonces: make(map[string]string)}
+}
+
+func nonceOrigin(target *url.URL) string {
+	return strings.ToLower(target.Scheme + "://" + target.Host)
+}
+
+// Get gibt die nonce für den Server von target zurück (leer, wenn keine bekannt ist).
+func (cache *DPoPNonceCache) Get(target *url.URL) string {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.nonces[nonceOrigin(target)]
+}
+
+// Update übernimmt die nonce aus dem DPoP-Nonce Header der Antwort, falls der Server eine sendet.
+func (cache *DPoPNonceCache) Update(target *url.URL, resp *http.Response) {
+	if nonce := resp.Header.Get("DPoP-Nonce"); nonce != "" {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		cache.nonces[nonceOrigin(target)] = nonce
+	}
+}
+
+// isDPoPNonceChallenge prüft, ob der Server die Anfrage wegen einer fehlenden oder alten nonce abgelehnt hat:
+// der Token-Endpunkt mit 400 und use_dpop_nonce im Body, der Resource Server mit 401 und use_dpop_nonce
+// im WWW-Authenticate Header. Der Body bleibt für den Aufrufer lesbar.
+func isDPoPNonceChallenge(resp *http.Response) bool {
+	if resp.Header.Get("DPoP-Nonce") == "" {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		for _, challenge := range resp.Header.Values("WWW-Authenticate") {
+			if strings.Contains(challenge, `error="`+ErrorUseDPoPNonce+`"`) {
+				return true
+			}
+		}
+	case http.StatusBadRequest:
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		var oauthErr OAuthError
+		return json.Unmarshal(body, &oauthErr) == nil && oauthErr.Code == ErrorUseDPoPNonce
+	}
+	return false
+}
+
+// doWithDPoP sendet die Anfrage von newRequest mit dem HTTP-Client. Ist key gesetzt, bekommt sie einen
+// DPoP Proof (mit accessToken für den Resource Server). Verlangt der Server eine neue nonce, wird die
+// Anfrage genau einmal mit der nonce wiederholt. newRequest muss bei jedem Aufruf eine neue Anfrage bauen.
+func doWithDPoP(newRequest func() (*http.Request, error), key *DPoPKey, accessToken string) (*http.Response, error) {
+	for retried := false; ; retried = true {
+		req, err := newRequest()
+		if err != nil {
+			return nil, fmt.Errorf("creating request: %v", err)
+		}
+		if key != nil {
+			proof, err := key.Proof(req.Method, req.URL.String(), accessToken, DPoPNonces.Get(req.URL))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("DPoP", proof)
+		}
+
+		// Sendet die Anfrage mit dem TLS-CLient
+		resp, err := Client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("making request: %v", err)
+		}
+		if key == nil {
+			return resp, nil
+		}
+		DPoPNonces.Update(req.URL, resp)
+		if retried || !isDPoPNonceChallenge(resp) {
+			return resp, nil
+		}
+		resp.Body.Close()
+	}
+}
+
+// setAuthorization setzt den Access Token im Authorization Header: mit dem Schema DPoP, wenn der Token
+// an einen Schlüssel gebunden ist (token_type DPoP), sonst als Bearer Token.
+func setAuthorization(req *http.Request, token OAuthToken) {
+	if strings.EqualFold(token.TokenType, "DPoP") {
+		req.Header.Set("Authorization", "DPoP "+token.AccessToken)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+}
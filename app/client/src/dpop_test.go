@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"jwk"
+	"net/http"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ##############################################################################################
+// Tests für DPoP Proofs und die nonce der Server
+// ##############################################################################################
+
+// verifyDPoPProof prüft einen Proof mit dem Schlüssel aus seinem jwk Header, wie es ein Server tut
+func verifyDPoPProof(t *testing.T, proof string) (jwt.MapClaims, string) {
+	t.Helper()
+	claims := jwt.MapClaims{}
+	var publicJwk jwk.Key
+	token, err := jwt.ParseWithClaims(proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["typ"] != "dpop+jwt" {
+			t.Errorf("Unexpected typ %v", token.Header["typ"])
+		}
+		raw, _ := json.Marshal(token.Header["jwk"])
+		json.Unmarshal(raw, &publicJwk)
+		var fields map[string]interface{}
+		json.Unmarshal(raw, &fields)
+		if _, private := fields["d"]; private {
+			t.Errorf("Proof must not contain the private key")
+		}
+		return publicJwk.PublicKey()
+	}, jwt.WithValidMethods([]string{"ES256"}))
+	if err != nil || !token.Valid {
+		t.Fatalf("Invalid DPoP proof: %v", err)
+	}
+	return claims, publicJwk.Thumbprint()
+}
+
+func TestDPoPKeyEncode(t *testing.T) {
+	key, err := NewDPoPKey()
+	if err != nil {
+		t.Fatalf("Failed to create dpop key: %v", err)
+	}
+	encoded, err := key.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode dpop key: %v", err)
+	}
+	parsed, err := ParseDPoPKey(encoded)
+	if err != nil {
+		t.Fatalf("Failed to parse dpop key: %v", err)
+	}
+	if parsed.Thumbprint() != key.Thumbprint() {
+		t.Errorf("Expected the same key after encoding")
+	}
+}
+
+func TestDPoPNonceRetry(t *testing.T) {
+	key, err := NewDPoPKey()
+	if err != nil {
+		t.Fatalf("Failed to create dpop key: %v", err)
+	}
+
+	var tokenEndpoint string
+	var nonces []interface{}
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		claims, thumbprint := verifyDPoPProof(t, r.Header.Get("DPoP"))
+		if claims["htm"] != "POST" || claims["htu"] != tokenEndpoint || claims["ath"] != nil {
+			t.Errorf("Unexpected proof claims %v", claims)
+		}
+		if thumbprint != key.Thumbprint() {
+			t.Errorf("Expected a proof of the session key")
+		}
+		nonces = append(nonces, claims["nonce"])
+
+		// Der Provider verlangt eine nonce
+		w.Header().Set("DPoP-Nonce", "server-nonce")
+		if claims["nonce"] != "server-nonce" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(OAuthError{Code: ErrorUseDPoPNonce})
+			return
+		}
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: "bound", TokenType: "DPoP"})
+	})
+	defer server.Close()
+	tokenEndpoint = Provider.Metadata().TokenEndpoint
+	DPoPNonces = NewDPoPNonceCache()
+
+	token, err := consumeRefreshToken("refresh", key)
+	if err != nil || token.AccessToken != "bound" {
+		t.Fatalf("Expected refresh after the nonce challenge, got %v, %v", token, err)
+	}
+	if len(nonces) != 2 || nonces[0] != nil || nonces[1] != "server-nonce" {
+		t.Errorf("Expected one retry with the nonce, got %v", nonces)
+	}
+
+	// Die nonce wird gemerkt und gleich beim nächsten Mal gesendet
+	nonces = nil
+	if _, err := consumeRefreshToken("refresh", key); err != nil {
+		t.Fatalf("Expected refresh, got %v", err)
+	}
+	if len(nonces) != 1 {
+		t.Errorf("Expected one request with the cached nonce, got %v", nonces)
+	}
+}
+
+func TestDPoPResourceRequest(t *testing.T) {
+	key, err := NewDPoPKey()
+	if err != nil {
+		t.Fatalf("Failed to create dpop key: %v", err)
+	}
+	encoded, _ := key.Encode()
+	session := &SessionTokenData{Token: OAuthToken{AccessToken: "bound", TokenType: "DPoP"}, DPoPKey: encoded}
+
+	var target string
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "DPoP bound" {
+			t.Errorf("Expected the DPoP scheme, got %q", r.Header.Get("Authorization"))
+		}
+		claims, thumbprint := verifyDPoPProof(t, r.Header.Get("DPoP"))
+		sum := sha256.Sum256([]byte("bound"))
+		if claims["htm"] != "GET" || claims["htu"] != target || claims["ath"] != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			t.Errorf("Unexpected proof claims %v", claims)
+		}
+		if thumbprint != key.Thumbprint() {
+			t.Errorf("Expected a proof of the session key")
+		}
+		w.Write([]byte("[]"))
+	})
+	defer server.Close()
+	target = Provider.Metadata().TokenEndpoint
+
+	resp, err := doResourceRequest("GET", target+"?limit=1", nil, session)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected resource request, got %v, %v", resp, err)
+	}
+	resp.Body.Close()
+
+	// Ein Bearer Token wird ohne Proof gesendet
+	session = &SessionTokenData{Token: OAuthToken{AccessToken: "bearer", TokenType: "Bearer"}}
+	server.Config.Handler.(*http.ServeMux).HandleFunc("/bearer/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer bearer" || r.Header.Get("DPoP") != "" {
+			t.Errorf("Expected a plain bearer token, got %v", r.Header)
+		}
+	})
+	resp, err = doResourceRequest("GET", server.URL+"/bearer/", nil, session)
+	if err != nil {
+		t.Fatalf("Expected resource request, got %v", err)
+	}
+	resp.Body.Close()
+}
@@ -2,7 +2,19 @@ module client
 
 go 1.21
 
+require (
+	config v0.0.0
+	jwk v0.0.0
+	github.com/golang-jwt/jwt/v4 v4.5.0
+	github.com/lib/pq v1.10.9
+)
+
 require (
 	golang.org/x/crypto v0.24.0 // indirect
 	golang.org/x/net v0.26.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace config => ../../config
+
+replace jwk => ../../jwk
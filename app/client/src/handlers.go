@@ -2,7 +2,7 @@ package main
 
 import (
 	"encoding/json"
-	"io/ioutil"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
@@ -30,7 +30,12 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 	codeVerifier := generateCodeVerifier()
 	codeChallenge := generateCodeChallenge(codeVerifier)
 	state := generateState()
-	LoginStates.AddState(state, codeVerifier)
+	nonce := generateNonce()
+	LoginStates.AddLoginState(state, LoginState{
+		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
+		Issuer:       Provider.Metadata().Issuer,
+	})
 	params := url.Values{}
 	params.Add("client_id", ClientId)
 
@@ -42,15 +47,33 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 	params.Add("redirect_uri", RedirectUrl)
 	params.Add("response_type", "code")
 
+	// "query" ist der Standard für den Code Flow und wird nicht mitgesendet (siehe response-mode.go)
+	if ResponseMode != ResponseModeQuery {
+		params.Add("response_mode", ResponseMode)
+	}
+
 	// Der notes Scope wird in den Access Token eingebettet: In Authentik ist eine "Resource-Id" 
 	// festgelegt: Im JWT sieht das so aus: "notes": "<Id>". Der Resource Server verifiziert das dann
 	// "offline_access" bedeutet, das Authentik einen refresh Token mitsendet
-	params.Add("scope", "notes offline_access")
+	// "openid profile email" fordert einen ID Token mit Name und E-Mail des Benutzers an
+	params.Add("scope", "openid profile email notes offline_access")
+
+	// Mit dem Resource Indicator (RFC 8707) wird der Access Token auf die notes-Api beschränkt (aud)
+	addResource(params)
 
 	// Der state Parameter ist noch eine Erweiterrung des Authorization Code FLows
 	params.Add("state", state)
 
-	authUrlWithParams := AuthUrl + "?" + params.Encode()
+	// Die nonce wird vom Provider in den ID Token übernommen und beim Callback geprüft
+	params.Add("nonce", nonce)
+
+	// Mit PAR gehen die Parameter direkt an den Provider, nicht über den Browser (siehe par.go)
+	authUrlWithParams, err := authorizationUrl(params)
+	if err != nil {
+		log.Printf("Error creating authorization request: %v\n", err)
+		renderError(w, http.StatusBadGateway, "Login failed", err)
+		return
+	}
 	http.Redirect(w, r, authUrlWithParams, http.StatusTemporaryRedirect)
 }
 
@@ -60,79 +83,162 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 // ##############################################################################################
 
 func handleCallback(w http.ResponseWriter, r *http.Request) {
-	state := r.FormValue("state")
-	if !LoginStates.Contains(state) {
-		log.Printf("invalid oauth state: '%s'", state)
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	// Die Parameter kommen je nach response_mode aus der URL, dem POST-Body oder dem JARM JWT (siehe response-mode.go)
+	params, status, err := callbackParams(w, r)
+	if err != nil {
+		log.Printf("Invalid authorization response: %v\n", err)
+		renderError(w, status, "Login failed", nil)
 		return
 	}
-	codeVerifier := LoginStates.Retrieve(state)
 
-	code := r.FormValue("code")
+	// Der state wird in einem Schritt geprüft und verbraucht: bei zwei gleichzeitigen Callbacks (auch über
+	// mehrere Instanzen mit geteiltem Speicher) bekommt nur einer den Login-State, der andere wird abgelehnt
+	state := params.Get("state")
+	loginState, ok := LoginStates.RetrieveLoginState(state)
+	if !ok {
+		log.Printf("invalid oauth state: '%s'", state)
+		renderError(w, http.StatusBadRequest, "Login attempt expired or invalid", nil)
+		return
+	}
 
-	// Nutzt den Authorization Code um einen Access Token abzufragen
-	params := url.Values{}
-	params.Add("grant_type", "authorization_code")
-	params.Add("code", code)
-	params.Add("redirect_uri", RedirectUrl)
-	params.Add("client_id", ClientId)
-	params.Add("client_secret", ClientSecret)
-	params.Add("code_verifier", codeVerifier)
+	// Die Antwort muss vom Provider kommen, an den der Login gesendet wurde (Schutz vor Mix-Up, RFC 9207).
+	// Das gilt auch für Fehler, der Code wird erst danach eingelöst
+	if err := checkResponseIssuer(params, loginState); err != nil {
+		log.Printf("Rejected authorization response: %v\n", err)
+		renderError(w, http.StatusBadRequest, "Login failed", nil)
+		return
+	}
 
-	req, err := http.NewRequest("POST", TokenUrl, strings.NewReader(params.Encode()))
-	if err != nil {
-		log.Printf("Error creating request: %v\n", err)
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	// Der Authorization Server kann den Login mit einem Fehler beenden, z.B. access_denied
+	// (https://datatracker.ietf.org/doc/html/rfc6749#section-4.1.2.1)
+	if oauthErr := callbackError(params); oauthErr != nil {
+		log.Printf("Authorization failed: %v\n", oauthErr)
+		renderError(w, http.StatusBadRequest, "Login failed", oauthErr)
 		return
 	}
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// Mit DPoP bekommt die Session ein eigenes Schlüsselpaar, an das der Provider die Tokens bindet
+	var dpopKey *DPoPKey
+	if DPoPMode != DPoPOff {
+		if dpopKey, err = NewDPoPKey(); err != nil {
+			log.Printf("Error creating dpop key: %v\n", err)
+			renderError(w, http.StatusInternalServerError, "Login failed", nil)
+			return
+		}
+	}
 
-	// Sendet die Anfrage mit dem TLS-CLient
-	resp, err := Client.Do(req)
+	// Nutzt den Authorization Code um einen Access Token abzufragen
+	tokenResponse, err := exchangeCode(params.Get("code"), loginState.CodeVerifier, dpopKey)
 	if err != nil {
-		log.Printf("Error sending request: %v\n", err)
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		log.Printf("Error exchanging authorization code: %v\n", err)
+		renderError(w, http.StatusBadGateway, "Login failed", err)
 		return
 	}
-	defer resp.Body.Close()
+	if dpopKey != nil && !strings.EqualFold(tokenResponse.TokenType, "DPoP") {
+		if DPoPMode == DPoPRequired {
+			log.Printf("Provider issued a %s token, but DPoP is required\n", tokenResponse.TokenType)
+			renderError(w, http.StatusBadGateway, "Login failed", nil)
+			return
+		}
+		// Der Provider kann kein DPoP, der Token ist ein gewöhnlicher Bearer Token
+		dpopKey = nil
+	}
 
-	// Liest und verarbeitet die Antwort
-	body, err := ioutil.ReadAll(resp.Body)
+	// Der ID Token wird validiert, erst danach gilt der Benutzer als angemeldet
+	claims, err := validateIdToken(tokenResponse.IdToken, loginState.Nonce)
 	if err != nil {
-		log.Printf("Error reading response body: %v\n", err)
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		log.Printf("Error validating id token: %v\n", err)
+		renderError(w, http.StatusBadGateway, "Login failed", nil)
+		return
+	}
+
+	//Eine neue Session wird registriert
+	sessionToken, _ := Sessions.AddSession(*tokenResponse, claims.User(), dpopKey)
+	session, stored := Sessions.GetData(sessionToken)
+	if !stored {
+		log.Printf("Error storing session\n")
+		renderError(w, http.StatusInternalServerError, "Login failed", nil)
 		return
 	}
 
+	//den Sessiontoken als Cookie setzen, er läuft mit der Session ab
+	setSessionCookie(w, sessionToken, session.SessionExpiresAt)
+
+	//zurück zur Anwendung, nun mit der angemeldeten Session
+	http.Redirect(w, r, ApplicationUrl, http.StatusFound)
+}
+
+// setSessionCookie setzt das Session-Cookie, es läuft zusammen mit der Session im Store ab (SessionExpiresAt).
+// Der Browser sendet den Ablauf nicht mit, deshalb wird das Cookie bei jeder Anfrage mit Session erneuert:
+// so verlängert ein rotierter Refresh Token (auch aus dem RefreshScheduler) das Cookie ebenfalls.
+func setSessionCookie(w http.ResponseWriter, sessionToken string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "GoNotesSessionToken",
+		Value:    sessionToken,
+		Path:     "/",
+		Domain:   CookieDomain,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   true,
+	})
+}
+
+// checkResponseIssuer prüft den iss Parameter der Antwort an den Callback (https://datatracker.ietf.org/doc/html/rfc9207#section-2.4).
+// Ist er gesetzt, muss er genau dem Issuer aus dem Login-State entsprechen. Fehlen darf er nur, wenn der
+// Provider authorization_response_iss_parameter_supported nicht ankündigt.
+func checkResponseIssuer(values url.Values, loginState LoginState) error {
+	// Ohne Issuer im Login-State gibt es nichts zu vergleichen, die Antwort wird abgelehnt
+	expected := loginState.Issuer
+	if expected == "" {
+		return fmt.Errorf("login state has no issuer")
+	}
+
+	if _, present := values["iss"]; !present {
+		if Provider.Metadata().AuthorizationResponseIssParameterSupported {
+			return fmt.Errorf("iss parameter missing, expected %q", expected)
+		}
+		return nil
+	}
+	if iss := values.Get("iss"); iss != expected {
+		return fmt.Errorf("unexpected issuer %q, expected %q", iss, expected)
+	}
+	return nil
+}
+
+// exchangeCode tauscht den Authorization Code (mit dem PKCE Code-Verifier) am Token-Endpunkt gegen die Tokens.
+// Mit dpopKey wird ein DPoP Proof mitgesendet. Lehnt der Authorization Server ab, wird ein *OAuthError zurückgegeben.
+func exchangeCode(code string, codeVerifier string, dpopKey *DPoPKey) (*OAuthToken, error) {
+	params := url.Values{}
+	params.Add("grant_type", "authorization_code")
+	params.Add("code", code)
+	params.Add("redirect_uri", RedirectUrl)
+	params.Add("code_verifier", codeVerifier)
+	addResource(params)
+
+	// Sendet die Anfrage mit Client-Authentifizierung (siehe client-auth.go)
+	resp, err := postClientRequest(tokenEndpoint(), params, dpopKey)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error: %s\n", body)
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return nil, readOAuthError(resp)
 	}
 
 	// Parse die JSON-Antwort
 	var tokenResponse OAuthToken
-	if err := json.Unmarshal(body, &tokenResponse); err != nil {
-		log.Printf("Error parsing response: %v\n", err)
-		return
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return nil, fmt.Errorf("decoding response: %v", err)
 	}
+	return &tokenResponse, nil
+}
 
-	//Eine neue Session wird registriert
-	sessionToken, _ := Sessions.AddToken(tokenResponse)
-	
-	//den Sessiontoken als Cookie setzen
-	http.SetCookie(w, &http.Cookie{
-        Name:     "GoNotesSessionToken",
-        Value:    sessionToken,
-        Path:     "/",
-        Domain:   "37.27.87.77",
-        Expires:  time.Now().Add(24 * time.Hour),
-        HttpOnly: true,
-        Secure:   true,
-    })
-
-	//zurück zur Anwendung, nun mit der angemeldeten Session
-	http.Redirect(w, r, ApplicationUrl, http.StatusFound)
+// addResource fügt den Resource Indicator der notes-Api als resource Parameter hinzu, falls einer konfiguriert ist.
+func addResource(params url.Values) {
+	if ResourceIndicator != "" {
+		params.Set("resource", ResourceIndicator)
+	}
 }
 
 // ##############################################################################################
@@ -154,11 +260,8 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
     }
 	
 	// Überprüft die Gültigkeit des Sitzungstokens
-	sessionData, isValid := Sessions.GetData(sessionCookie.Value)
 	// Fehlerbehandlung basierend auf der HTTP-Methode, hier wird nur eine Nullzeiger-Dereferenzierung vermieden
-	if isValid {
-		Sessions.RefreshAccess(sessionCookie.Value)
-	}
+	sessionData, _ := Sessions.RefreshAccess(sessionCookie.Value)
 	token := sessionData.Token
 	csrfToken := sessionData.CSRFToken.Source
 	csrfTokenClaim := r.FormValue("csrf_token")
@@ -172,13 +275,22 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 	// Widerruft das Refresh-Token
 	err = revokeToken(token.RefreshToken)
 	if err != nil {
-		http.Error(w, "Token Revokation failed", http.StatusInternalServerError)
+		log.Printf("Error revoking token: %v\n", err)
+		renderError(w, http.StatusBadGateway, "Token Revokation failed", err)
 		return
 	}
 
-	// Entfernt das Sitzungstoken und leitet zur Abmeldeseite von Authentik weiter
+	// Entfernt das Sitzungstoken und leitet zur Abmeldeseite von Authentik weiter.
+	// Ohne end_session_endpoint in den Metadaten geht es zurück zur Anwendung
 	Sessions.RemoveToken(sessionCookie.Value)
-	http.Redirect(w, r, LogoutUrl, http.StatusSeeOther)
+	logoutUrl := Provider.Metadata().EndSessionEndpoint
+	if logoutUrl == "" {
+		logoutUrl = ApplicationUrl
+	}
+	if logoutUrl == "" {
+		logoutUrl = "/"
+	}
+	http.Redirect(w, r, logoutUrl, http.StatusSeeOther)
 }
 
 // ##############################################################################################
@@ -188,25 +300,20 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 
 func revokeToken(token string) error {
 	params := url.Values{}
-	params.Add("client_id", ClientId)
-	params.Add("client_secret", ClientSecret)
 	params.Add("token", token)
 
-	// Erstellt eine neue HTTP-POST-Anfrage zum Widerruf des Tokens
-	req, err := http.NewRequest("POST", RevokeUrl, strings.NewReader(params.Encode()))
+	// Sendet die HTTP-POST-Anfrage zum Widerruf des Tokens mit Client-Authentifizierung
+	resp, err := postClientRequest(revocationEndpoint(), params, nil)
 	if err != nil {
-		log.Printf("Error creating request: %v\n", err)
+		log.Printf("Error sending request: %v\n", err)
 		return err
 	}
+	defer resp.Body.Close()
 
-	// Setzt den entsprechenden Header
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	// Sendet die Anfrage mit dem TLS-CLient
-	_, err = Client.Do(req)
-	if err != nil {
-		log.Printf("Error sending request: %v\n", err)
-		return err
+	// Auch unbekannte oder schon widerrufene Tokens werden mit 200 beantwortet
+	// (https://datatracker.ietf.org/doc/html/rfc7009#section-2.2)
+	if resp.StatusCode != http.StatusOK {
+		return readOAuthError(resp)
 	}
 	return nil
 }
@@ -242,12 +349,11 @@ func notesHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-	sessionData, isValid := Sessions.GetData(sessionCookie.Value)
+	// Wenn nötig einen Neuen Access Token anfragen (mit dem Refresh Token), danach gelten die neuen Daten
+	sessionData, isValid := Sessions.RefreshAccess(sessionCookie.Value)
 	if isValid {
-		// Wenn nötig einen Neuen Access Token anfragen (mit dem Refresh Token)
-		Sessions.RefreshAccess(sessionCookie.Value)
+		setSessionCookie(w, sessionCookie.Value, sessionData.SessionExpiresAt)
 	}
-	token := sessionData.Token
 	csrfToken := sessionData.CSRFToken.Source
 	csrfTokenClaim := r.FormValue("csrf_token")
 
@@ -261,7 +367,7 @@ func notesHandler(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, "oa/login", http.StatusTemporaryRedirect)
 			return
 		}
-		notes, err := fetchNotes(token)
+		notes, err := fetchNotes(sessionData)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -282,8 +388,9 @@ func notesHandler(w http.ResponseWriter, r *http.Request) {
 		page := NotesPage{
 			Notes:     notes,
 			CSRFToken: csrfToken,
+			User:      sessionData.User,
 		}
-		if err := tmpl.Execute(w, page); err != nil {
+		if err := tmpl.ExecuteTemplate(w, "layout.html", page); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 
@@ -313,7 +420,7 @@ func notesHandler(w http.ResponseWriter, r *http.Request) {
 			Done: done,
 		}
 
-		if err := createNote(note, token); err != nil {
+		if err := createNote(note, sessionData); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -335,44 +442,132 @@ func parseDate(date string) (time.Time, error) {
 
 // ##############################################################################################
 // deleteHandler verarbeitet Anfragen an den /notes/delete-Endpunkt.
-// Es unterstützt die DELETE-Methode und implementiert die Logik zum Löschen einer Notiz anhand ihres Textinhalts.
+// Es implementiert die Logik zum Löschen einer Notiz anhand ihrer Id.
 // ##############################################################################################
 
 func deleteHandler(w http.ResponseWriter, r *http.Request) {
-	sessionCookie, err := r.Cookie("GoNotesSessionToken")
-    if err != nil {
-        if err == http.ErrNoCookie {
-            http.Redirect(w, r, "oa/login", http.StatusTemporaryRedirect)
-            return
-        }
-        w.WriteHeader(http.StatusBadRequest)
-        return
-    }
+	session, ok := formSession(w, r)
+	if !ok {
+		return
+	}
+	id := r.FormValue("id")
+	if id == "" {
+		http.Error(w, "Missing 'id' parameter", http.StatusBadRequest)
+		return
+	}
 
-	sessionData, isValid := Sessions.GetData(sessionCookie.Value)
-	if !isValid { 
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if err := deleteNoteById(id, session); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	csrfToken := sessionData.CSRFToken.Source
-	csrfTokenClaim := r.FormValue("csrf_token")
-	if csrfToken != csrfTokenClaim {
-		http.Error(w, "Possible CSRF attack detected", http.StatusInternalServerError)
+
+	http.Redirect(w, r, "/notes", http.StatusSeeOther)
+}
+
+// ##############################################################################################
+// editHandler verarbeitet POST-Anfragen an den /notes/edit-Endpunkt.
+// Text, Datum und Status der Notiz mit der übergebenen Id werden mit PATCH auf dem Resource Server geändert.
+// ##############################################################################################
+
+func editHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	// Wenn nötig einen Neuen Access Token anfragen (mit dem Refresh Token)
-	Sessions.RefreshAccess(sessionCookie.Value)
-	token := sessionData.Token
+	session, ok := formSession(w, r)
+	if !ok {
+		return
+	}
+	id := r.FormValue("id")
+	if id == "" {
+		http.Error(w, "Missing 'id' parameter", http.StatusBadRequest)
+		return
+	}
+
+	// Aus dem Form-Post die Änderungen erstellen
 	text := r.FormValue("text")
-	if text == "" {
-		http.Error(w, "Missing 'text' query parameter", http.StatusBadRequest)
+	done := r.FormValue("done") == "true"
+	parsedDate, err := parseDate(r.FormValue("date"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	patch := NotePatch{
+		Date: &parsedDate,
+		Text: &text,
+		Done: &done,
+	}
+
+	if err := updateNote(id, patch, session); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/notes", http.StatusSeeOther)
+}
+
+// ##############################################################################################
+// toggleHandler verarbeitet POST-Anfragen an den /notes/toggle-Endpunkt.
+// Setzt den Status der Notiz auf den übergebenen Wert (das Formular enthält bereits den umgekehrten Status).
+// ##############################################################################################
+
+func toggleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := formSession(w, r)
+	if !ok {
+		return
+	}
+	id := r.FormValue("id")
+	if id == "" {
+		http.Error(w, "Missing 'id' parameter", http.StatusBadRequest)
 		return
 	}
 
-	if err := deleteNoteByText(text, token); err != nil {
+	done := r.FormValue("done") == "true"
+	if err := updateNote(id, NotePatch{Done: &done}, session); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	http.Redirect(w, r, "/notes", http.StatusSeeOther)
-}
\ No newline at end of file
+}
+
+// ##############################################################################################
+// formSession prüft Session-Cookie und CSRF-Token eines Formulars und gibt die Daten der Session (mit Access Token) zurück.
+// Wenn nötig wird vorher ein neuer Access Token angefragt (mit dem Refresh Token).
+// Bei einem Fehler ist die Antwort bereits geschrieben und ok ist false.
+// ##############################################################################################
+
+func formSession(w http.ResponseWriter, r *http.Request) (*SessionTokenData, bool) {
+	sessionCookie, err := r.Cookie("GoNotesSessionToken")
+	if err != nil {
+		if err == http.ErrNoCookie {
+			http.Redirect(w, r, "oa/login", http.StatusTemporaryRedirect)
+			return nil, false
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+
+	sessionData, isValid := Sessions.GetData(sessionCookie.Value)
+	if !isValid {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	if sessionData.CSRFToken.Source != r.FormValue("csrf_token") {
+		http.Error(w, "Possible CSRF attack detected", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	// Wenn nötig einen Neuen Access Token anfragen, der neue Token wird direkt benutzt
+	sessionData, isValid = Sessions.RefreshAccess(sessionCookie.Value)
+	if !isValid {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	setSessionCookie(w, sessionCookie.Value, sessionData.SessionExpiresAt)
+	return sessionData, true
+}
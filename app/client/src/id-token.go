@@ -0,0 +1,148 @@
+// ##############################################################################################
+// Hier wird der ID Token aus der Token-Antwort validiert
+// (https://openid.net/specs/openid-connect-core-1_0.html#IDTokenValidation).
+// Aus den geprüften Claims wird der angemeldete Benutzer für die Session übernommen.
+// ##############################################################################################
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// zur Darstellung der Claims eines ID Tokens. Neben den registrierten Claims werden nonce, azp
+// und die Profil-Claims (name, preferred_username, email) gelesen.
+type IdTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce,omitempty"`
+	AuthorizedParty   string `json:"azp,omitempty"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+}
+
+// zur Darstellung des angemeldeten Benutzers. Wird in der Session gespeichert und in die Templates eingebettet.
+type UserInfo struct {
+	Subject           string
+	Name              string
+	PreferredUsername string
+	Email             string
+}
+
+// User gibt die für die Anzeige relevanten Claims als UserInfo zurück.
+func (claims *IdTokenClaims) User() UserInfo {
+	return UserInfo{
+		Subject:           claims.Subject,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+		Email:             claims.Email,
+	}
+}
+
+// DisplayName gibt einen lesbaren Namen des Benutzers zurück: den Namen, sonst den Benutzernamen, sonst das Subject.
+func (user UserInfo) DisplayName() string {
+	if user.Name != "" {
+		return user.Name
+	}
+	if user.PreferredUsername != "" {
+		return user.PreferredUsername
+	}
+	return user.Subject
+}
+
+// validateIdToken überprüft Signatur und Claims eines ID Tokens aus dem Login im Browser.
+// Der nonce Claim muss expectedNonce aus dem Login-State entsprechen. Eine leere expectedNonce (z.B. ein
+// fehlender Login-State) führt immer zur Ablehnung, die Prüfung wird nie übersprungen.
+func validateIdToken(source string, expectedNonce string) (*IdTokenClaims, error) {
+	if expectedNonce == "" {
+		return nil, fmt.Errorf("no nonce to check the id token against")
+	}
+	claims, err := parseIdToken(source)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != expectedNonce {
+		return nil, fmt.Errorf("id token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// validateIdTokenWithoutNonce überprüft einen ID Token, zu dem keine nonce gesendet wurde: beim Refresh
+// (https://openid.net/specs/openid-connect-core-1_0.html#RefreshTokenResponse) und beim Device Grant.
+// Für den Login im Browser ist immer validateIdToken zu benutzen.
+func validateIdTokenWithoutNonce(source string) (*IdTokenClaims, error) {
+	return parseIdToken(source)
+}
+
+// parseIdToken überprüft alles außer der nonce: Die Signatur wird mit dem passenden Schlüssel aus dem JWKS
+// des Providers geprüft, danach iss, aud, azp, exp und iat.
+func parseIdToken(source string) (*IdTokenClaims, error) {
+	metadata := Provider.Metadata()
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(idTokenSigningAlgorithms(metadata)),
+		// Die zeitlichen Claims werden unten mit Toleranz (IdTokenLeeway) geprüft
+		jwt.WithoutClaimsValidation(),
+	)
+
+	var claims IdTokenClaims
+	_, err := parser.ParseWithClaims(source, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return IdTokenKeys.Key(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+
+	if claims.Issuer != metadata.Issuer {
+		return nil, fmt.Errorf("id token issuer mismatch: '%s'", claims.Issuer)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("id token has no subject")
+	}
+	if !claims.VerifyAudience(ClientId, true) {
+		return nil, fmt.Errorf("id token audience does not contain the client id")
+	}
+
+	// Bei mehreren Audiences muss azp gesetzt sein, und wenn azp gesetzt ist, muss es der Client sein
+	if len(claims.Audience) > 1 && claims.AuthorizedParty == "" {
+		return nil, fmt.Errorf("id token with multiple audiences has no azp claim")
+	}
+	if claims.AuthorizedParty != "" && claims.AuthorizedParty != ClientId {
+		return nil, fmt.Errorf("id token azp mismatch: '%s'", claims.AuthorizedParty)
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt == nil || now.After(claims.ExpiresAt.Add(IdTokenLeeway)) {
+		return nil, fmt.Errorf("id token is expired")
+	}
+	if claims.IssuedAt == nil || claims.IssuedAt.After(now.Add(IdTokenLeeway)) {
+		return nil, fmt.Errorf("id token has an invalid iat claim")
+	}
+
+	return &claims, nil
+}
+
+// idTokenSigningAlgorithms gibt die erlaubten Signatur-Algorithmen für ID Tokens zurück.
+func idTokenSigningAlgorithms(metadata ProviderMetadata) []string {
+	return signingAlgorithms(metadata.IdTokenSigningAlgValuesSupported)
+}
+
+// signingAlgorithms gibt die erlaubten Signatur-Algorithmen zurück.
+// Verwendet werden die vom Provider angekündigten Algorithmen, "none" ist nie erlaubt.
+// Ohne Angabe gilt RS256, der Standard nach OpenID Connect.
+func signingAlgorithms(announced []string) []string {
+	var algorithms []string
+	for _, alg := range announced {
+		if alg != "none" {
+			algorithms = append(algorithms, alg)
+		}
+	}
+	if len(algorithms) == 0 {
+		return []string{"RS256"}
+	}
+	return algorithms
+}
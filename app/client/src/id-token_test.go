@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"jwk"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Startet einen Mock Provider mit Discovery und JWKS, der mit dem gegebenen Schlüssel signiert
+func mockIdTokenProvider(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	issuer := server.URL + "/"
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ProviderMetadata{
+			Issuer:                issuer,
+			AuthorizationEndpoint: issuer + "authorize/",
+			TokenEndpoint:         issuer + "token/",
+			JwksUri:               issuer + "jwks/",
+		})
+	})
+	mux.HandleFunc("/jwks/", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwk.Set{Keys: []jwk.Key{{
+			Kty: "RSA",
+			Kid: "test-key",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}}})
+	})
+
+	Client = *server.Client()
+	Provider = NewProviderDiscovery(issuer, time.Hour)
+	if err := Provider.Load(); err != nil {
+		t.Fatalf("Failed to load mock provider metadata: %v", err)
+	}
+	IdTokenKeys = NewKeySetCache(0)
+	return server
+}
+
+// Hilfsfunktion um signierte Mock ID Tokens zu erstellen
+func createMockIdToken(t *testing.T, key *rsa.PrivateKey, kid string, modify func(claims *IdTokenClaims)) string {
+	now := time.Now()
+	claims := IdTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Provider.Metadata().Issuer,
+			Subject:   "test-sub",
+			Audience:  jwt.ClaimStrings{ClientId},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		Nonce: "test-nonce",
+		Name:  "Test User",
+	}
+	if modify != nil {
+		modify(&claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed
+}
+
+// ##############################################################################################
+// Tests für validateIdToken
+// ##############################################################################################
+
+func TestValidateIdToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	server := mockIdTokenProvider(t, key)
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		token      string
+		nonce      string
+		expectedOk bool
+	}{
+		{
+			name:       "valid token",
+			token:      createMockIdToken(t, key, "test-key", nil),
+			nonce:      "test-nonce",
+			expectedOk: true,
+		},
+		{
+			name:       "wrong nonce",
+			token:      createMockIdToken(t, key, "test-key", nil),
+			nonce:      "other-nonce",
+			expectedOk: false,
+		},
+		{
+			name:       "no expected nonce",
+			token:      createMockIdToken(t, key, "test-key", nil),
+			nonce:      "",
+			expectedOk: false,
+		},
+		{
+			name:       "wrong signature",
+			token:      createMockIdToken(t, otherKey, "test-key", nil),
+			nonce:      "test-nonce",
+			expectedOk: false,
+		},
+		{
+			name:       "unknown kid",
+			token:      createMockIdToken(t, key, "other-key", nil),
+			nonce:      "test-nonce",
+			expectedOk: false,
+		},
+		{
+			name: "wrong issuer",
+			token: createMockIdToken(t, key, "test-key", func(claims *IdTokenClaims) {
+				claims.Issuer = "https://evil.example/"
+			}),
+			nonce:      "test-nonce",
+			expectedOk: false,
+		},
+		{
+			name: "wrong audience",
+			token: createMockIdToken(t, key, "test-key", func(claims *IdTokenClaims) {
+				claims.Audience = jwt.ClaimStrings{"other-client"}
+			}),
+			nonce:      "test-nonce",
+			expectedOk: false,
+		},
+		{
+			name: "multiple audiences without azp",
+			token: createMockIdToken(t, key, "test-key", func(claims *IdTokenClaims) {
+				claims.Audience = jwt.ClaimStrings{ClientId, "other-client"}
+			}),
+			nonce:      "test-nonce",
+			expectedOk: false,
+		},
+		{
+			name: "expired",
+			token: createMockIdToken(t, key, "test-key", func(claims *IdTokenClaims) {
+				claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+			}),
+			nonce:      "test-nonce",
+			expectedOk: false,
+		},
+		{
+			name: "issued in the future",
+			token: createMockIdToken(t, key, "test-key", func(claims *IdTokenClaims) {
+				claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+			}),
+			nonce:      "test-nonce",
+			expectedOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := validateIdToken(tt.token, tt.nonce)
+			if (err == nil) != tt.expectedOk {
+				t.Errorf("validateIdToken() error = %v, expectedOk %v", err, tt.expectedOk)
+			}
+			if err == nil && claims.User().DisplayName() != "Test User" {
+				t.Errorf("Expected DisplayName 'Test User', got %v", claims.User().DisplayName())
+			}
+		})
+	}
+}
+
+func TestValidateIdTokenWithoutNonce(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	server := mockIdTokenProvider(t, key)
+	defer server.Close()
+
+	// Beim Refresh und beim Device Grant wird keine nonce geprüft, alle anderen Claims schon
+	if _, err := validateIdTokenWithoutNonce(createMockIdToken(t, key, "test-key", nil)); err != nil {
+		t.Errorf("Expected a valid id token, got %v", err)
+	}
+	expired := createMockIdToken(t, key, "test-key", func(claims *IdTokenClaims) {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	})
+	if _, err := validateIdTokenWithoutNonce(expired); err == nil {
+		t.Errorf("Expected an error for an expired id token")
+	}
+}
+
+func TestKeySetCacheRefetchWithoutLock(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	server := mockIdTokenProvider(t, key)
+	defer server.Close()
+
+	// Ab der zweiten Anfrage an die jwks_uri antwortet der Provider erst nach release
+	var requests int32
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	slow := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 1 {
+			arrived <- struct{}{}
+			<-release
+		}
+		json.NewEncoder(w).Encode(jwk.Set{Keys: []jwk.Key{{
+			Kty: "RSA",
+			Kid: "test-key",
+			N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}}})
+	}))
+	defer slow.Close()
+	Provider.metadata.JwksUri = slow.URL
+	IdTokenKeys = NewKeySetCache(time.Minute)
+
+	if _, err := IdTokenKeys.Key("test-key"); err != nil {
+		t.Fatalf("Expected the key, got %v", err)
+	}
+	IdTokenKeys.mu.Lock()
+	IdTokenKeys.fetchedAt = time.Now().Add(-2 * time.Minute)
+	IdTokenKeys.mu.Unlock()
+
+	// Mehrere unbekannte kids lösen zusammen eine einzige Anfrage aus
+	done := make(chan struct{})
+	for i := 0; i < 5; i++ {
+		go func() {
+			IdTokenKeys.Key("made-up")
+			done <- struct{}{}
+		}()
+	}
+	<-arrived
+
+	// Während der Provider langsam antwortet, werden bekannte kids ohne Warten gefunden
+	found := make(chan error, 1)
+	go func() {
+		_, err := IdTokenKeys.Key("test-key")
+		found <- err
+	}()
+	select {
+	case err := <-found:
+		if err != nil {
+			t.Errorf("Expected the cached key, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected the cached key while the jwks is being fetched")
+	}
+
+	close(release)
+	for i := 0; i < 5; i++ {
+		<-done
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("Expected 2 jwks requests, got %d", n)
+	}
+}
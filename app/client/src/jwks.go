@@ -0,0 +1,143 @@
+// ##############################################################################################
+// Hier wird das JSON Web Key Set (https://datatracker.ietf.org/doc/html/rfc7517) des Providers geladen.
+// Mit den Schlüsseln werden die Signaturen der ID Tokens überprüft.
+// ##############################################################################################
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"jwk"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// zur Verwaltung der zwischengespeicherten Schlüssel des Providers. Die Schlüssel werden nach ihrer
+// Key-Id (kid) abgelegt. Taucht eine unbekannte kid auf, wird das Set neu geladen, aber höchstens
+// einmal pro minRefetch, damit gefälschte Tokens den Provider nicht mit Anfragen fluten können.
+// Geladen wird ohne den Mutex, es läuft immer höchstens ein Ladevorgang (loading), weitere Aufrufer warten darauf.
+type KeySetCache struct {
+	keys       map[string]interface{}
+	fetchedAt  time.Time
+	loading    *keySetLoad
+	mu         sync.Mutex
+	minRefetch time.Duration
+}
+
+// keySetLoad ist ein laufender Ladevorgang, done wird nach dem Laden geschlossen.
+type keySetLoad struct {
+	done chan struct{}
+	err  error
+}
+
+// NewKeySetCache erstellt einen leeren KeySetCache.
+func NewKeySetCache(minRefetch time.Duration) *KeySetCache {
+	return &KeySetCache{
+		keys:       make(map[string]interface{}),
+		minRefetch: minRefetch,
+	}
+}
+
+// Key gibt den Schlüssel mit der gegebenen kid zurück. Ist er nicht im Cache, wird das Key Set neu geladen.
+// Hat das Key Set nur einen Schlüssel, darf die kid im Token fehlen.
+func (c *KeySetCache) Key(kid string) (interface{}, error) {
+	c.mu.Lock()
+	key, ok := c.lookup(kid)
+	limited := !c.fetchedAt.IsZero() && time.Since(c.fetchedAt) < c.minRefetch && c.loading == nil
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if limited {
+		return nil, fmt.Errorf("unknown key id '%s'", kid)
+	}
+
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	key, ok = c.lookup(kid)
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id '%s'", kid)
+}
+
+// refresh lädt das Key Set neu und ersetzt den Cache. Läuft schon ein Ladevorgang, wird auf dessen Ergebnis
+// gewartet statt eine zweite Anfrage zu senden. Der Mutex wird während der Anfrage an den Provider nicht gehalten.
+func (c *KeySetCache) refresh() error {
+	c.mu.Lock()
+	if load := c.loading; load != nil {
+		c.mu.Unlock()
+		<-load.done
+		return load.err
+	}
+	load := &keySetLoad{done: make(chan struct{})}
+	c.loading = load
+	c.fetchedAt = time.Now()
+	c.mu.Unlock()
+
+	keys, err := fetchKeySet()
+
+	c.mu.Lock()
+	if err == nil {
+		c.keys = keys
+	}
+	c.loading = nil
+	c.mu.Unlock()
+
+	load.err = err
+	close(load.done)
+	return err
+}
+
+// lookup sucht den Schlüssel im Cache. Der Aufrufer muss den Mutex halten.
+func (c *KeySetCache) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// fetchKeySet lädt das Key Set von der jwks_uri aus den Provider-Metadaten.
+// Schlüssel, die nicht zur Signatur bestimmt sind oder nicht gelesen werden können, werden übersprungen.
+func fetchKeySet() (map[string]interface{}, error) {
+	jwksUri := Provider.Metadata().JwksUri
+	if jwksUri == "" {
+		return nil, fmt.Errorf("provider metadata has no jwks_uri")
+	}
+
+	resp, err := Client.Get(jwksUri)
+	if err != nil {
+		return nil, fmt.Errorf("fetching jwks: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad response status from jwks_uri: %s", resp.Status)
+	}
+
+	var keySet jwk.Set
+	if err := json.NewDecoder(resp.Body).Decode(&keySet); err != nil {
+		return nil, fmt.Errorf("decoding jwks: %v", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, webKey := range keySet.Keys {
+		if webKey.Use != "" && webKey.Use != "sig" {
+			continue
+		}
+		key, err := webKey.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[webKey.Kid] = key
+	}
+	return keys, nil
+}
@@ -6,7 +6,10 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"os"
 	"log"
 	"net/http"
 	"time"
@@ -23,23 +26,70 @@ type OAuthToken struct {
 	ExpiresIn    int    `json:"expires_in"`
 	RefreshToken string `json:"refresh_token,omitempty"`
 	IdToken      string `json:"id_token,omitempty"`
+
+	// Nicht standardisiert, aber von einigen Providern gesendet: Lebensdauer des Refresh Tokens in Sekunden
+	RefreshExpiresIn int `json:"refresh_expires_in,omitempty"`
 }
 
 var (
 	ClientId         string = "HQrsYMEXnEksFCMQ1klvt85RIT3Jt8KHSd5uArn0"
-	ClientSecret     string = "DGChUUu9f82vhhfaBmEA1KrSPFYwuopTXktkvuOhnuyJt1yyXtjVe8bX9ondAylY0eavD5NxNYQ5z1tPt3pV6k5EAsq3t7x6gYzUT326Ktt8aZfRX92NAOFlGBpGOdOj"
 
-	//Endpoints von Authentik
-	AuthUrl          string = "https://37.27.87.77:9443/application/o/authorize/"
-	TokenUrl         string = "https://37.27.87.77:9443/application/o/token/"
-	RevokeUrl        string = "https://37.27.87.77:9443/application/o/revoke/"
-	LogoutUrl        string = "https://37.27.87.77:9443/application/o/notes/end-session/"
+	// Das Client Secret steht nicht im Quellcode: es kommt aus CLIENT_SECRET (und CLIENT_SECRET_NEXT während
+	// einer Rotation) oder aus ClientSecretFile, die alle ClientSecretReload auf Änderungen geprüft wird
+	ClientSecret       string = ""
+	ClientSecretNext   string = ""
+	ClientSecretFile   string = ""
+	ClientSecretReload time.Duration = 10 * time.Second
+	Secrets            *ClientSecrets = NewClientSecrets("", "")
+
+	// Client-Authentifizierung am Token- und Revoke-Endpunkt: client_secret_post, client_secret_basic oder
+	// private_key_jwt (Client Assertion mit ClientAssertionKey, kurzlebig, der öffentliche Schlüssel unter /oa/jwks),
+	// tls_client_auth oder self_signed_tls_client_auth (das Zertifikat CertFile des HTTP-Clients, RFC 8705)
+	TokenEndpointAuthMethod string = ClientSecretPost
+	ClientAssertionKey      string = "../certs/server.key"
+	ClientAssertionLifetime time.Duration = 1 * time.Minute
+	ClientAuth              ClientAuthenticator = NewSecretAuthenticator(ClientSecretPost, Secrets)
+
+	// DPoP (RFC 9449): "off" (Bearer Tokens), "optional" (DPoP, wenn der Provider es kann) oder "required".
+	// Die zuletzt gesendeten DPoP-Nonces der Server werden pro Server gemerkt
+	DPoPMode                string = DPoPOff
+	DPoPNonces              *DPoPNonceCache = NewDPoPNonceCache()
+
+	// Pushed Authorization Requests (RFC 9126): "off" (klassische Weiterleitung), "preferred" (PAR, wenn der
+	// Provider einen PAR-Endpunkt anbietet) oder "required" (ohne PAR-Endpunkt schlägt der Login fehl)
+	ParMode                 string = ParOff
+
+	// response_mode der Antwort an den Callback: "query" (Standard), "form_post" (per POST, nicht im Browserverlauf)
+	// oder signiert mit JARM ("query.jwt", "form_post.jwt")
+	ResponseMode            string = ResponseModeQuery
+
+	// Issuer von Authentik. Alle Endpunkte (authorize, token, revoke, end-session) werden
+	// beim Start per Discovery aus den Metadaten des Issuers geladen
+	Issuer           string = "https://37.27.87.77:9443/application/o/notes/"
+
+	// Die Metadaten des Providers werden zwischengespeichert und in diesem Intervall neu geladen
+	Provider         *ProviderDiscovery = NewProviderDiscovery(Issuer, 1 * time.Hour)
+
+	// Die Schlüssel des Providers zur Prüfung der ID Tokens. Bei unbekannter kid wird
+	// das Key Set höchstens alle 10 Sekunden neu geladen
+	IdTokenKeys      *KeySetCache = NewKeySetCache(10 * time.Second)
+
+	// Toleranz für abweichende Uhren bei der Prüfung von exp und iat im ID Token
+	IdTokenLeeway    time.Duration = 30 * time.Second
 
 	// Die URLs des Clients und des Resource Servers
 	ResourceServer   string = "https://37.27.87.77:8080/notes"
 	ApplicationUrl   string = "https://37.27.87.77:8089/notes"
 	RedirectUrl      string = "https://37.27.87.77:8089/oa/callback"
 
+	// Resource Indicator der notes-Api (RFC 8707). Er wird beim Login, beim Code-Tausch und beim Refresh als
+	// resource Parameter gesendet, damit der Provider die aud des Access Tokens darauf beschränkt (leer: kein Parameter)
+	ResourceIndicator string = "https://37.27.87.77:8080/notes"
+
+	// Port des Clients und Domain des Session Cookies
+	Port             string = "8089"
+	CookieDomain     string = "37.27.87.77"
+
 	// Zertifikate: Der Resource-Server und der Client Benutzen jeweils CertFile und KeyFile
 	// CaCertFile ist der public key von Authentik
 	CertFile         string = "../certs/server.crt"
@@ -47,22 +97,133 @@ var (
 	CaCertFile       string = "../certs/certificate.crt"
 	AuthentikCA      string = "../certs/authentik_default_certificate.crt"
 
+	// Der Access Token wird so lange vor seinem Ablauf (expires_in bzw. exp) erneuert.
+	// Gibt der Provider keine Lebensdauer an, wird DefaultAccessTokenLifetime angenommen
+	AccessTokenMargin          time.Duration = 30 * time.Second
+	DefaultAccessTokenLifetime time.Duration = 5 * time.Minute
+
+	// Eine Session lebt so lange wie ihr Refresh Token. Authentik sendet dessen Lebensdauer nicht,
+	// deswegen muss hier die Einstellung "Refresh Token validity" des Providers stehen
+	RefreshTokenLifetime       time.Duration = 30 * 24 * time.Hour
+
+	// Demo-Modus: erzwingt kurze Lebensdauern, um den Refresh mit Refresh Tokens zu zeigen
+	DemoMode                   bool = false
+	DemoAccessTokenLifetime    time.Duration = 10 * time.Second
+	DemoSessionLifetime        time.Duration = 10 * time.Minute
+
+	// Optionaler Refresh im Hintergrund: alle RefreshInterval werden Access Tokens erneuert, die in weniger
+	// als RefreshLead (plus bis zu RefreshJitter) ablaufen. Höchstens RefreshConcurrency gleichzeitig,
+	// Sessions ohne Anfrage seit RefreshIdleAfter werden übersprungen
+	RefreshSchedulerEnabled    bool = false
+	RefreshInterval            time.Duration = 5 * time.Second
+	RefreshLead                time.Duration = 15 * time.Second
+	RefreshJitter              time.Duration = 5 * time.Second
+	RefreshIdleAfter           time.Duration = 15 * time.Minute
+	RefreshConcurrency         int = 4
+
+	// Lebensdauer der laufenden Authorization Flows (state-Parameter)
+	LoginStateTTL    time.Duration = 1 * time.Minute
+
+	// Verschlüsselte Token-Datei des Unterbefehls "notes" (siehe token-file.go). Der Schlüssel kommt aus TokenKey
+	// (base64) oder aus TokenKeyFile, die beim ersten Login mit einem zufälligen Schlüssel angelegt wird.
+	// TokenKeyFile liegt im Konfigurationsverzeichnis des Benutzers, nicht neben der Token-Datei
+	TokenFilePath    string = "./data/notes-cli.token"
+	TokenKeyFile     string = defaultTokenKeyFile()
+	TokenKey         string = ""
+
+	// Speicher für Sessions und Login-States: "memory" (Standard, geht bei einem Neustart verloren),
+	// "file" (Snapshot-Dateien in StoreDirectory) oder "postgres" (teilt sich die Datenbank mit dem
+	// Resource Server, für mehrere Instanzen des Clients)
+	SessionStorage   string = "memory"
+	StoreDirectory   string = "./data"
+	StoreDbUser      string = "client_user"
+	StoreDbPassword  string = "123"
+	StoreDbName      string = "postgres"
+	StoreDbHost      string = "postgres" // Aus dem Docker Compose Netz
+	StoreDbPort      string = "5432"
+	StoreDbSchema    string = "client_user"
+
 	// Instanz eines SessionTokenStore zum Speichern von Session-Tokens.
-	// Die TTL (Time To Live) im SessionTokenStore sollte entwas kürzer als die
-	// Lebensdauer der AccessTokens sein.
-	Sessions SessionTokenStore = *NewSessionTokenStore()
+	Sessions *SessionTokenStore = NewSessionTokenStore()
 
 	// Instanz eines LoginStateStore zum Verwalten von laufenden Authorization Flows
 	// In der Menge werden die state-Parameter des authorization-Flows gespeichert
-	LoginStates LoginStateStore = *NewLoginStateStore(1 * time.Minute)
+	LoginStates *LoginStateStore = NewLoginStateStore(LoginStateTTL)
+
+	// Der Refresh im Hintergrund (nil, wenn nicht aktiviert)
+	Refresher *RefreshScheduler
 
 	// HTTP-Client für Anfragen an den Resource Server und Authentik.
 	Client http.Client
 )
 
 func main() {
+	// Konfiguration aus Datei, Umgebung und Flags (siehe config.go)
+	args, err := loadConfig(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	Provider = NewProviderDiscovery(Issuer, 1 * time.Hour)
+
+	// Client Secrets aus der Datei (mit Neuladen bei Änderungen) oder aus der Umgebung
+	if ClientSecretFile != "" {
+		secrets, err := LoadClientSecretsFile(ClientSecretFile)
+		if err != nil {
+			log.Fatalf("Failed to load client secrets: %v", err)
+		}
+		Secrets = secrets
+		go Secrets.Watch(ClientSecretReload, nil)
+	} else {
+		Secrets = NewClientSecrets(ClientSecret, ClientSecretNext)
+	}
+	auth, err := newClientAuthenticator(TokenEndpointAuthMethod, Secrets, ClientAssertionKey)
+	if err != nil {
+		log.Fatalf("Failed to set up client authentication: %v", err)
+	}
+	ClientAuth = auth
+
 	InitHTTPClient()
 
+	// Lädt die Endpunkte des Providers. Ohne gültige Metadaten kann der Client nicht arbeiten
+	if err := Provider.Load(); err != nil {
+		log.Fatalf("Failed to discover provider metadata: %v", err)
+	}
+	go discoveryRefreshRoutine()
+	if !authMethodSupported(Provider.Metadata(), ClientAuth.Method()) {
+		log.Printf("Warning: provider does not announce token endpoint auth method %s\n", ClientAuth.Method())
+	}
+	if !responseModeSupported(Provider.Metadata(), ResponseMode) {
+		log.Printf("Warning: provider does not announce response mode %s\n", ResponseMode)
+	}
+	if Provider.Metadata().RequirePushedAuthorizationRequests && ParMode == ParOff {
+		log.Println("Warning: provider requires pushed authorization requests, but par_mode is off")
+	}
+
+	// Unterbefehl "notes login|list|add|done|delete|logout": Kommandozeile mit Device-Login, kein Server
+	if len(args) > 0 && args[0] == "notes" {
+		if err := runNotesCommand(args[1:]); err != nil {
+			log.Fatalf("Notes command failed: %v", err)
+		}
+		return
+	}
+
+	// Öffnet den konfigurierten Speicher für Sessions und Login-States und räumt ihn regelmäßig auf
+	sessions, loginStates, err := openStores(SessionStorage)
+	if err != nil {
+		log.Fatalf("Failed to open session storage: %v", err)
+	}
+	Sessions, LoginStates = sessions, loginStates
+	routinesInit()
+
+	if RefreshSchedulerEnabled {
+		Refresher = NewRefreshScheduler(Sessions, RefreshInterval, RefreshLead, RefreshJitter, RefreshIdleAfter, RefreshConcurrency)
+		go Refresher.Run(nil)
+		go refreshStatsRoutine()
+	}
+
 	// Richtet den HTTPS-Server ein, um statische Dateien aus dem Verzeichnis "../static" zu bedienen.
 	// Für die login Seite und die CSS Dateien 
 	http.Handle("/", http.FileServer(http.Dir("../static")))
@@ -71,15 +232,18 @@ func main() {
 	http.HandleFunc("/oa/login", handleLogin)
 	http.HandleFunc("/oa/callback", handleCallback)
 	http.HandleFunc("/oa/logout", handleLogout)
+	http.HandleFunc("/oa/jwks", clientJwksHandler)
 	http.HandleFunc("/notes", notesHandler)
 	http.HandleFunc("/notes/delete", deleteHandler)
+	http.HandleFunc("/notes/edit", editHandler)
+	http.HandleFunc("/notes/toggle", toggleHandler)
 	
 	
 	server := &http.Server{
-		Addr: ":8089",
+		Addr: ":" + Port,
 	}
 
-	fmt.Println("Started running on https://localhost:8089")
+	fmt.Println("Started running on https://localhost:" + Port)
 
 	// Startet den HTTPS-Server und verwendet die angegebenen Zertifikats- und Schlüsseldateien.
 	// Falls ein Fehler auftritt, wird dieser protokolliert und die Anwendung beendet.
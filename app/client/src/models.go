@@ -2,17 +2,27 @@ package main
 
 import "time"
 
-// zur Darstellung einer Notiz. Enthält das Erstellungsdatum, den Text, einen Boolean, 
+// zur Darstellung einer Notiz. Enthält die Id, das Erstellungsdatum, den Text, einen Boolean, 
 // der anzeigt, ob die Notiz erledigt ist, und den Besitzer der Notiz.
 type Note struct {
+	Id        string    `json:"id,omitempty"`
 	Date      time.Time `json:"date"`
 	Text      string    `json:"text"`
 	Done      bool      `json:"done"`
 	Owner     string    `json:"owner"`
 }
 
-// zur Darstellung einer Seite mit Notizen. Enthält eine Liste von Notizen und einen CSRF-Token zur Vermeidung von CSRF-Angriffen.
+// zur Darstellung einer Änderung an einer Notiz (PATCH). Nur gesetzte Felder werden geändert.
+type NotePatch struct {
+	Date      *time.Time `json:"date,omitempty"`
+	Text      *string    `json:"text,omitempty"`
+	Done      *bool      `json:"done,omitempty"`
+}
+
+// zur Darstellung einer Seite mit Notizen. Enthält eine Liste von Notizen, einen CSRF-Token zur Vermeidung von CSRF-Angriffen
+// und den angemeldeten Benutzer.
 type NotesPage struct {
 	Notes     []Note
 	CSRFToken string
+	User      UserInfo
 }
\ No newline at end of file
@@ -2,6 +2,7 @@
 // Hier wird der Resource Server abgerufen
 // Zur Authtorizierung wird ein Access-Token (JWT) verwendet, der von Authentik signiert ist
 // Der Scope "notes" ist hier relevant. Idealerweise ist der in den JWT eingebettet
+// Ist der Access Token an den DPoP-Schlüssel der Session gebunden, bekommt jede Anfrage einen Proof
 // ##############################################################################################
 
 package main
@@ -12,6 +13,7 @@ import (
 	"fmt"
 	"encoding/json"
 	"bytes"
+	"strings"
 )
 
 // ##############################################################################################
@@ -19,17 +21,9 @@ import (
 // Es sendet eine GET-Anfrage an den ResourceServer mit dem Access-Token (JWT) im Header.
 // ##############################################################################################
 
-func fetchNotes(token OAuthToken) ([]Note, error) {
-	// Baut die Anfrage
-	req, err := http.NewRequest("GET", ResourceServer, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", token.AccessToken)
-
-	// Sendet die Anfrage mit dem TLS-CLient
-	resp, err := Client.Do(req)
+func fetchNotes(session *SessionTokenData) ([]Note, error) {
+	// Baut und sendet die Anfrage
+	resp, err := doResourceRequest("GET", ResourceServer, nil, session)
 	if err != nil {
 		return nil, err
 	}
@@ -52,56 +46,111 @@ func fetchNotes(token OAuthToken) ([]Note, error) {
 // Es sendet eine POST-Anfrage mit den Notizdaten als JSON und dem Access-Token (JWT) im Header.
 // ##############################################################################################
 
-func createNote(note Note, token OAuthToken) error {
+func createNote(note Note, session *SessionTokenData) error {
 	// Kodiert die neue Notiz in JSON
 	jsonData, err := json.Marshal(note)
 	if err != nil {
 		return err
 	}
-	// Baut die Anfrage
-	req, err := http.NewRequest("POST", ResourceServer, bytes.NewBuffer(jsonData))
+	// Baut und sendet die Anfrage
+	resp, err := doResourceRequest("POST", ResourceServer, jsonData, session)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", token.AccessToken)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("failed to create note: %s", resp.Status)
+	}
+	return nil
+}
+
+// ##############################################################################################
+// deleteNoteById löscht eine Notiz anhand ihrer Id vom Ressource Server.
+// Es sendet eine DELETE-Anfrage an /notes/{id} mit dem Access-Token (JWT) im Header.
+// ##############################################################################################
 
-	// Sendet die Anfrage mit dem TLS-CLient
-	resp, err := Client.Do(req)
+func deleteNoteById(id string, session *SessionTokenData) error {
+	// Baut und sendet die Anfrage
+	resp, err := doResourceRequest(http.MethodDelete, fmt.Sprintf("%s/%s", ResourceServer, url.PathEscape(id)), nil, session)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("failed to create note: %s", resp.Status)
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to delete note: %s", resp.Status)
 	}
+
 	return nil
 }
 
 // ##############################################################################################
-// deleteNoteByText löscht eine Notiz anhand ihres Textinhalts vom Ressource Server.
-// Es sendet eine DELETE-Anfrage mit dem Text als Query-Parameter und dem Access-Token (JWT) im Header.
+// updateNote ändert eine Notiz auf dem Ressource Server.
+// Es sendet eine PATCH-Anfrage an /notes/{id} mit den geänderten Feldern als JSON und dem Access-Token (JWT) im Header.
 // ##############################################################################################
 
-func deleteNoteByText(text string, token OAuthToken) error {
-	// Baut die Angrage
-	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/delete?text=%s", ResourceServer, url.QueryEscape(text)), nil)
+func updateNote(id string, patch NotePatch, session *SessionTokenData) error {
+	// Kodiert die Änderungen in JSON
+	jsonData, err := json.Marshal(patch)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", token.AccessToken)
-
-	// Sendet die Anfrage mit dem TLS-CLient
-	resp, err := Client.Do(req)
+	// Baut und sendet die Anfrage
+	resp, err := doResourceRequest(http.MethodPatch, fmt.Sprintf("%s/%s", ResourceServer, url.PathEscape(id)), jsonData, session)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("failed to delete note: %s", resp.Status)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to update note: %s", resp.Status)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// ##############################################################################################
+// doResourceRequest sendet eine Anfrage mit dem JSON body an den Resource Server. Der Access Token der
+// Session steht im Authorization Header, bei DPoP-gebundenen Tokens mit Proof (siehe doWithDPoP).
+// ##############################################################################################
+
+func doResourceRequest(method string, target string, body []byte, session *SessionTokenData) (*http.Response, error) {
+	var dpopKey *DPoPKey
+	if strings.EqualFold(session.Token.TokenType, "DPoP") {
+		dpopKey = session.dpopKey()
+	}
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequest(method, target, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		setAuthorization(req, session.Token)
+		return req, nil
+	}
+	return doWithDPoP(newRequest, dpopKey, session.Token.AccessToken)
+}
+
+// ##############################################################################################
+// doServiceRequest sendet eine Anfrage wie doResourceRequest, aber mit dem Token des Clients selbst (Client
+// Credentials Grant, siehe client-credentials.go). Lehnt der Resource Server den Token mit 401 ab (z.B. widerrufen),
+// wird er verworfen und die Anfrage einmal mit einem neuen Token wiederholt.
+// ##############################################################################################
+
+func doServiceRequest(method string, target string, body []byte, provider *ClientCredentialsProvider) (*http.Response, error) {
+	session, err := provider.Session()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doResourceRequest(method, target, body, session)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	provider.Invalidate()
+	if session, err = provider.Session(); err != nil {
+		return nil, err
+	}
+	return doResourceRequest(method, target, body, session)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// ##############################################################################################
+// Tests für die Anfragen an den Resource Server und die Formulare der Notizen
+// ##############################################################################################
+
+// mockResourceServer startet einen Resource Server, dessen /notes-Routen von handler beantwortet werden
+func mockResourceServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewServer(handler)
+	previous, previousClient := ResourceServer, Client
+	t.Cleanup(func() { ResourceServer, Client = previous, previousClient })
+	ResourceServer = server.URL + "/notes"
+	Client = *server.Client()
+	return server
+}
+
+// addFormSession legt eine Session mit gültigem Access Token an und gibt Session- und CSRF-Token zurück
+func addFormSession(t *testing.T) (string, string) {
+	return Sessions.AddToken(OAuthToken{AccessToken: "access", TokenType: "Bearer", ExpiresIn: 300})
+}
+
+// postForm sendet ein Formular mit Session-Cookie an handler
+func postForm(handler http.HandlerFunc, method string, sessionToken string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/notes/form", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "GoNotesSessionToken", Value: sessionToken})
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestDeleteNoteById(t *testing.T) {
+	var requests []string
+	server := mockResourceServer(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Header.Get("Authorization") != "Bearer access" {
+			t.Errorf("Expected the access token, got %q", r.Header.Get("Authorization"))
+		}
+		if r.URL.Path == "/notes/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	defer server.Close()
+	sessionToken, csrfToken := addFormSession(t)
+
+	// Gelöscht wird über die Id, nicht mehr über den Text
+	rec := postForm(deleteHandler, http.MethodPost, sessionToken, url.Values{"csrf_token": {csrfToken}, "id": {"note-1"}})
+	if rec.Code != http.StatusSeeOther || len(requests) != 1 || requests[0] != "DELETE /notes/note-1" {
+		t.Errorf("Expected DELETE /notes/note-1 and a redirect, got %v %v", rec.Code, requests)
+	}
+
+	// Ohne Id oder mit falschem CSRF-Token wird nichts gesendet
+	if rec := postForm(deleteHandler, http.MethodPost, sessionToken, url.Values{"csrf_token": {csrfToken}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without id, got %v", rec.Code)
+	}
+	if rec := postForm(deleteHandler, http.MethodPost, sessionToken, url.Values{"csrf_token": {"wrong"}, "id": {"note-1"}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a csrf mismatch, got %v", rec.Code)
+	}
+	if len(requests) != 1 {
+		t.Errorf("Expected no further requests, got %v", requests)
+	}
+
+	// Eine andere Antwort als 204 ist ein Fehler
+	session, _ := Sessions.GetData(sessionToken)
+	if err := deleteNoteById("missing", session); err == nil {
+		t.Errorf("Expected an error for status 404")
+	}
+}
+
+func TestEditAndToggleNote(t *testing.T) {
+	var methods []string
+	var bodies []map[string]interface{}
+	server := mockResourceServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		methods = append(methods, r.Method+" "+r.URL.Path)
+		bodies = append(bodies, body)
+		w.WriteHeader(http.StatusOK)
+	})
+	defer server.Close()
+	sessionToken, csrfToken := addFormSession(t)
+
+	// Bearbeiten sendet alle drei Felder mit PATCH
+	rec := postForm(editHandler, http.MethodPost, sessionToken, url.Values{"csrf_token": {csrfToken}, "id": {"note-1"}, "text": {"Buy bread"}, "date": {"2024-05-01"}, "done": {"true"}})
+	if rec.Code != http.StatusSeeOther || len(methods) != 1 || methods[0] != "PATCH /notes/note-1" {
+		t.Fatalf("Expected PATCH /notes/note-1 and a redirect, got %v %v", rec.Code, methods)
+	}
+	if len(bodies[0]) != 3 || bodies[0]["text"] != "Buy bread" || bodies[0]["done"] != true || !strings.HasPrefix(bodies[0]["date"].(string), "2024-05-01") {
+		t.Errorf("Expected date, text and done, got %v", bodies[0])
+	}
+
+	// Umschalten sendet nur den neuen Status
+	rec = postForm(toggleHandler, http.MethodPost, sessionToken, url.Values{"csrf_token": {csrfToken}, "id": {"note-1"}, "done": {"false"}})
+	if rec.Code != http.StatusSeeOther || len(methods) != 2 || methods[1] != "PATCH /notes/note-1" {
+		t.Fatalf("Expected PATCH /notes/note-1 and a redirect, got %v %v", rec.Code, methods)
+	}
+	if len(bodies[1]) != 1 || bodies[1]["done"] != false {
+		t.Errorf("Expected only done, got %v", bodies[1])
+	}
+
+	// Fehlerfälle: keine Anfrage an den Resource Server
+	for name, handler := range map[string]http.HandlerFunc{"edit": editHandler, "toggle": toggleHandler} {
+		if rec := postForm(handler, http.MethodGet, sessionToken, url.Values{"csrf_token": {csrfToken}, "id": {"note-1"}}); rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s: expected status 405 for GET, got %v", name, rec.Code)
+		}
+		if rec := postForm(handler, http.MethodPost, sessionToken, url.Values{"csrf_token": {"wrong"}, "id": {"note-1"}, "date": {"2024-05-01"}}); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status 401 for a csrf mismatch, got %v", name, rec.Code)
+		}
+		if rec := postForm(handler, http.MethodPost, sessionToken, url.Values{"csrf_token": {csrfToken}, "date": {"2024-05-01"}}); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400 without id, got %v", name, rec.Code)
+		}
+	}
+	if rec := postForm(editHandler, http.MethodPost, sessionToken, url.Values{"csrf_token": {csrfToken}, "id": {"note-1"}, "date": {"01.05.2024"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid date, got %v", rec.Code)
+	}
+	if len(methods) != 2 {
+		t.Errorf("Expected no further requests, got %v", methods)
+	}
+}
+
+func TestUpdateNoteError(t *testing.T) {
+	server := mockResourceServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	defer server.Close()
+	sessionToken, csrfToken := addFormSession(t)
+
+	// Lehnt der Resource Server ab, gibt es keinen Redirect
+	rec := postForm(toggleHandler, http.MethodPost, sessionToken, url.Values{"csrf_token": {csrfToken}, "id": {"note-1"}, "done": {"true"}})
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %v", rec.Code)
+	}
+}
@@ -0,0 +1,222 @@
+// ##############################################################################################
+// Hier steht der Unterbefehl "notes" für die Kommandozeile. Die Anmeldung läuft über den Device
+// Authorization Grant (siehe device-flow.go): der Benutzer bestätigt den Login im Browser eines
+// beliebigen Geräts, die Kommandozeile braucht keinen Callback. Die Tokens liegen verschlüsselt in
+// der Token-Datei (siehe token-file.go), die Notizen werden mit denselben Funktionen wie im Web-Client
+// abgerufen (siehe notes-adapter.go).
+// ##############################################################################################
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const notesUsage = "usage: notes login|list|add <text>|done <id>|delete <id>|logout"
+
+// Scope des Device-Logins, wie beim Login im Browser (siehe handleLogin)
+const deviceLoginScope = "openid profile email notes offline_access"
+
+// ##############################################################################################
+// runNotesCommand führt den Unterbefehl "notes login|list|add <text>|done <id>|delete <id>|logout" aus.
+// Ohne gespeicherte oder mit abgelaufener Session startet jeder Befehl zuerst den Device-Login.
+// ##############################################################################################
+
+func runNotesCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(notesUsage)
+	}
+	key, err := loadTokenKey(TokenKey, TokenKeyFile)
+	if err != nil {
+		return err
+	}
+	tokenFile, err := NewTokenFile(TokenFilePath, key)
+	if err != nil {
+		return err
+	}
+	out := os.Stdout
+
+	switch args[0] {
+	case "login":
+		session, err := deviceLogin(tokenFile, out)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Logged in as %s\n", session.User.DisplayName())
+		return nil
+	case "logout":
+		return cliLogout(tokenFile, out)
+	case "list":
+		session, err := cliSession(tokenFile, out)
+		if err != nil {
+			return err
+		}
+		notes, err := fetchNotes(session)
+		if err != nil {
+			return err
+		}
+		printNotes(out, notes)
+		return nil
+	case "add":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: notes add <text>")
+		}
+		session, err := cliSession(tokenFile, out)
+		if err != nil {
+			return err
+		}
+		note := Note{Date: time.Now(), Text: strings.Join(args[1:], " ")}
+		return createNote(note, session)
+	case "done":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: notes done <id>")
+		}
+		session, err := cliSession(tokenFile, out)
+		if err != nil {
+			return err
+		}
+		done := true
+		return updateNote(args[1], NotePatch{Done: &done}, session)
+	case "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: notes delete <id>")
+		}
+		session, err := cliSession(tokenFile, out)
+		if err != nil {
+			return err
+		}
+		return deleteNoteById(args[1], session)
+	default:
+		return fmt.Errorf("unknown notes command: %s (%s)", args[0], notesUsage)
+	}
+}
+
+// cliSession gibt die gespeicherte Session zurück und erneuert vorher den Access Token, falls er abgelaufen ist.
+// Gibt es keine Session, ist sie abgelaufen oder lehnt der Provider den Refresh Token ab, wird neu angemeldet.
+func cliSession(tokenFile *TokenFile, out io.Writer) (*SessionTokenData, error) {
+	session, exists, err := tokenFile.Load()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !exists || now.After(session.SessionExpiresAt) {
+		return deviceLogin(tokenFile, out)
+	}
+	// Ohne Refresh Token (offline_access nicht gewährt) geht es nach dem Ablauf nur mit einem neuen Login weiter
+	if session.Token.RefreshToken == "" && now.After(session.AccessTokenExpiresAt) {
+		return deviceLogin(tokenFile, out)
+	}
+
+	expiresAt := session.AccessTokenExpiresAt
+	if err := session.refreshAccessTokenIfPossible(); err != nil {
+		// Nur ein endgültig ungültiger Refresh Token führt zum neuen Login, alles andere kann vorübergehend sein
+		if isInvalidGrant(err) {
+			return deviceLogin(tokenFile, out)
+		}
+		return nil, err
+	}
+	if !session.AccessTokenExpiresAt.Equal(expiresAt) {
+		session.LastActiveAt = time.Now()
+		if err := tokenFile.Save(*session); err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// ##############################################################################################
+// deviceLogin meldet den Benutzer mit dem Device Authorization Grant an: user_code und verification_uri
+// werden auf out ausgegeben, dann wird gewartet, bis der Benutzer den Login bestätigt hat. Der ID Token
+// wird validiert und die Session in der Token-Datei gespeichert.
+// ##############################################################################################
+
+func deviceLogin(tokenFile *TokenFile, out io.Writer) (*SessionTokenData, error) {
+	// Mit DPoP bekommt die Session ein eigenes Schlüsselpaar, an das der Provider die Tokens bindet
+	var dpopKey *DPoPKey
+	var err error
+	if DPoPMode != DPoPOff {
+		if dpopKey, err = NewDPoPKey(); err != nil {
+			return nil, fmt.Errorf("creating dpop key: %v", err)
+		}
+	}
+
+	device, err := startDeviceAuthorization(deviceLoginScope)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "To sign in, open %s and enter the code %s\n", device.VerificationUri, device.UserCode)
+	if device.VerificationUriComplete != "" {
+		fmt.Fprintf(out, "or open %s\n", device.VerificationUriComplete)
+	}
+
+	token, err := pollDeviceToken(device, dpopKey, time.Sleep)
+	if err != nil {
+		return nil, err
+	}
+	if dpopKey != nil && !strings.EqualFold(token.TokenType, "DPoP") {
+		if DPoPMode == DPoPRequired {
+			return nil, fmt.Errorf("provider issued a %s token, but DPoP is required", token.TokenType)
+		}
+		// Der Provider kann kein DPoP, der Token ist ein gewöhnlicher Bearer Token
+		dpopKey = nil
+	}
+
+	// Beim Device Grant gibt es keine nonce, der ID Token wird sonst genauso geprüft wie beim Callback
+	claims, err := validateIdTokenWithoutNonce(token.IdToken)
+	if err != nil {
+		return nil, fmt.Errorf("validating id token: %v", err)
+	}
+
+	now := time.Now()
+	session := SessionTokenData{
+		Token:                *token,
+		User:                 claims.User(),
+		AccessTokenExpiresAt: accessTokenExpiry(*token, now),
+		SessionExpiresAt:     sessionExpiry(*token, now, RefreshTokenLifetime),
+		LastActiveAt:         now,
+	}
+	if dpopKey != nil {
+		if session.DPoPKey, err = dpopKey.Encode(); err != nil {
+			return nil, fmt.Errorf("encoding dpop key: %v", err)
+		}
+	}
+	if err := tokenFile.Save(session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// cliLogout widerruft den Refresh Token (und damit beim Provider auch die Access Tokens) und löscht die Token-Datei.
+// Schlägt der Widerruf fehl oder hat der Provider keinen Revoke-Endpunkt, wird die Datei trotzdem gelöscht.
+func cliLogout(tokenFile *TokenFile, out io.Writer) error {
+	session, exists, err := tokenFile.Load()
+	if err == nil && exists && revocationEndpoint() != "" {
+		token := session.Token.RefreshToken
+		if token == "" {
+			token = session.Token.AccessToken
+		}
+		if err := revokeToken(token); err != nil {
+			fmt.Fprintf(out, "Warning: revoking the token failed: %v\n", err)
+		}
+	}
+	if err := tokenFile.Remove(); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "Logged out")
+	return nil
+}
+
+// printNotes gibt die Notizen als Liste aus: erledigt, Id, Datum und Text.
+func printNotes(out io.Writer, notes []Note) {
+	for _, note := range notes {
+		done := " "
+		if note.Done {
+			done = "x"
+		}
+		fmt.Fprintf(out, "[%s] %s  %s  %s\n", done, note.Id, note.Date.Format("2006-01-02"), note.Text)
+	}
+}
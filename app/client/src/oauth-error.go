@@ -0,0 +1,118 @@
+// ##############################################################################################
+// Hier stehen die Fehler des Authorization Servers (https://datatracker.ietf.org/doc/html/rfc6749#section-5.2
+// für Token-, Refresh- und Revoke-Endpunkt, https://datatracker.ietf.org/doc/html/rfc6749#section-4.1.2.1
+// für den Callback) und die Fehlerseite, die dem Benutzer angezeigt wird.
+// ##############################################################################################
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// Fehlercodes aus RFC 6749 (Abschnitte 4.1.2.1 und 5.2)
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorInvalidClient           = "invalid_client"
+	ErrorInvalidGrant            = "invalid_grant"
+	ErrorUnauthorizedClient      = "unauthorized_client"
+	ErrorUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorAccessDenied            = "access_denied"
+	ErrorServerError             = "server_error"
+	ErrorTemporarilyUnavailable  = "temporarily_unavailable"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+)
+
+// OAuthError ist ein Fehler, den der Authorization Server gemeldet hat.
+// StatusCode ist der HTTP-Status der Antwort (0 beim Callback).
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	Uri         string `json:"error_uri,omitempty"`
+	StatusCode  int    `json:"-"`
+}
+
+func (err *OAuthError) Error() string {
+	if err.Description != "" {
+		return fmt.Sprintf("oauth error %s: %s", err.Code, err.Description)
+	}
+	return fmt.Sprintf("oauth error %s", err.Code)
+}
+
+// isInvalidGrant prüft, ob der Authorization Server den Grant (z.B. den Refresh Token) endgültig
+// abgelehnt hat. Nur dann ist eine Session nicht mehr zu retten, alle anderen Fehler können vorübergehend sein.
+func isInvalidGrant(err error) bool {
+	var oauthErr *OAuthError
+	return errors.As(err, &oauthErr) && oauthErr.Code == ErrorInvalidGrant
+}
+
+// readOAuthError liest den Fehler aus einer Antwort mit einem Status außer 200. Enthält der Body kein
+// Fehlerobjekt nach RFC 6749, wird nur der Status gemeldet. Der Body wird nie ausgegeben, er kann Tokens enthalten.
+func readOAuthError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(resp.Body)
+	var oauthErr OAuthError
+	if err := json.Unmarshal(body, &oauthErr); err != nil || oauthErr.Code == "" {
+		return fmt.Errorf("bad response status: %s", resp.Status)
+	}
+	oauthErr.StatusCode = resp.StatusCode
+	return &oauthErr
+}
+
+// callbackError liest den Fehler aus den Parametern der Weiterleitung zum Callback (nil, wenn es keinen gibt).
+func callbackError(values url.Values) *OAuthError {
+	code := values.Get("error")
+	if code == "" {
+		return nil
+	}
+	return &OAuthError{
+		Code:        code,
+		Description: values.Get("error_description"),
+		Uri:         values.Get("error_uri"),
+	}
+}
+
+// ##############################################################################################
+// Fehlerseite
+// ##############################################################################################
+
+// zur Darstellung eines Fehlers auf der Fehlerseite, mit Link um es erneut zu versuchen
+type ErrorPage struct {
+	Title       string
+	Code        string
+	Description string
+	RetryUrl    string
+}
+
+// renderError zeigt dem Benutzer die Fehlerseite mit einem Link zum erneuten Login.
+// Bei einem OAuthError werden Code und Beschreibung des Authorization Servers angezeigt.
+func renderError(w http.ResponseWriter, status int, title string, err error) {
+	page := ErrorPage{
+		Title:    title,
+		RetryUrl: "/oa/login",
+	}
+	var oauthErr *OAuthError
+	if errors.As(err, &oauthErr) {
+		page.Code = oauthErr.Code
+		page.Description = oauthErr.Description
+	}
+
+	tmpl, tmplErr := template.ParseFiles("../templates/error.html")
+	if tmplErr != nil {
+		log.Printf("Error parsing error template: %v\n", tmplErr)
+		http.Error(w, title, status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if tmplErr := tmpl.Execute(w, page); tmplErr != nil {
+		log.Printf("Error rendering error page: %v\n", tmplErr)
+	}
+}
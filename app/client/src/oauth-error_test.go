@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ##############################################################################################
+// Tests für die Fehlerbehandlung im Callback
+// ##############################################################################################
+
+func TestCallbackErrors(t *testing.T) {
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid_grant", "error_description": "code expired"}`))
+	})
+	defer server.Close()
+	LoginStates = NewLoginStateStore(time.Minute)
+
+	tests := []struct {
+		name           string
+		query          url.Values
+		validState     bool
+		expectedStatus int
+		expectedText   string
+	}{
+		{"unknown state", url.Values{"code": {"abc"}}, false, http.StatusBadRequest, "expired or invalid"},
+		{"error from authorization server", url.Values{"error": {"access_denied"}, "error_description": {"user <b>denied</b>"}}, true, http.StatusBadRequest, "user &lt;b&gt;denied&lt;/b&gt;"},
+		{"token endpoint rejects code", url.Values{"code": {"abc"}}, true, http.StatusBadGateway, "invalid_grant"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := generateState()
+			if tt.validState {
+				LoginStates.AddLoginState(state, LoginState{CodeVerifier: "verifier", Nonce: "nonce", Issuer: Provider.Metadata().Issuer})
+			}
+			tt.query.Set("state", state)
+
+			rec := httptest.NewRecorder()
+			handleCallback(rec, httptest.NewRequest(http.MethodGet, "/oa/callback?"+tt.query.Encode(), nil))
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %v, got %v", tt.expectedStatus, rec.Code)
+			}
+			body := rec.Body.String()
+			if !strings.Contains(body, tt.expectedText) || !strings.Contains(body, `href="/oa/login"`) {
+				t.Errorf("Expected error page containing %q and a retry link, got %s", tt.expectedText, body)
+			}
+			if rec.Header().Get("Set-Cookie") != "" {
+				t.Errorf("Expected no session cookie on error")
+			}
+			// Der state ist auch nach einem Fehler verbraucht
+			if LoginStates.Contains(state) {
+				t.Errorf("Expected state to be consumed")
+			}
+		})
+	}
+}
+
+func TestCallbackIssuer(t *testing.T) {
+	var redeemed bool
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		redeemed = true
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+	})
+	defer server.Close()
+	LoginStates = NewLoginStateStore(time.Minute)
+	issuer := Provider.Metadata().Issuer
+
+	tests := []struct {
+		name           string
+		issSupported   bool
+		query          url.Values
+		expectedRedeem bool
+		expectedStatus int
+	}{
+		{"matching issuer", true, url.Values{"code": {"abc"}, "iss": {issuer}}, true, http.StatusBadGateway},
+		{"other issuer", true, url.Values{"code": {"abc"}, "iss": {"https://attacker.example/"}}, false, http.StatusBadRequest},
+		{"other issuer without announcement", false, url.Values{"code": {"abc"}, "iss": {"https://attacker.example/"}}, false, http.StatusBadRequest},
+		{"missing issuer", true, url.Values{"code": {"abc"}}, false, http.StatusBadRequest},
+		{"missing issuer without announcement", false, url.Values{"code": {"abc"}}, true, http.StatusBadGateway},
+		{"error from other issuer", true, url.Values{"error": {"access_denied"}, "iss": {"https://attacker.example/"}}, false, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Provider.metadata.AuthorizationResponseIssParameterSupported = tt.issSupported
+			redeemed = false
+			state := generateState()
+			LoginStates.AddLoginState(state, LoginState{CodeVerifier: "verifier", Nonce: "nonce", Issuer: issuer})
+			tt.query.Set("state", state)
+
+			rec := httptest.NewRecorder()
+			handleCallback(rec, httptest.NewRequest(http.MethodGet, "/oa/callback?"+tt.query.Encode(), nil))
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %v, got %v", tt.expectedStatus, rec.Code)
+			}
+			// Bei falschem oder fehlendem Issuer wird der Code nie eingelöst
+			if redeemed != tt.expectedRedeem {
+				t.Errorf("Expected code redeemed = %v, got %v", tt.expectedRedeem, redeemed)
+			}
+		})
+	}
+}
+
+func TestCallbackStateOnce(t *testing.T) {
+	var redeemed int
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		redeemed++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+	})
+	defer server.Close()
+	LoginStates = NewLoginStateStore(time.Minute)
+
+	// Derselbe state wird nur einmal eingelöst, der zweite Callback bekommt keinen (leeren) Login-State
+	LoginStates.AddLoginState("once-state", LoginState{CodeVerifier: "verifier", Nonce: "nonce", Issuer: Provider.Metadata().Issuer})
+	for i, expected := range []int{http.StatusBadGateway, http.StatusBadRequest} {
+		rec := httptest.NewRecorder()
+		handleCallback(rec, httptest.NewRequest(http.MethodGet, "/oa/callback?code=abc&state=once-state", nil))
+		if rec.Code != expected {
+			t.Errorf("Callback %d: expected status %v, got %v", i+1, expected, rec.Code)
+		}
+	}
+	if redeemed != 1 {
+		t.Errorf("Expected the code to be redeemed once, got %d", redeemed)
+	}
+
+	// Ein Login-State ohne Issuer wird abgelehnt, bevor der Code eingelöst wird
+	LoginStates.AddLoginState("no-issuer", LoginState{CodeVerifier: "verifier", Nonce: "nonce"})
+	rec := httptest.NewRecorder()
+	handleCallback(rec, httptest.NewRequest(http.MethodGet, "/oa/callback?code=abc&state=no-issuer", nil))
+	if rec.Code != http.StatusBadRequest || redeemed != 1 {
+		t.Errorf("Expected 400 without redeeming the code, got %v", rec.Code)
+	}
+}
@@ -0,0 +1,90 @@
+// ##############################################################################################
+// Hier stehen Pushed Authorization Requests (PAR, https://datatracker.ietf.org/doc/html/rfc9126).
+// Statt alle Parameter (PKCE Challenge, Scope, state, nonce, ...) in die URL der Weiterleitung zu schreiben,
+// sendet der Client den Authorization Request mit Client-Authentifizierung direkt an den Provider.
+// Der Browser bekommt dann nur noch client_id und die request_uri aus der Antwort zu sehen.
+// ##############################################################################################
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// Modi für PAR
+const (
+	ParOff       = "off"       // klassische Weiterleitung mit allen Parametern
+	ParPreferred = "preferred" // PAR, wenn der Provider einen PAR-Endpunkt anbietet, sonst klassische Weiterleitung
+	ParRequired  = "required"  // nur PAR, ohne PAR-Endpunkt schlägt der Login fehl
+)
+
+// zur Darstellung der Antwort des PAR-Endpunkts (RFC 9126 Abschnitt 2.2)
+type ParResponse struct {
+	RequestUri string `json:"request_uri"`
+	ExpiresIn  int    `json:"expires_in"`
+}
+
+// parEndpoint gibt den PAR-Endpunkt zurück (siehe mtlsAlias), ohne PAR-Endpunkt in den Metadaten ist das Ergebnis leer.
+func parEndpoint() string {
+	return mtlsAlias(Provider.Metadata().PushedAuthorizationRequestEndpoint, func(aliases *MtlsEndpointAliases) string {
+		return aliases.PushedAuthorizationRequestEndpoint
+	})
+}
+
+// ##############################################################################################
+// authorizationUrl baut die URL, zu der der Browser für den Login weitergeleitet wird. Je nach ParMode
+// werden die params vorher an den PAR-Endpunkt gesendet, die URL enthält dann nur client_id und request_uri.
+// ##############################################################################################
+
+func authorizationUrl(params url.Values) (string, error) {
+	authorizationEndpoint := Provider.Metadata().AuthorizationEndpoint
+	if ParMode == ParOff {
+		return authorizationEndpoint + "?" + params.Encode(), nil
+	}
+
+	endpoint := parEndpoint()
+	if endpoint == "" {
+		if ParMode == ParRequired {
+			return "", fmt.Errorf("provider does not announce a pushed authorization request endpoint")
+		}
+		log.Println("Provider does not announce a pushed authorization request endpoint, using a classic redirect")
+		return authorizationEndpoint + "?" + params.Encode(), nil
+	}
+
+	parResponse, err := pushAuthorizationRequest(endpoint, params)
+	if err != nil {
+		return "", err
+	}
+	redirectParams := url.Values{}
+	redirectParams.Set("client_id", ClientId)
+	redirectParams.Set("request_uri", parResponse.RequestUri)
+	return authorizationEndpoint + "?" + redirectParams.Encode(), nil
+}
+
+// pushAuthorizationRequest sendet den Authorization Request an den PAR-Endpunkt. Der Provider antwortet
+// mit 201 und einer request_uri, die nur kurz (expires_in) und nur einmal gültig ist.
+func pushAuthorizationRequest(endpoint string, params url.Values) (*ParResponse, error) {
+	// Sendet die Anfrage mit Client-Authentifizierung (siehe client-auth.go)
+	resp, err := postClientRequest(endpoint, params, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, readOAuthError(resp)
+	}
+
+	var parResponse ParResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parResponse); err != nil {
+		return nil, fmt.Errorf("decoding response: %v", err)
+	}
+	if parResponse.RequestUri == "" {
+		return nil, fmt.Errorf("pushed authorization response without request_uri")
+	}
+	return &parResponse, nil
+}
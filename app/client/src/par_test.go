@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ##############################################################################################
+// Tests für Pushed Authorization Requests
+// ##############################################################################################
+
+// mockParProvider startet einen Provider, der den PAR-Endpunkt nur mit withPar in den Metadaten nennt
+func mockParProvider(t *testing.T, withPar bool, handler http.HandlerFunc) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	issuer := server.URL + "/"
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		metadata := ProviderMetadata{
+			Issuer:                issuer,
+			AuthorizationEndpoint: issuer + "authorize/",
+			TokenEndpoint:         issuer + "token/",
+		}
+		if withPar {
+			metadata.PushedAuthorizationRequestEndpoint = issuer + "par/"
+		}
+		json.NewEncoder(w).Encode(metadata)
+	})
+	mux.HandleFunc("/par/", handler)
+
+	Client = *server.Client()
+	Provider = NewProviderDiscovery(issuer, time.Hour)
+	if err := Provider.Load(); err != nil {
+		t.Fatalf("Failed to load mock provider metadata: %v", err)
+	}
+	return server
+}
+
+func TestPushedAuthorizationRequest(t *testing.T) {
+	defer func(mode string, auth ClientAuthenticator) { ParMode, ClientAuth = mode, auth }(ParMode, ClientAuth)
+	ClientAuth = NewSecretAuthenticator(ClientSecretPost, NewClientSecrets("secret", ""))
+	LoginStates = NewLoginStateStore(time.Minute)
+	ParMode = ParPreferred
+
+	var pushed url.Values
+	server := mockParProvider(t, true, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		pushed = r.PostForm
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ParResponse{RequestUri: "urn:ietf:params:oauth:request_uri:abc", ExpiresIn: 60})
+	})
+	defer server.Close()
+
+	rec := httptest.NewRecorder()
+	handleLogin(rec, httptest.NewRequest(http.MethodGet, "/oa/login", nil))
+
+	// Die Parameter gehen mit Client-Authentifizierung an den PAR-Endpunkt
+	if pushed.Get("client_secret") != "secret" || pushed.Get("code_challenge") == "" || pushed.Get("state") == "" || pushed.Get("redirect_uri") != RedirectUrl || pushed.Get("resource") != ResourceIndicator {
+		t.Errorf("Expected the authorization request at the par endpoint, got %v", pushed)
+	}
+	if !LoginStates.Contains(pushed.Get("state")) {
+		t.Errorf("Expected the pushed state to be stored")
+	}
+
+	// Der Browser sieht nur client_id und request_uri
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || rec.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("Expected redirect, got %v %q", rec.Code, rec.Header().Get("Location"))
+	}
+	expected := url.Values{"client_id": {ClientId}, "request_uri": {"urn:ietf:params:oauth:request_uri:abc"}}
+	if !strings.HasPrefix(location.String(), Provider.Metadata().AuthorizationEndpoint) || location.Query().Encode() != expected.Encode() {
+		t.Errorf("Expected only client_id and request_uri in the redirect, got %v", location)
+	}
+}
+
+func TestPushedAuthorizationRequestFallback(t *testing.T) {
+	defer func(mode string) { ParMode = mode }(ParMode)
+	LoginStates = NewLoginStateStore(time.Minute)
+
+	server := mockParProvider(t, false, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected no request to an unannounced par endpoint")
+	})
+	defer server.Close()
+
+	// Ohne PAR-Endpunkt: klassische Weiterleitung oder Fehler, je nach Konfiguration
+	ParMode = ParPreferred
+	rec := httptest.NewRecorder()
+	handleLogin(rec, httptest.NewRequest(http.MethodGet, "/oa/login", nil))
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if rec.Code != http.StatusTemporaryRedirect || location.Query().Get("code_challenge") == "" {
+		t.Errorf("Expected a classic redirect, got %v %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	ParMode = ParRequired
+	rec = httptest.NewRecorder()
+	handleLogin(rec, httptest.NewRequest(http.MethodGet, "/oa/login", nil))
+	if rec.Code != http.StatusBadGateway || rec.Header().Get("Location") != "" {
+		t.Errorf("Expected the login to fail without par endpoint, got %v %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
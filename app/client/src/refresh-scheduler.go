@@ -0,0 +1,122 @@
+// ##############################################################################################
+// Hier steht der optionale Refresh im Hintergrund: Access Tokens von aktiven Sessions werden kurz vor
+// ihrem Ablauf erneuert, damit die erste Anfrage danach nicht auf den Token-Endpunkt warten muss.
+// Der Refresh in den Handlern (RefreshAccess) bleibt als Rückfall bestehen.
+// ##############################################################################################
+
+package main
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RefreshStats sind die Zähler des RefreshSchedulers seit dem Start.
+type RefreshStats struct {
+	Successes int64
+	Failures  int64
+	Idle      int64
+}
+
+// RefreshScheduler prüft alle interval die Sessions und erneuert Access Tokens, die in weniger als
+// lead (plus einem zufälligen Anteil bis jitter, damit nicht alle Sessions gleichzeitig refreshen) ablaufen.
+// Es laufen höchstens concurrency Refreshes gleichzeitig. Sessions ohne Aktivität seit idleAfter werden
+// nicht mehr erneuert, ihr Token wird erst bei der nächsten Anfrage wieder refresht.
+// Der Scheduler sollte nur in einer Instanz des Clients laufen, wenn sich mehrere Instanzen die Sessions teilen.
+type RefreshScheduler struct {
+	store       *SessionTokenStore
+	interval    time.Duration
+	lead        time.Duration
+	jitter      time.Duration
+	idleAfter   time.Duration
+	concurrency int
+
+	successes atomic.Int64
+	failures  atomic.Int64
+	idle      atomic.Int64
+}
+
+// NewRefreshScheduler erstellt einen Scheduler für die Sessions in store.
+func NewRefreshScheduler(store *SessionTokenStore, interval, lead, jitter, idleAfter time.Duration, concurrency int) *RefreshScheduler {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &RefreshScheduler{
+		store:       store,
+		interval:    interval,
+		lead:        lead,
+		jitter:      jitter,
+		idleAfter:   idleAfter,
+		concurrency: concurrency,
+	}
+}
+
+// Stats gibt die aktuellen Zähler zurück.
+func (scheduler *RefreshScheduler) Stats() RefreshStats {
+	return RefreshStats{
+		Successes: scheduler.successes.Load(),
+		Failures:  scheduler.failures.Load(),
+		Idle:      scheduler.idle.Load(),
+	}
+}
+
+// Run prüft die Sessions alle interval, bis stop geschlossen wird.
+func (scheduler *RefreshScheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(scheduler.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			scheduler.RunOnce()
+		}
+	}
+}
+
+// RunOnce prüft einmal alle Sessions und wartet, bis alle fälligen Refreshes abgeschlossen sind.
+func (scheduler *RefreshScheduler) RunOnce() {
+	ids, err := scheduler.store.Ids()
+	if err != nil {
+		log.Printf("cannot list sessions for refresh: %v\n", err)
+		return
+	}
+
+	slots := make(chan struct{}, scheduler.concurrency)
+	var wg sync.WaitGroup
+	now := time.Now()
+	for _, id := range ids {
+		data, exists := scheduler.store.GetData(id)
+		if !exists {
+			continue
+		}
+		lead := scheduler.lead
+		if scheduler.jitter > 0 {
+			lead += time.Duration(rand.Int63n(int64(scheduler.jitter)))
+		}
+		if now.Add(lead).Before(data.AccessTokenExpiresAt) {
+			continue
+		}
+		if now.Sub(data.LastActiveAt) > scheduler.idleAfter {
+			scheduler.idle.Add(1)
+			continue
+		}
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(id string, lead time.Duration) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result := scheduler.store.refreshSingleFlight(id, lead, false)
+			if result.err != nil {
+				scheduler.failures.Add(1)
+			} else if result.refreshed {
+				scheduler.successes.Add(1)
+			}
+		}(id, lead)
+	}
+	wg.Wait()
+}
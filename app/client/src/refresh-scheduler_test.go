@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// ##############################################################################################
+// Tests für den Refresh im Hintergrund
+// ##############################################################################################
+
+// addSessionWith legt eine Session an und setzt Ablauf des Access Tokens und letzte Aktivität
+func addSessionWith(t *testing.T, store *SessionTokenStore, refreshToken string, expiresIn time.Duration, lastActive time.Duration) string {
+	sessionToken, _ := store.AddToken(OAuthToken{AccessToken: "access-old", RefreshToken: refreshToken})
+	data, _ := store.GetData(sessionToken)
+	data.AccessTokenExpiresAt = time.Now().Add(expiresIn)
+	data.LastActiveAt = time.Now().Add(-lastActive)
+	if err := store.put(sessionToken, *data); err != nil {
+		t.Fatalf("Failed to store session: %v", err)
+	}
+	return sessionToken
+}
+
+func TestRefreshSchedulerRunOnce(t *testing.T) {
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("refresh_token") == "revoked" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: "access-new", ExpiresIn: 300})
+	})
+	defer server.Close()
+
+	store := NewSessionTokenStoreWithBackend(NewMemoryStoreBackend(), time.Hour)
+	expiring := addSessionWith(t, store, "refresh", 5*time.Second, time.Minute)
+	valid := addSessionWith(t, store, "refresh", 10*time.Minute, time.Minute)
+	idle := addSessionWith(t, store, "refresh", 5*time.Second, time.Hour)
+	failing := addSessionWith(t, store, "revoked", 5*time.Second, time.Minute)
+
+	scheduler := NewRefreshScheduler(store, time.Second, 10*time.Second, time.Second, 15*time.Minute, 2)
+	scheduler.RunOnce()
+
+	expected := RefreshStats{Successes: 1, Failures: 1, Idle: 1}
+	if stats := scheduler.Stats(); stats != expected {
+		t.Errorf("Expected stats %+v, got %+v", expected, stats)
+	}
+	for id, accessToken := range map[string]string{expiring: "access-new", valid: "access-old", idle: "access-old"} {
+		if data, _ := store.GetData(id); data.Token.AccessToken != accessToken {
+			t.Errorf("Expected access token %q, got %q", accessToken, data.Token.AccessToken)
+		}
+	}
+
+	// Ein abgelehnter Refresh Token beendet die Session
+	if _, exists := store.GetData(failing); exists {
+		t.Errorf("Expected session with rejected refresh token to be removed")
+	}
+
+	// Der Refresh im Hintergrund zählt nicht als Aktivität
+	if data, _ := store.GetData(expiring); time.Since(data.LastActiveAt) < time.Minute {
+		t.Errorf("Expected background refresh not to mark the session active")
+	}
+}
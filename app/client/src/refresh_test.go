@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ##############################################################################################
+// Tests für den Refresh der Access Tokens
+// ##############################################################################################
+
+// mockTokenEndpoint startet einen Provider, dessen Token-Endpunkt von handler beantwortet wird
+func mockTokenEndpoint(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	issuer := server.URL + "/"
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ProviderMetadata{
+			Issuer:                issuer,
+			AuthorizationEndpoint: issuer + "authorize/",
+			TokenEndpoint:         issuer + "token/",
+		})
+	})
+	mux.HandleFunc("/token/", handler)
+
+	Client = *server.Client()
+	Provider = NewProviderDiscovery(issuer, time.Hour)
+	if err := Provider.Load(); err != nil {
+		t.Fatalf("Failed to load mock provider metadata: %v", err)
+	}
+	return server
+}
+
+// addExpiredSession legt eine Session an, deren Access Token sofort erneuert werden muss
+func addExpiredSession(t *testing.T, store *SessionTokenStore, token OAuthToken) string {
+	sessionToken, _ := store.AddToken(token)
+	data, _ := store.GetData(sessionToken)
+	data.AccessTokenExpiresAt = time.Now().Add(-time.Second)
+	if err := store.put(sessionToken, *data); err != nil {
+		t.Fatalf("Failed to store session: %v", err)
+	}
+	return sessionToken
+}
+
+func TestRefreshAccessSingleFlight(t *testing.T) {
+	var calls int32
+	var mu sync.Mutex
+	validRefreshToken := "refresh-1"
+
+	// Der Provider rotiert den Refresh Token, ein alter Refresh Token wird abgelehnt
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if r.FormValue("refresh_token") != validRefreshToken {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		validRefreshToken = "refresh-rotated"
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: "access-new", ExpiresIn: 300, RefreshToken: validRefreshToken, TokenType: "Bearer"})
+	})
+	defer server.Close()
+
+	store := NewSessionTokenStoreWithBackend(NewMemoryStoreBackend(), time.Minute)
+	sessionToken := addExpiredSession(t, store, OAuthToken{AccessToken: "access-old", RefreshToken: "refresh-1"})
+
+	// Zwei Tabs (und mehr) fragen gleichzeitig an
+	var wg sync.WaitGroup
+	results := make([]string, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data, exists := store.RefreshAccess(sessionToken)
+			if exists {
+				results[i] = data.Token.AccessToken
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Expected exactly one call to the token endpoint, got %v", calls)
+	}
+	for _, accessToken := range results {
+		if accessToken != "access-new" {
+			t.Errorf("Expected every caller to get the fresh token, got %q", accessToken)
+		}
+	}
+	data, _ := store.GetData(sessionToken)
+	if data.Token.RefreshToken != "refresh-rotated" {
+		t.Errorf("Expected rotated refresh token to be stored, got %q", data.Token.RefreshToken)
+	}
+
+	// Der neue Access Token ist noch gültig, es wird nicht erneut refresht
+	store.RefreshAccess(sessionToken)
+	if calls != 1 {
+		t.Errorf("Expected no refresh for a valid access token, got %v calls", calls)
+	}
+}
+
+func TestRefreshAccessSharedBackend(t *testing.T) {
+	var calls int32
+	var mu sync.Mutex
+	validRefreshToken := "refresh-1"
+
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if r.FormValue("refresh_token") != validRefreshToken {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		validRefreshToken = "refresh-rotated"
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: "access-new", ExpiresIn: 300, RefreshToken: validRefreshToken, TokenType: "Bearer"})
+	})
+	defer server.Close()
+
+	// Zwei Instanzen des Clients mit demselben Backend, jede mit ihrem eigenen single-flight
+	backend := NewMemoryStoreBackend()
+	replicas := []*SessionTokenStore{
+		NewSessionTokenStoreWithBackend(backend, time.Minute),
+		NewSessionTokenStoreWithBackend(backend, time.Minute),
+	}
+	sessionToken := addExpiredSession(t, replicas[0], OAuthToken{AccessToken: "access-old", RefreshToken: "refresh-1"})
+
+	// Zwei Tabs landen auf verschiedenen Instanzen
+	var wg sync.WaitGroup
+	results := make([]string, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data, exists := replicas[i%2].RefreshAccess(sessionToken)
+			if exists {
+				results[i] = data.Token.AccessToken
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Expected exactly one call to the token endpoint, got %v", calls)
+	}
+	for _, accessToken := range results {
+		if accessToken != "access-new" {
+			t.Errorf("Expected every caller to get the fresh token, got %q", accessToken)
+		}
+	}
+	data, exists := replicas[1].GetData(sessionToken)
+	if !exists || data.Token.RefreshToken != "refresh-rotated" {
+		t.Errorf("Expected rotated refresh token to be stored, got %q", data.Token.RefreshToken)
+	}
+
+	// Die Sperre ist wieder frei und keine Session
+	if _, exists, _ := backend.Get(refreshLockPrefix + sessionToken); exists {
+		t.Errorf("Expected the refresh lock to be released")
+	}
+	backend.PutIfAbsent(refreshLockPrefix+sessionToken, []byte("other"), time.Now().Add(time.Minute))
+	if ids, _ := replicas[0].Ids(); len(ids) != 1 || ids[0] != sessionToken {
+		t.Errorf("Expected only the session id, got %v", ids)
+	}
+}
+
+func TestRefreshAccessWaitsForOtherInstance(t *testing.T) {
+	var calls int32
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: "access-mine", ExpiresIn: 300, RefreshToken: "refresh-2", TokenType: "Bearer"})
+	})
+	defer server.Close()
+
+	backend := NewMemoryStoreBackend()
+	store := NewSessionTokenStoreWithBackend(backend, time.Minute)
+	other := NewSessionTokenStoreWithBackend(backend, time.Minute)
+	sessionToken := addExpiredSession(t, store, OAuthToken{AccessToken: "access-old", RefreshToken: "refresh-1"})
+
+	// Eine andere Instanz hält die Sperre und speichert kurz danach ihren neuen Token
+	backend.PutIfAbsent(refreshLockPrefix+sessionToken, []byte("other"), time.Now().Add(time.Minute))
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		data, _ := other.GetData(sessionToken)
+		data.Token = OAuthToken{AccessToken: "access-other", RefreshToken: "refresh-other", TokenType: "Bearer"}
+		data.AccessTokenExpiresAt = time.Now().Add(5 * time.Minute)
+		other.put(sessionToken, *data)
+		backend.DeleteIf(refreshLockPrefix+sessionToken, []byte("other"))
+	}()
+
+	// Nach dem Warten wird der Token der anderen Instanz benutzt, ohne selbst zu refreshen
+	data, exists := store.RefreshAccess(sessionToken)
+	if !exists || data.Token.AccessToken != "access-other" || calls != 0 {
+		t.Errorf("Expected the token of the other instance without a refresh, got %q after %d calls", data.Token.AccessToken, calls)
+	}
+}
+
+func TestRefreshAccessDoesNotResurrectRemovedSession(t *testing.T) {
+	var store *SessionTokenStore
+	var sessionToken string
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		// Logout während der Anfrage an den Token-Endpunkt
+		store.RemoveToken(sessionToken)
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: "access-new", ExpiresIn: 300, RefreshToken: "refresh-2"})
+	})
+	defer server.Close()
+
+	store = NewSessionTokenStoreWithBackend(NewMemoryStoreBackend(), time.Minute)
+	sessionToken = addExpiredSession(t, store, OAuthToken{AccessToken: "access-old", RefreshToken: "refresh-1"})
+
+	if _, exists := store.RefreshAccess(sessionToken); exists {
+		t.Errorf("Expected removed session not to exist after refresh")
+	}
+	if _, exists := store.GetData(sessionToken); exists {
+		t.Errorf("Expected removed session not to be stored again")
+	}
+}
+
+func TestRefreshAccessErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		body           string
+		expectedExists bool
+	}{
+		// Nur invalid_grant beendet die Session
+		{"invalid grant", http.StatusBadRequest, `{"error": "invalid_grant", "error_description": "token revoked"}`, false},
+		{"server error", http.StatusInternalServerError, `{"error": "server_error"}`, true},
+		{"unavailable without error object", http.StatusServiceUnavailable, `<html>maintenance</html>`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			defer server.Close()
+
+			store := NewSessionTokenStoreWithBackend(NewMemoryStoreBackend(), time.Minute)
+			sessionToken := addExpiredSession(t, store, OAuthToken{AccessToken: "access-old", RefreshToken: "refresh-1"})
+
+			data, exists := store.RefreshAccess(sessionToken)
+			if exists != tt.expectedExists {
+				t.Errorf("Expected session to exist: %v, got %v", tt.expectedExists, exists)
+			}
+			if exists && data.Token.AccessToken != "access-old" {
+				t.Errorf("Expected old access token to be kept, got %q", data.Token.AccessToken)
+			}
+		})
+	}
+}
+
+func TestRefreshAccessWithoutRefreshToken(t *testing.T) {
+	var calls int32
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	defer server.Close()
+
+	// Ohne Refresh Token wird der Token-Endpunkt nie angefragt, der Access Token bleibt bis zum Ablauf der Session
+	store := NewSessionTokenStoreWithBackend(NewMemoryStoreBackend(), time.Minute)
+	sessionToken := addExpiredSession(t, store, OAuthToken{AccessToken: "access-old", ExpiresIn: 60})
+
+	data, exists := store.RefreshAccess(sessionToken)
+	if !exists || data.Token.AccessToken != "access-old" {
+		t.Errorf("Expected session with old access token, got exists %v, %q", exists, data.Token.AccessToken)
+	}
+	if err := data.refreshAccessTokenIfPossible(); err != nil {
+		t.Errorf("Expected no error without refresh token, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Errorf("Expected no token request, got %d", n)
+	}
+}
+
+func TestResourceIndicator(t *testing.T) {
+	defer func(previous string) { ResourceIndicator = previous }(ResourceIndicator)
+	ResourceIndicator = "https://notes.example/notes"
+
+	var resources []string
+	server := mockTokenEndpoint(t, func(w http.ResponseWriter, r *http.Request) {
+		resources = append(resources, r.FormValue("resource"))
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: "access", TokenType: "Bearer"})
+	})
+	defer server.Close()
+
+	// Code-Tausch und Refresh fordern einen Token für die notes-Api an
+	if _, err := exchangeCode("code", "verifier", nil); err != nil {
+		t.Fatalf("Expected code exchange, got %v", err)
+	}
+	if _, err := consumeRefreshToken("refresh", nil); err != nil {
+		t.Fatalf("Expected refresh, got %v", err)
+	}
+	if len(resources) != 2 || resources[0] != ResourceIndicator || resources[1] != ResourceIndicator {
+		t.Errorf("Expected resource %q in both requests, got %v", ResourceIndicator, resources)
+	}
+
+	// Ohne Resource Indicator wird kein resource Parameter gesendet
+	ResourceIndicator = ""
+	resources = nil
+	if _, err := consumeRefreshToken("refresh", nil); err != nil {
+		t.Fatalf("Expected refresh, got %v", err)
+	}
+	if len(resources) != 1 || resources[0] != "" {
+		t.Errorf("Expected no resource parameter, got %v", resources)
+	}
+}
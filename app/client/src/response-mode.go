@@ -0,0 +1,131 @@
+// ##############################################################################################
+// Hier wird die Antwort des Authorization Servers an den Callback gelesen, passend zum response_mode
+// (https://openid.net/specs/oauth-v2-multiple-response-types-1_0.html#ResponseModes).
+// Mit "query" stehen code und state in der URL, mit "form_post" werden sie per POST gesendet und landen so
+// weder im Browserverlauf noch im Referer (https://openid.net/specs/oauth-v2-form-post-response-mode-1_0.html).
+// Mit JARM ("query.jwt", "form_post.jwt", https://openid.net/specs/oauth-v2-jarm.html) steht die ganze Antwort
+// in einem vom Provider signierten JWT (response), das vor dem Auslesen von code und state geprüft wird.
+// ##############################################################################################
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Werte für den response_mode
+const (
+	ResponseModeQuery       = "query"
+	ResponseModeFormPost    = "form_post"
+	ResponseModeQueryJwt    = "query.jwt"
+	ResponseModeFormPostJwt = "form_post.jwt"
+)
+
+// usesFormPost prüft, ob die Antwort im response_mode per POST an den Callback kommt.
+func usesFormPost(mode string) bool {
+	return mode == ResponseModeFormPost || mode == ResponseModeFormPostJwt
+}
+
+// ##############################################################################################
+// callbackParams liest die Parameter der Antwort an den Callback je nach ResponseMode. Die HTTP-Methode
+// muss zum Modus passen (GET für query, POST für form_post), bei JARM werden die Parameter aus dem
+// geprüften response JWT übernommen. Bei einem Fehler wird auch der HTTP-Status für die Fehlerseite zurückgegeben.
+// ##############################################################################################
+
+func callbackParams(w http.ResponseWriter, r *http.Request) (url.Values, int, error) {
+	var params url.Values
+	if usesFormPost(ResponseMode) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			return nil, http.StatusMethodNotAllowed, fmt.Errorf("response mode %s requires POST, got %s", ResponseMode, r.Method)
+		}
+		if err := r.ParseForm(); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("parsing form: %v", err)
+		}
+		params = r.PostForm
+	} else {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			return nil, http.StatusMethodNotAllowed, fmt.Errorf("response mode %s requires GET, got %s", ResponseMode, r.Method)
+		}
+		params = r.URL.Query()
+	}
+
+	if ResponseMode != ResponseModeQueryJwt && ResponseMode != ResponseModeFormPostJwt {
+		return params, http.StatusOK, nil
+	}
+	response := params.Get("response")
+	if response == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("response parameter missing")
+	}
+	jarmParams, err := validateJarmResponse(response)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return jarmParams, http.StatusOK, nil
+}
+
+// ##############################################################################################
+// validateJarmResponse prüft die signierte Antwort (JARM Abschnitt 2.4): Signatur mit dem JWKS des Providers,
+// iss, aud (die Client Id) und exp. Die übrigen Claims (code, state, iss, error, ...) werden als Parameter
+// zurückgegeben. Verschlüsselte Antworten (JWE) werden nicht unterstützt.
+// ##############################################################################################
+
+func validateJarmResponse(source string) (url.Values, error) {
+	metadata := Provider.Metadata()
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(signingAlgorithms(metadata.AuthorizationSigningAlgValuesSupported)),
+		// exp wird unten mit Toleranz (IdTokenLeeway) geprüft
+		jwt.WithoutClaimsValidation(),
+	)
+
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(source, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return IdTokenKeys.Key(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization response: %v", err)
+	}
+
+	if !claims.VerifyIssuer(metadata.Issuer, true) {
+		return nil, fmt.Errorf("authorization response issuer mismatch: '%v'", claims["iss"])
+	}
+	if !claims.VerifyAudience(ClientId, true) {
+		return nil, fmt.Errorf("authorization response audience does not contain the client id")
+	}
+	if !claims.VerifyExpiresAt(time.Now().Add(-IdTokenLeeway).Unix(), true) {
+		return nil, fmt.Errorf("authorization response is expired")
+	}
+
+	params := url.Values{}
+	for name, value := range claims {
+		if name == "aud" || name == "exp" {
+			continue
+		}
+		if text, ok := value.(string); ok {
+			params.Set(name, text)
+		}
+	}
+	return params, nil
+}
+
+// responseModeSupported prüft, ob der Provider den response_mode ankündigt. Ohne Angabe gelten nach
+// RFC 8414 "query" und "fragment".
+func responseModeSupported(metadata ProviderMetadata, mode string) bool {
+	if len(metadata.ResponseModesSupported) == 0 {
+		return mode == ResponseModeQuery
+	}
+	for _, supported := range metadata.ResponseModesSupported {
+		if supported == mode {
+			return true
+		}
+	}
+	return false
+}
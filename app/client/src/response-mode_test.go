@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ##############################################################################################
+// Tests für die Response Modes des Callbacks
+// ##############################################################################################
+
+func TestCallbackResponseModeMethods(t *testing.T) {
+	defer func(previous string) { ResponseMode = previous }(ResponseMode)
+
+	tests := []struct {
+		name           string
+		mode           string
+		method         string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"query with GET", ResponseModeQuery, http.MethodGet, http.StatusOK, "from-query"},
+		{"query with POST", ResponseModeQuery, http.MethodPost, http.StatusMethodNotAllowed, ""},
+		{"form_post with POST", ResponseModeFormPost, http.MethodPost, http.StatusOK, "from-body"},
+		{"form_post with GET", ResponseModeFormPost, http.MethodGet, http.StatusMethodNotAllowed, ""},
+		{"jarm form_post with GET", ResponseModeFormPostJwt, http.MethodGet, http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ResponseMode = tt.mode
+			req := httptest.NewRequest(tt.method, "/oa/callback?code=from-query&state=s", strings.NewReader("code=from-body&state=s"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+
+			params, status, err := callbackParams(rec, req)
+			if status != tt.expectedStatus || (err == nil) != (tt.expectedStatus == http.StatusOK) {
+				t.Fatalf("Expected status %v, got %v, %v", tt.expectedStatus, status, err)
+			}
+			// Im Modus form_post zählt nur der Body, nie die URL
+			if err == nil && params.Get("code") != tt.expectedCode {
+				t.Errorf("Expected code %q, got %q", tt.expectedCode, params.Get("code"))
+			}
+			if err != nil && rec.Header().Get("Allow") == "" {
+				t.Errorf("Expected an Allow header")
+			}
+		})
+	}
+
+	// Der Callback lehnt die falsche Methode ab, bevor der state verbraucht wird
+	ResponseMode = ResponseModeFormPost
+	LoginStates = NewLoginStateStore(time.Minute)
+	LoginStates.AddLoginState("known-state", LoginState{CodeVerifier: "verifier"})
+	rec := httptest.NewRecorder()
+	handleCallback(rec, httptest.NewRequest(http.MethodGet, "/oa/callback?code=abc&state=known-state", nil))
+	if rec.Code != http.StatusMethodNotAllowed || !LoginStates.Contains("known-state") {
+		t.Errorf("Expected 405 without consuming the state, got %v", rec.Code)
+	}
+}
+
+func TestJarmResponse(t *testing.T) {
+	defer func(previous string) { ResponseMode = previous }(ResponseMode)
+	ResponseMode = ResponseModeQueryJwt
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	server := mockIdTokenProvider(t, key)
+	defer server.Close()
+
+	sign := func(signingKey *rsa.PrivateKey, modify func(jwt.MapClaims)) string {
+		claims := jwt.MapClaims{
+			"iss":   Provider.Metadata().Issuer,
+			"aud":   ClientId,
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"code":  "signed-code",
+			"state": "signed-state",
+		}
+		if modify != nil {
+			modify(claims)
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test-key"
+		signed, err := token.SignedString(signingKey)
+		if err != nil {
+			t.Fatalf("Failed to sign response: %v", err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name       string
+		response   string
+		expectedOk bool
+	}{
+		{"valid response", sign(key, nil), true},
+		{"other key", sign(otherKey, nil), false},
+		{"other issuer", sign(key, func(c jwt.MapClaims) { c["iss"] = "https://attacker.example/" }), false},
+		{"other audience", sign(key, func(c jwt.MapClaims) { c["aud"] = "other-client" }), false},
+		{"expired", sign(key, func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }), false},
+		{"missing response", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Unsignierte Parameter neben dem JWT werden ignoriert
+			query := url.Values{"code": {"unsigned-code"}}
+			if tt.response != "" {
+				query.Set("response", tt.response)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/oa/callback?"+query.Encode(), nil)
+
+			params, _, err := callbackParams(httptest.NewRecorder(), req)
+			if (err == nil) != tt.expectedOk {
+				t.Fatalf("Expected ok = %v, got %v", tt.expectedOk, err)
+			}
+			if tt.expectedOk && (params.Get("code") != "signed-code" || params.Get("state") != "signed-state" || params.Get("iss") != Provider.Metadata().Issuer) {
+				t.Errorf("Expected the signed parameters, got %v", params)
+			}
+		})
+	}
+}
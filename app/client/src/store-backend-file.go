@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ##############################################################################################
+// FileStoreBackend hält die Einträge im Speicher und schreibt nach jeder Änderung einen
+// Snapshot in eine Datei. Beim Start wird der Snapshot wieder eingelesen, so überleben die
+// Sessions einen Neustart. Für mehrere Instanzen ist das Postgres Backend gedacht.
+// Die Datei enthält Tokens und wird deswegen nur für den Besitzer lesbar angelegt.
+// ##############################################################################################
+
+type FileStoreBackend struct {
+	path    string
+	entries map[string]storeEntry
+	mu      sync.RWMutex
+}
+
+// NewFileStoreBackend öffnet das Backend und liest einen vorhandenen Snapshot unter path ein.
+// Abgelaufene Einträge werden dabei verworfen.
+func NewFileStoreBackend(path string) (*FileStoreBackend, error) {
+	backend := &FileStoreBackend{
+		path:    path,
+		entries: make(map[string]storeEntry),
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return backend, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &backend.entries); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for key, entry := range backend.entries {
+		if entry.expired(now) {
+			delete(backend.entries, key)
+		}
+	}
+	return backend, nil
+}
+
+func (backend *FileStoreBackend) Put(key string, value []byte, expiresAt time.Time) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.entries[key] = storeEntry{Value: value, ExpiresAt: expiresAt}
+	return backend.persist()
+}
+
+func (backend *FileStoreBackend) Get(key string) ([]byte, bool, error) {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	entry, exists := backend.entries[key]
+	if !exists || entry.expired(time.Now()) {
+		return nil, false, nil
+	}
+	return entry.Value, true, nil
+}
+
+func (backend *FileStoreBackend) Take(key string) ([]byte, bool, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	entry, exists := backend.entries[key]
+	if !exists {
+		return nil, false, nil
+	}
+	delete(backend.entries, key)
+	if err := backend.persist(); err != nil {
+		return nil, false, err
+	}
+	if entry.expired(time.Now()) {
+		return nil, false, nil
+	}
+	return entry.Value, true, nil
+}
+
+func (backend *FileStoreBackend) PutIfAbsent(key string, value []byte, expiresAt time.Time) (bool, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if entry, exists := backend.entries[key]; exists && !entry.expired(time.Now()) {
+		return false, nil
+	}
+	backend.entries[key] = storeEntry{Value: value, ExpiresAt: expiresAt}
+	return true, backend.persist()
+}
+
+func (backend *FileStoreBackend) Delete(key string) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if _, exists := backend.entries[key]; !exists {
+		return nil
+	}
+	delete(backend.entries, key)
+	return backend.persist()
+}
+
+func (backend *FileStoreBackend) DeleteIf(key string, value []byte) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if entry, exists := backend.entries[key]; !exists || !bytes.Equal(entry.Value, value) {
+		return nil
+	}
+	delete(backend.entries, key)
+	return backend.persist()
+}
+
+func (backend *FileStoreBackend) Keys() ([]string, error) {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	return liveKeys(backend.entries, time.Now()), nil
+}
+
+func (backend *FileStoreBackend) CleanUp() error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	now := time.Now()
+	removed := false
+	for key, entry := range backend.entries {
+		if entry.expired(now) {
+			delete(backend.entries, key)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return backend.persist()
+}
+
+// persist schreibt den Snapshot in eine temporäre Datei und ersetzt dann die alte Datei,
+// damit bei einem Absturz nie ein halb geschriebener Snapshot übrig bleibt.
+// Der Aufrufer muss den Mutex halten.
+func (backend *FileStoreBackend) persist() error {
+	data, err := json.Marshal(backend.entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(backend.path), filepath.Base(backend.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), backend.path)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// ##############################################################################################
+// PostgresStoreBackend speichert die Einträge in einer Tabelle der Postgres-Datenbank des
+// Resource Servers (eigene Rolle und eigenes Schema, siehe init-db/init.sql). Damit können
+// mehrere Instanzen des Clients dieselben Sessions benutzen.
+// Die Ablaufzeit wird mit der Uhr des Clients verglichen, wie bei den anderen Backends.
+// ##############################################################################################
+
+type PostgresStoreBackend struct {
+	db    *sql.DB
+	table string
+}
+
+// NewPostgresStoreBackend erstellt das Backend und legt die Tabelle an, falls sie fehlt.
+// table ist ein Name aus der Konfiguration (z.B. "client_user.sessions"), keine Benutzereingabe.
+func NewPostgresStoreBackend(db *sql.DB, table string) (*PostgresStoreBackend, error) {
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		key        TEXT PRIMARY KEY,
+		value      BYTEA NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`, table))
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresStoreBackend{db: db, table: table}, nil
+}
+
+func (backend *PostgresStoreBackend) Put(key string, value []byte, expiresAt time.Time) error {
+	_, err := backend.db.Exec(fmt.Sprintf(`INSERT INTO %s (key, value, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at`, backend.table),
+		key, value, expiresAt)
+	return err
+}
+
+func (backend *PostgresStoreBackend) Get(key string) ([]byte, bool, error) {
+	var value []byte
+	err := backend.db.QueryRow(fmt.Sprintf("SELECT value FROM %s WHERE key = $1 AND expires_at > $2", backend.table),
+		key, time.Now()).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (backend *PostgresStoreBackend) Take(key string) ([]byte, bool, error) {
+	// DELETE ... RETURNING ist atomar, ein state kann auch bei mehreren Instanzen nur einmal verwendet werden
+	var entry storeEntry
+	err := backend.db.QueryRow(fmt.Sprintf("DELETE FROM %s WHERE key = $1 RETURNING value, expires_at", backend.table),
+		key).Scan(&entry.Value, &entry.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if entry.expired(time.Now()) {
+		return nil, false, nil
+	}
+	return entry.Value, true, nil
+}
+
+func (backend *PostgresStoreBackend) PutIfAbsent(key string, value []byte, expiresAt time.Time) (bool, error) {
+	// Ein abgelaufener Eintrag wird überschrieben, ein gültiger nicht. Die Zeile ist dabei gesperrt, von zwei
+	// Instanzen gewinnt genau eine.
+	result, err := backend.db.Exec(fmt.Sprintf(`INSERT INTO %s AS entry (key, value, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at
+		WHERE entry.expires_at <= $4`, backend.table),
+		key, value, expiresAt, time.Now())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (backend *PostgresStoreBackend) Delete(key string) error {
+	_, err := backend.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE key = $1", backend.table), key)
+	return err
+}
+
+func (backend *PostgresStoreBackend) DeleteIf(key string, value []byte) error {
+	_, err := backend.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE key = $1 AND value = $2", backend.table), key, value)
+	return err
+}
+
+func (backend *PostgresStoreBackend) Keys() ([]string, error) {
+	rows, err := backend.db.Query(fmt.Sprintf("SELECT key FROM %s WHERE expires_at > $1", backend.table), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (backend *PostgresStoreBackend) CleanUp() error {
+	_, err := backend.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE expires_at <= $1", backend.table), time.Now())
+	return err
+}
@@ -0,0 +1,195 @@
+// ##############################################################################################
+// Hier stehen die austauschbaren Speicher (Backends) für Sessions und Login-States.
+// SessionTokenStore und LoginStateStore speichern ihre Einträge als JSON über die Schnittstelle
+// StoreBackend, so bleiben Sessions (je nach Backend) über Neustarts erhalten und
+// mehrere Instanzen des Clients können sich die Sessions teilen.
+// ##############################################################################################
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StoreBackend speichert Werte unter einem Schlüssel bis zu einem Ablaufzeitpunkt.
+// Alle Backends haben dieselbe Semantik: Ein Eintrag ist ab expiresAt (nach der Uhr des Clients)
+// abgelaufen und wird von Get und Take nicht mehr zurückgegeben. CleanUp löscht abgelaufene Einträge endgültig.
+type StoreBackend interface {
+	// Put speichert oder überschreibt einen Eintrag
+	Put(key string, value []byte, expiresAt time.Time) error
+	// Get gibt einen nicht abgelaufenen Eintrag zurück
+	Get(key string) ([]byte, bool, error)
+	// Take gibt einen nicht abgelaufenen Eintrag zurück und löscht ihn atomar (nur einmal verwendbar)
+	Take(key string) ([]byte, bool, error)
+	// PutIfAbsent speichert einen Eintrag nur, wenn es unter key keinen nicht abgelaufenen Eintrag gibt (atomar).
+	// Das Ergebnis ist true, wenn der Eintrag gespeichert wurde.
+	PutIfAbsent(key string, value []byte, expiresAt time.Time) (bool, error)
+	// Delete löscht einen Eintrag, fehlende Einträge sind kein Fehler
+	Delete(key string) error
+	// DeleteIf löscht einen Eintrag nur, wenn er noch value enthält (atomar)
+	DeleteIf(key string, value []byte) error
+	// CleanUp löscht alle abgelaufenen Einträge
+	CleanUp() error
+	// Keys gibt die Schlüssel aller nicht abgelaufenen Einträge zurück
+	Keys() ([]string, error)
+}
+
+// storeEntry ist ein gespeicherter Wert mit Ablaufzeitpunkt
+type storeEntry struct {
+	Value     []byte    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// expired prüft, ob der Eintrag zum Zeitpunkt now abgelaufen ist
+func (entry storeEntry) expired(now time.Time) bool {
+	return !now.Before(entry.ExpiresAt)
+}
+
+// liveKeys gibt die Schlüssel aller zum Zeitpunkt now nicht abgelaufenen Einträge zurück
+func liveKeys(entries map[string]storeEntry, now time.Time) []string {
+	keys := make([]string, 0, len(entries))
+	for key, entry := range entries {
+		if !entry.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// ##############################################################################################
+// MemoryStoreBackend ist der Standard: eine Map im Speicher. Nach einem Neustart sind alle
+// Sessions verloren und sie kann nicht zwischen mehreren Instanzen geteilt werden.
+// ##############################################################################################
+
+type MemoryStoreBackend struct {
+	entries map[string]storeEntry
+	mu      sync.RWMutex
+}
+
+// NewMemoryStoreBackend erstellt ein leeres Backend im Speicher.
+func NewMemoryStoreBackend() *MemoryStoreBackend {
+	return &MemoryStoreBackend{
+		entries: make(map[string]storeEntry),
+	}
+}
+
+func (backend *MemoryStoreBackend) Put(key string, value []byte, expiresAt time.Time) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.entries[key] = storeEntry{Value: value, ExpiresAt: expiresAt}
+	return nil
+}
+
+func (backend *MemoryStoreBackend) Get(key string) ([]byte, bool, error) {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	entry, exists := backend.entries[key]
+	if !exists || entry.expired(time.Now()) {
+		return nil, false, nil
+	}
+	return entry.Value, true, nil
+}
+
+func (backend *MemoryStoreBackend) Take(key string) ([]byte, bool, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	entry, exists := backend.entries[key]
+	delete(backend.entries, key)
+	if !exists || entry.expired(time.Now()) {
+		return nil, false, nil
+	}
+	return entry.Value, true, nil
+}
+
+func (backend *MemoryStoreBackend) PutIfAbsent(key string, value []byte, expiresAt time.Time) (bool, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if entry, exists := backend.entries[key]; exists && !entry.expired(time.Now()) {
+		return false, nil
+	}
+	backend.entries[key] = storeEntry{Value: value, ExpiresAt: expiresAt}
+	return true, nil
+}
+
+func (backend *MemoryStoreBackend) Delete(key string) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	delete(backend.entries, key)
+	return nil
+}
+
+func (backend *MemoryStoreBackend) DeleteIf(key string, value []byte) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if entry, exists := backend.entries[key]; exists && bytes.Equal(entry.Value, value) {
+		delete(backend.entries, key)
+	}
+	return nil
+}
+
+func (backend *MemoryStoreBackend) Keys() ([]string, error) {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	return liveKeys(backend.entries, time.Now()), nil
+}
+
+func (backend *MemoryStoreBackend) CleanUp() error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	now := time.Now()
+	for key, entry := range backend.entries {
+		if entry.expired(now) {
+			delete(backend.entries, key)
+		}
+	}
+	return nil
+}
+
+// ##############################################################################################
+// Auswahl der Backends anhand der Konfiguration
+// ##############################################################################################
+
+// openStores erstellt den SessionTokenStore und den LoginStateStore für das konfigurierte Backend
+// ("memory", "file" oder "postgres"). Die Ablaufzeiten sind bei allen Backends gleich.
+func openStores(backend string) (*SessionTokenStore, *LoginStateStore, error) {
+	switch backend {
+	case "memory":
+		return NewSessionTokenStoreWithBackend(NewMemoryStoreBackend(), RefreshTokenLifetime), NewLoginStateStore(LoginStateTTL), nil
+	case "file":
+		sessions, err := NewFileStoreBackend(filepath.Join(StoreDirectory, "sessions.json"))
+		if err != nil {
+			return nil, nil, err
+		}
+		states, err := NewFileStoreBackend(filepath.Join(StoreDirectory, "login-states.json"))
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewSessionTokenStoreWithBackend(sessions, RefreshTokenLifetime), NewLoginStateStoreWithBackend(states, LoginStateTTL), nil
+	case "postgres":
+		connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+			StoreDbHost, StoreDbPort, StoreDbUser, StoreDbPassword, StoreDbName)
+		db, err := sql.Open("postgres", connStr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := db.Ping(); err != nil {
+			return nil, nil, err
+		}
+		sessions, err := NewPostgresStoreBackend(db, StoreDbSchema+".sessions")
+		if err != nil {
+			return nil, nil, err
+		}
+		states, err := NewPostgresStoreBackend(db, StoreDbSchema+".login_states")
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewSessionTokenStoreWithBackend(sessions, RefreshTokenLifetime), NewLoginStateStoreWithBackend(states, LoginStateTTL), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown session storage %q", backend)
+	}
+}
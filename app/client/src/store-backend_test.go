@@ -0,0 +1,135 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ##############################################################################################
+// Tests für die StoreBackends. Alle Backends müssen dieselbe Ablauf-Semantik haben,
+// deswegen laufen dieselben Tests gegen alle Backends, die ohne Postgres auskommen.
+// ##############################################################################################
+
+func testStoreBackends(t *testing.T) map[string]StoreBackend {
+	file, err := NewFileStoreBackend(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatalf("Failed to open file backend: %v", err)
+	}
+	return map[string]StoreBackend{
+		"memory": NewMemoryStoreBackend(),
+		"file":   file,
+	}
+}
+
+func TestStoreBackend(t *testing.T) {
+	for name, backend := range testStoreBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			future := time.Now().Add(time.Minute)
+			past := time.Now().Add(-time.Second)
+
+			backend.Put("valid", []byte("value"), future)
+			backend.Put("expired", []byte("old"), past)
+
+			value, exists, err := backend.Get("valid")
+			if err != nil || !exists || string(value) != "value" {
+				t.Errorf("Expected valid entry, got %q %v %v", value, exists, err)
+			}
+			// Abgelaufene Einträge gibt es nicht mehr, auch vor dem Aufräumen
+			if _, exists, _ := backend.Get("expired"); exists {
+				t.Errorf("Expected expired entry to be hidden by Get")
+			}
+			if _, exists, _ := backend.Take("expired"); exists {
+				t.Errorf("Expected expired entry to be hidden by Take")
+			}
+
+			// Take kann einen Eintrag nur einmal zurückgeben
+			backend.Put("once", []byte("state"), future)
+			if _, exists, _ := backend.Take("once"); !exists {
+				t.Errorf("Expected first Take to succeed")
+			}
+			if _, exists, _ := backend.Take("once"); exists {
+				t.Errorf("Expected second Take to fail")
+			}
+
+			// Überschreiben setzt auch den Ablaufzeitpunkt neu
+			backend.Put("valid", []byte("newer"), past)
+			if _, exists, _ := backend.Get("valid"); exists {
+				t.Errorf("Expected overwritten entry to be expired")
+			}
+
+			backend.Put("deleted", []byte("value"), future)
+			if err := backend.Delete("deleted"); err != nil {
+				t.Errorf("Delete() failed: %v", err)
+			}
+			if err := backend.Delete("missing"); err != nil {
+				t.Errorf("Expected deleting a missing entry to succeed, got %v", err)
+			}
+			if _, exists, _ := backend.Get("deleted"); exists {
+				t.Errorf("Expected entry to be deleted")
+			}
+
+			// PutIfAbsent überschreibt nur abgelaufene Einträge, DeleteIf löscht nur den eigenen Wert
+			if stored, err := backend.PutIfAbsent("lock", []byte("first"), future); !stored || err != nil {
+				t.Errorf("Expected PutIfAbsent to store a new entry, got %v %v", stored, err)
+			}
+			if stored, _ := backend.PutIfAbsent("lock", []byte("second"), future); stored {
+				t.Errorf("Expected PutIfAbsent not to overwrite a valid entry")
+			}
+			if stored, _ := backend.PutIfAbsent("expired", []byte("second"), future); !stored {
+				t.Errorf("Expected PutIfAbsent to overwrite an expired entry")
+			}
+			backend.DeleteIf("lock", []byte("second"))
+			if value, exists, _ := backend.Get("lock"); !exists || string(value) != "first" {
+				t.Errorf("Expected DeleteIf to keep an entry with another value, got %q", value)
+			}
+			backend.DeleteIf("lock", []byte("first"))
+			if _, exists, _ := backend.Get("lock"); exists {
+				t.Errorf("Expected DeleteIf to delete an entry with the same value")
+			}
+
+			if err := backend.CleanUp(); err != nil {
+				t.Errorf("CleanUp() failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestFileStoreBackendSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	backend, err := NewFileStoreBackend(path)
+	if err != nil {
+		t.Fatalf("Failed to open file backend: %v", err)
+	}
+	store := NewSessionTokenStoreWithBackend(backend, time.Minute)
+	sessionToken, csrfToken := store.AddSession(OAuthToken{AccessToken: "access"}, UserInfo{Subject: "alice"}, nil)
+	backend.Put("expired", []byte("old"), time.Now().Add(-time.Second))
+
+	// Neustart: ein neues Backend liest den Snapshot ein
+	reopened, err := NewFileStoreBackend(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen file backend: %v", err)
+	}
+	data, exists := NewSessionTokenStoreWithBackend(reopened, time.Minute).GetData(sessionToken)
+	if !exists {
+		t.Fatalf("Expected session to survive a restart")
+	}
+	if data.Token.AccessToken != "access" || data.User.Subject != "alice" || data.CSRFToken.Source != csrfToken {
+		t.Errorf("Expected restored session data, got %+v", data)
+	}
+	if len(reopened.entries) != 1 {
+		t.Errorf("Expected expired entries to be dropped on load, got %v entries", len(reopened.entries))
+	}
+}
+
+func TestLoginStateStoreExpiry(t *testing.T) {
+	store := NewLoginStateStoreWithBackend(NewMemoryStoreBackend(), -time.Second)
+	store.AddLoginState("state", LoginState{CodeVerifier: "verifier"})
+
+	if store.Contains("state") {
+		t.Errorf("Expected state with elapsed TTL to be expired")
+	}
+	if _, exists := store.RetrieveLoginState("state"); exists {
+		t.Errorf("Expected expired state not to be retrievable")
+	}
+}
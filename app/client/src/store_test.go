@@ -7,10 +7,7 @@ import (
 
 // Eine Mock Version vom SessionTokenStore, bei dem die Sessions schnell ablaufen
 func mockNewSessionTokenStore() *SessionTokenStore {
-    return &SessionTokenStore{
-        tokens: make(map[string]SessionTokenData),
-		ttl: time.Second * 10,
-    }
+    return NewSessionTokenStoreWithBackend(NewMemoryStoreBackend(), time.Second * 10)
 }
 
 // Simuliere Refresh Token Flow
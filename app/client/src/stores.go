@@ -12,10 +12,9 @@ import (
     "net/url"
     "sync"
     "net/http"
-    "bytes"
-    "io/ioutil"
     "fmt"
     "encoding/json"
+    "strings"
 )
 
 // zur Darstellung eines CSRF-Tokens
@@ -23,40 +22,105 @@ type CSRFToken struct {
 	Source string
 }
 
-// zur Speicherung von Session-Daten: OAuth2-Token, CSRF-Token und Ablaufzeiten für Access- und Session-Tokens.
+// zur Speicherung von Session-Daten: OAuth2-Token, CSRF-Token, der angemeldete Benutzer (aus dem ID Token)
+// und Ablaufzeiten für Access- und Session-Tokens.
 type SessionTokenData struct {
 	Token                OAuthToken
 	CSRFToken		     CSRFToken
+	User                 UserInfo
 	AccessTokenExpiresAt time.Time
 	SessionExpiresAt     time.Time
+	LastActiveAt         time.Time
+
+	// Privater DPoP-Schlüssel der Session (PKCS#8, base64), leer ohne DPoP (siehe dpop.go)
+	DPoPKey              string
 }
 
-// zur Verwaltung eines Stores für Session-Tokens. Enthält eine Map zur Speicherung der Tokens, 
-// einen Mutex zur Synchronisierung und eine TTL (time-to-live) für die Tokens.
+// dpopKey gibt den DPoP-Schlüssel der Session zurück (nil ohne DPoP).
+func (sessionData *SessionTokenData) dpopKey() *DPoPKey {
+	if sessionData.DPoPKey == "" {
+		return nil
+	}
+	key, err := ParseDPoPKey(sessionData.DPoPKey)
+	if err != nil {
+		log.Printf("cannot read dpop key of session: %v\n", err)
+		return nil
+	}
+	return key
+}
+
+// zur Verwaltung eines Stores für Session-Tokens. Die Sessions werden als JSON in einem StoreBackend
+// gespeichert (Standard: Map im Speicher), mit dem Ablauf der Session als Ablaufzeitpunkt.
+// In refreshing stehen die laufenden Refreshes pro Session (single-flight), der Mutex schützt nur diese Map.
+// Die TTL (time-to-live) ist die Lebensdauer einer Session, wenn der Provider keine Lebensdauer für den Refresh Token angibt.
 type SessionTokenStore struct {
-	tokens map[string]SessionTokenData
-	mu     sync.RWMutex
-	ttl    time.Duration
+	backend    StoreBackend
+	refreshing map[string]*refreshCall
+	mu         sync.Mutex
+	ttl        time.Duration
+}
+
+// ein laufender Refresh einer Session. Alle Aufrufer warten auf done und bekommen dasselbe Ergebnis.
+type refreshCall struct {
+	done   chan struct{}
+	result refreshResult
+}
+
+// das Ergebnis eines Refreshs: die aktuellen Session-Daten, ob die Session existiert,
+// ob tatsächlich ein neuer Token geholt wurde und ein eventueller Fehler des Token-Endpunkts
+type refreshResult struct {
+	data      *SessionTokenData
+	exists    bool
+	refreshed bool
+	err       error
+}
+
+// Sperre des Refreshs einer Session im Backend (siehe lockRefresh). Session-Tokens enthalten keinen Doppelpunkt.
+// Die Sperre läuft nach refreshLockTimeout ab, wartende Refreshs fragen alle refreshLockPoll nach, höchstens refreshLockWait lang.
+const (
+    refreshLockPrefix  = "refresh-lock:"
+    refreshLockTimeout = time.Minute
+    refreshLockWait    = 10 * time.Second
+    refreshLockPoll    = 50 * time.Millisecond
+)
+
+// So oft wird LastActiveAt einer Session höchstens geschrieben (nicht bei jeder Anfrage)
+const activityResolution = time.Minute
+
+// AddToken fügt ein neues Access-Token ohne Benutzerdaten zum Store hinzu (siehe AddSession).
+func (store *SessionTokenStore) AddToken(token OAuthToken) (string, string) {
+    return store.AddSession(token, UserInfo{}, nil)
 }
 
-// AddToken fügt ein neues Access-Token zum Store hinzu.
+// AddSession fügt ein neues Access-Token und den angemeldeten Benutzer zum Store hinzu.
 // Es generiert einen neuen Session-Token und einen CSRF-Token.
 // Der neue Eintrag wird im Store gespeichert und die Tokens werden zurückgegeben.
-func (store *SessionTokenStore) AddToken(token OAuthToken) (string, string) {
-    store.mu.Lock()
-    defer store.mu.Unlock()
-	
+// Ist dpopKey gesetzt, sind die Tokens an diesen Schlüssel gebunden, er wird mit der Session gespeichert.
+func (store *SessionTokenStore) AddSession(token OAuthToken, user UserInfo, dpopKey *DPoPKey) (string, string) {
     sessionToken := generateSessionToken()
     csrfToken := generateCSRFTokenSource()
 
+	// Die Ablaufzeiten kommen aus der Antwort des Providers (im Demo-Modus verkürzt)
+	now := time.Now()
 	entry := SessionTokenData {
 		CSRFToken: 		      CSRFToken {Source: csrfToken,},
 		Token:                token,
-        //sehr kurze anfängliche TTL, um refresh tokens zu demonstrieren
-        AccessTokenExpiresAt: time.Now().Add(10 * time.Second), 
-        SessionExpiresAt:     time.Now().Add(store.ttl),
+		User:                 user,
+        AccessTokenExpiresAt: accessTokenExpiry(token, now),
+        SessionExpiresAt:     sessionExpiry(token, now, store.ttl),
+        LastActiveAt:         now,
+	}
+	if dpopKey != nil {
+		encoded, err := dpopKey.Encode()
+		if err != nil {
+			log.Printf("cannot store dpop key: %v\n", err)
+		}
+		entry.DPoPKey = encoded
 	}
-    store.tokens[sessionToken] = entry
+    if err := store.put(sessionToken, entry); err != nil {
+        // Ohne gespeicherte Session wird der Benutzer beim nächsten Aufruf wieder zum Login geleitet
+        log.Printf("cannot store session: %v\n", err)
+    }
 
     return sessionToken, csrfToken
 }
@@ -64,47 +128,199 @@ func (store *SessionTokenStore) AddToken(token OAuthToken) (string, string) {
 // GetToken ruft ein Access-Token aus dem Store anhand der ID ab.
 // Es wird geprüft, ob das Token existiert, und das Token sowie ein Existenz-Flag zurückgegeben.
 func (store *SessionTokenStore) GetToken(id string) (*OAuthToken, bool) {
-    store.mu.RLock()
-    defer store.mu.RUnlock()
-    entry, exists := store.tokens[id]
+    entry, exists := store.GetData(id)
     return &entry.Token, exists
 }
 
 // GetData ruft alle Session-Daten aus dem Store anhand der ID ab.
 // Es wird geprüft, ob die Daten existieren, und die Daten sowie ein Existenz-Flag zurückgegeben.
+// Abgelaufene Sessions existieren (bei allen Backends) nicht mehr.
 func (store *SessionTokenStore) GetData(id string) (*SessionTokenData, bool) {
-    store.mu.RLock()
-    defer store.mu.RUnlock()
-    entry, exists := store.tokens[id]
-    return &entry, exists
+    var entry SessionTokenData
+    value, exists, err := store.backend.Get(id)
+    if err != nil {
+        log.Printf("cannot load session: %v\n", err)
+        return &entry, false
+    }
+    if !exists {
+        return &entry, false
+    }
+    if err := json.Unmarshal(value, &entry); err != nil {
+        log.Printf("cannot decode session: %v\n", err)
+        return &SessionTokenData{}, false
+    }
+    return &entry, true
 }
 
-// RefreshAccess aktualisiert den Access-Token, falls möglich, anhand der ID.
+// RefreshAccess aktualisiert den Access-Token, falls möglich, anhand der ID, und gibt die aktuellen Session-Daten zurück.
 // Es wird versucht, den Access-Token mit einem Refresh-Token zu erneuern, falls der aktuelle Token abgelaufen ist.
-func (store *SessionTokenStore) RefreshAccess(id string) {
+// Pro Session läuft höchstens ein Refresh gleichzeitig (single-flight): Parallele Anfragen (z.B. zwei Tabs) warten
+// auf diesen Refresh und bekommen denselben neuen Token, so wird ein rotierter Refresh Token nie zweimal benutzt.
+// Zwischen mehreren Instanzen mit demselben Backend sorgt dafür eine Sperre im Backend (siehe lockRefresh).
+// Während der Anfrage an den Token-Endpunkt wird kein Lock gehalten, andere Sessions sind nicht blockiert.
+// Der Aufruf zählt als Aktivität des Benutzers (siehe RefreshScheduler).
+// Gibt false zurück, wenn die Session nicht (mehr) existiert.
+func (store *SessionTokenStore) RefreshAccess(id string) (*SessionTokenData, bool) {
+    result := store.refreshSingleFlight(id, 0, true)
+    return result.data, result.exists
+}
+
+// refreshSingleFlight erneuert den Access-Token, wenn er in weniger als lead abläuft. Läuft für die Session schon ein
+// Refresh, wird auf dessen Ergebnis gewartet. active markiert die Session als vom Benutzer benutzt.
+func (store *SessionTokenStore) refreshSingleFlight(id string, lead time.Duration, active bool) refreshResult {
     store.mu.Lock()
-    defer store.mu.Unlock()
-    data := store.tokens[id]
-    err := data.refreshAccessTokenIfPossible()
+    if call, running := store.refreshing[id]; running {
+        store.mu.Unlock()
+        <-call.done
+        return call.result
+    }
+    call := &refreshCall{done: make(chan struct{})}
+    store.refreshing[id] = call
+    store.mu.Unlock()
+
+    call.result = store.refresh(id, lead, active)
+
+    store.mu.Lock()
+    delete(store.refreshing, id)
+    store.mu.Unlock()
+    close(call.done)
+    return call.result
+}
+
+// refresh führt den eigentlichen Refresh einer Session aus (siehe refreshSingleFlight) und speichert das Ergebnis.
+// Bei einem Fehler bleiben die bisherigen Session-Daten erhalten.
+func (store *SessionTokenStore) refresh(id string, lead time.Duration, active bool) refreshResult {
+    data, exists := store.GetData(id)
+    if !exists {
+        return refreshResult{data: data}
+    }
+
+    var err error
+    refreshed := false
+    if data.accessExpiresWithin(lead) && data.Token.RefreshToken != "" {
+        // Mehrere Instanzen mit demselben Backend: nur die Instanz mit der Sperre löst den Refresh Token ein,
+        // danach gelten die Session-Daten aus dem Backend (vielleicht schon von einer anderen Instanz erneuert)
+        var unlock func()
+        data, exists, unlock, err = store.lockRefresh(id, lead)
+        if !exists {
+            return refreshResult{data: data, err: err}
+        }
+        if unlock != nil {
+            defer unlock()
+            refreshToken := data.Token.RefreshToken
+            err = data.refreshAccessTokenIfExpiring(lead)
+            if isInvalidGrant(err) {
+                // Der Refresh Token ist endgültig ungültig, die Session kann nicht mehr gerettet werden
+                log.Printf("refresh token rejected, ending session: %s\n", err.Error())
+                store.RemoveToken(id)
+                return refreshResult{data: data, err: err}
+            } else if err != nil {
+                // Vorübergehender Fehler: die Session bleibt, beim nächsten Mal wird es erneut versucht
+                log.Printf("cannot refresh Token: %s\n", err.Error())
+            } else {
+                refreshed = true
+                // Ein neuer Refresh Token verlängert die Session um seine Lebensdauer
+                if data.Token.RefreshToken != refreshToken {
+                    data.SessionExpiresAt = sessionExpiry(data.Token, time.Now(), store.ttl)
+                }
+            }
+        }
+    }
+
+    now := time.Now()
+    changed := refreshed || active && now.Sub(data.LastActiveAt) >= activityResolution
+    if active {
+        data.LastActiveAt = now
+    }
+    if !changed {
+        return refreshResult{data: data, exists: true, err: err}
+    }
+
+    // Wurde die Session während des Refreshs beendet (Logout), wird sie nicht wieder angelegt
+    if _, exists := store.GetData(id); !exists {
+        return refreshResult{data: data, refreshed: refreshed, err: err}
+    }
+    if err := store.put(id, *data); err != nil {
+        log.Printf("cannot store session: %v\n", err)
+    }
+    return refreshResult{data: data, exists: true, refreshed: refreshed, err: err}
+}
+
+// accessExpiresWithin prüft, ob der Access Token in weniger als lead abläuft.
+func (sessionData *SessionTokenData) accessExpiresWithin(lead time.Duration) bool {
+    return time.Now().Add(lead).After(sessionData.AccessTokenExpiresAt)
+}
+
+// ##############################################################################################
+// lockRefresh sperrt den Refresh einer Session im Backend (Eintrag unter refreshLockPrefix + id), damit auch
+// mehrere Instanzen des Clients einen rotierten Refresh Token nie zweimal einlösen. Hält eine andere Instanz die
+// Sperre, wird gewartet, bis sie frei ist oder die Session schon erneuert wurde (höchstens refreshLockWait).
+// Nach der Sperre wird die Session neu gelesen: ist der Refresh nicht mehr nötig, ist unlock nil.
+// Sonst muss der Aufrufer nach dem Speichern der neuen Tokens unlock aufrufen. Stürzt eine Instanz ab,
+// läuft die Sperre nach refreshLockTimeout von selbst ab.
+// ##############################################################################################
+
+func (store *SessionTokenStore) lockRefresh(id string, lead time.Duration) (data *SessionTokenData, exists bool, unlock func(), err error) {
+    key := refreshLockPrefix + id
+    // Der Wert kennzeichnet diese Sperre, eine abgelaufene und neu vergebene Sperre wird nicht freigegeben
+    owner := []byte(generateJti())
+    deadline := time.Now().Add(refreshLockWait)
+    for {
+        locked, err := store.backend.PutIfAbsent(key, owner, time.Now().Add(refreshLockTimeout))
+        data, exists := store.GetData(id)
+        if err != nil {
+            // Ohne Sperre wird nicht refresht, lieber später erneut versuchen als den Refresh Token doppelt einlösen
+            return data, exists, nil, fmt.Errorf("cannot lock session for refresh: %v", err)
+        }
+        if !exists || !data.accessExpiresWithin(lead) {
+            if locked {
+                store.unlockRefresh(key, owner)
+            }
+            return data, exists, nil, nil
+        }
+        if locked {
+            return data, true, func() { store.unlockRefresh(key, owner) }, nil
+        }
+        if time.Now().After(deadline) {
+            return data, true, nil, fmt.Errorf("session is being refreshed by another instance")
+        }
+        time.Sleep(refreshLockPoll)
+    }
+}
+
+// unlockRefresh gibt die Sperre von lockRefresh frei, falls sie noch dieser Instanz gehört.
+func (store *SessionTokenStore) unlockRefresh(key string, owner []byte) {
+    if err := store.backend.DeleteIf(key, owner); err != nil {
+        log.Printf("cannot unlock session after refresh: %v\n", err)
+    }
+}
+
+// Ids gibt die Ids aller nicht abgelaufenen Sessions zurück (ohne die Sperren der Refreshs).
+func (store *SessionTokenStore) Ids() ([]string, error) {
+    keys, err := store.backend.Keys()
     if err != nil {
-        log.Printf("cannot refresh Token: %s\n", err.Error())
+        return nil, err
+    }
+    ids := keys[:0]
+    for _, key := range keys {
+        if !strings.HasPrefix(key, refreshLockPrefix) {
+            ids = append(ids, key)
+        }
     }
-    store.tokens[id] = data
+    return ids, nil
 }
 
 // RemoveToken entfernt ein Access-Token aus dem Store anhand der Session-Token-ID.
 func (store *SessionTokenStore) RemoveToken(id string) {
-    store.mu.Lock()
-    defer store.mu.Unlock()
-    delete(store.tokens, id)
+    if err := store.backend.Delete(id); err != nil {
+        log.Printf("cannot remove session: %v\n", err)
+    }
 }
 
 // IsExpired prüft, ob ein bestimmter Session-Token abgelaufen ist.
 // Wenn der Token nicht existiert oder das Ablaufdatum überschritten wurde, wird true zurückgegeben.
 func (store *SessionTokenStore) IsExpired(id string) bool {
-    store.mu.RLock()
-    defer store.mu.RUnlock()
-    token, exists := store.tokens[id]
+    token, exists := store.GetData(id)
     if !exists {
         return true
     }
@@ -112,49 +328,65 @@ func (store *SessionTokenStore) IsExpired(id string) bool {
 }
 
 // CleanUp entfernt abgelaufene Tokens aus dem Store.
-// Es wird durch alle gespeicherten Tokens iteriert und die abgelaufenen Tokens werden gelöscht.
 func (s *SessionTokenStore) CleanUp() {
-    s.mu.Lock()
-    defer s.mu.Unlock()
-    for token, data := range s.tokens {
-        if time.Now().After(data.SessionExpiresAt) {
-            delete(s.tokens, token)
-        }
+    if err := s.backend.CleanUp(); err != nil {
+        log.Printf("cannot clean up sessions: %v\n", err)
     }
 }
 
-// NewSessionTokenStore erstellt eine neue Instanz von SessionTokenStore.
-// Es initialisiert den Store mit einer leeren Token-Map und einem TTL von 10 Minuten.
+// put speichert die Session-Daten als JSON, sie laufen mit der Session ab.
+func (store *SessionTokenStore) put(id string, data SessionTokenData) error {
+    value, err := json.Marshal(data)
+    if err != nil {
+        return err
+    }
+    return store.backend.Put(id, value, data.SessionExpiresAt)
+}
+
+// NewSessionTokenStore erstellt eine neue Instanz von SessionTokenStore im Speicher.
+// Ohne Angabe des Providers leben Sessions so lange wie RefreshTokenLifetime.
 func NewSessionTokenStore() *SessionTokenStore {
+    return NewSessionTokenStoreWithBackend(NewMemoryStoreBackend(), RefreshTokenLifetime)
+}
+
+// NewSessionTokenStoreWithBackend erstellt einen SessionTokenStore, der seine Sessions im gegebenen Backend speichert.
+func NewSessionTokenStoreWithBackend(backend StoreBackend, ttl time.Duration) *SessionTokenStore {
     return &SessionTokenStore{
-        tokens: make(map[string]SessionTokenData),
-		ttl: time.Minute * 10,
+        backend:    backend,
+        refreshing: make(map[string]*refreshCall),
+        ttl:        ttl,
     }
 }
 
 
 
 // zur Speicherung des Login-Zustands während des OAuth2-Authentifizierungsprozesses. 
-// Enthält den Code-Verifier und den Zeitpunkt der Erstellung.
+// Enthält den Code-Verifier, die nonce für den ID Token und den Zeitpunkt der Erstellung.
 type LoginState struct {
 	CodeVerifier string
+	Nonce        string
+	Issuer       string // Issuer des Providers, an den der Login gesendet wurde (RFC 9207)
 	CreatedAt    time.Time
 }
 
-// zur Verwaltung eines Stores für Login-Zustände. Enthält eine Map zur Speicherung der Zustände, 
-// einen Mutex zur Synchronisierung und eine TTL (time-to-live) für die Zustände.
+// zur Verwaltung eines Stores für Login-Zustände. Die Zustände werden als JSON in einem StoreBackend
+// gespeichert und laufen nach der TTL (time-to-live) ab.
 type LoginStateStore struct {
-    states map[string]LoginState
-    mu     sync.RWMutex
-	ttl    time.Duration
+    backend StoreBackend
+	ttl     time.Duration
 }
 
 
-// NewLoginStateStore erstellt einen neuen LoginStateStore mit einer gegebenen TTL (time-to-live).
+// NewLoginStateStore erstellt einen neuen LoginStateStore im Speicher mit einer gegebenen TTL (time-to-live).
 func NewLoginStateStore(ttl time.Duration) *LoginStateStore {
+    return NewLoginStateStoreWithBackend(NewMemoryStoreBackend(), ttl)
+}
+
+// NewLoginStateStoreWithBackend erstellt einen LoginStateStore, der die Zustände im gegebenen Backend speichert.
+func NewLoginStateStoreWithBackend(backend StoreBackend, ttl time.Duration) *LoginStateStore {
     return &LoginStateStore{
-        states: make(map[string]LoginState),
-        ttl:    ttl,
+        backend: backend,
+        ttl:     ttl,
     }
 }
 
@@ -163,12 +395,20 @@ func NewLoginStateStore(ttl time.Duration) *LoginStateStore {
 // Diese Methode wird verwendet, um OAuth2-Zustände während des Login-Prozesses zu speichern.
 
 func (s *LoginStateStore) AddState(state string, codeVerifier string) {
-    s.mu.Lock()
-    defer s.mu.Unlock()
-    s.states[state] = LoginState {
-		CreatedAt:    time.Now(),
-		CodeVerifier: codeVerifier,
-	}
+    s.AddLoginState(state, LoginState{CodeVerifier: codeVerifier})
+}
+
+// AddLoginState fügt einen vollständigen Login-Zustand (Code-Verifier und nonce) in den Store hinzu.
+// Der Zeitpunkt der Erstellung wird hier gesetzt, der Zustand läuft nach der TTL ab.
+func (s *LoginStateStore) AddLoginState(state string, loginState LoginState) {
+    loginState.CreatedAt = time.Now()
+    value, err := json.Marshal(loginState)
+    if err == nil {
+        err = s.backend.Put(state, value, loginState.CreatedAt.Add(s.ttl))
+    }
+    if err != nil {
+        log.Printf("cannot store login state: %v\n", err)
+    }
 }
 
 
@@ -176,15 +416,27 @@ func (s *LoginStateStore) AddState(state string, codeVerifier string) {
 // Diese Methode wird verwendet, um den Code-Verifier nach Abschluss des OAuth2-Authentifizierungsprozesses abzurufen.
 
 func (s *LoginStateStore) Retrieve(state string) string {
-    s.mu.Lock()
-    defer s.mu.Unlock()
-	loginState, exists := s.states[state]
-	var codeVerifier string
-    if exists {
-        codeVerifier = loginState.CodeVerifier
+	loginState, _ := s.RetrieveLoginState(state)
+	return loginState.CodeVerifier
+}
+
+// RetrieveLoginState entfernt einen Zustand (state) aus dem Store und gibt den vollständigen Login-Zustand zurück.
+// Jeder Zustand kann nur einmal abgerufen werden, auch wenn sich mehrere Instanzen ein Backend teilen.
+func (s *LoginStateStore) RetrieveLoginState(state string) (LoginState, bool) {
+    var loginState LoginState
+    value, exists, err := s.backend.Take(state)
+    if err != nil {
+        log.Printf("cannot load login state: %v\n", err)
+        return loginState, false
     }
-    delete(s.states, state)
-	return codeVerifier
+    if !exists {
+        return loginState, false
+    }
+    if err := json.Unmarshal(value, &loginState); err != nil {
+        log.Printf("cannot decode login state: %v\n", err)
+        return LoginState{}, false
+    }
+	return loginState, true
 }
 
 
@@ -192,22 +444,12 @@ func (s *LoginStateStore) Retrieve(state string) string {
 // Diese Methode wird verwendet, um sicherzustellen, dass ein Zustand während des OAuth2-Authentifizierungsprozesses gültig ist.
 
 func (s *LoginStateStore) Contains(state string) bool {
-    s.mu.RLock()
-    defer s.mu.RUnlock()
-    loginState, exists := s.states[state]
-    if !exists {
-        return false
-    }
-    if time.Since(loginState.CreatedAt) > s.ttl {
-        // Entfernt abgelaufene Zustände
-        s.mu.RUnlock()
-        s.mu.Lock()
-        delete(s.states, state)
-        s.mu.Unlock()
-        s.mu.RLock()
+    _, exists, err := s.backend.Get(state)
+    if err != nil {
+        log.Printf("cannot load login state: %v\n", err)
         return false
     }
-    return true
+    return exists
 }
 
 /* 
@@ -215,13 +457,8 @@ CleanUp entfernt abgelaufene Zustände aus dem Store.
 Diese Methode wird regelmäßig aufgerufen, um sicherzustellen, dass der Store nur gültige Zustände enthält.
 */
 func (s *LoginStateStore) CleanUp() {
-    s.mu.Lock()
-    defer s.mu.Unlock()
-    now := time.Now()
-    for state, loginState := range s.states {
-        if now.Sub(loginState.CreatedAt) > s.ttl {
-            delete(s.states, state)
-        }
+    if err := s.backend.CleanUp(); err != nil {
+        log.Printf("cannot clean up login states: %v\n", err)
     }
 }
 
@@ -230,47 +467,73 @@ func (s *LoginStateStore) CleanUp() {
 // Wenn der Access-Token abgelaufen ist, wird die Methode 'consumeRefreshToken' aufgerufen, um einen neuen Access-Token zu erhalten.
 // Wenn der Erneuerungsvorgang erfolgreich ist, werden die neuen Token-Daten aktualisiert und das Ablaufdatum des Access-Tokens neu gesetzt.
 func (sessionData *SessionTokenData) refreshAccessTokenIfPossible() error {
-	if time.Now().After(sessionData.AccessTokenExpiresAt) {
+	return sessionData.refreshAccessTokenIfExpiring(0)
+}
+
+// Wie refreshAccessTokenIfPossible, erneuert den Access-Token aber schon, wenn er in weniger als lead abläuft
+// (für den proaktiven Refresh im Hintergrund).
+func (sessionData *SessionTokenData) refreshAccessTokenIfExpiring(lead time.Duration) error {
+	// Ohne Refresh Token gibt es nichts zu erneuern, die Session endet mit dem Access Token
+	if sessionData.Token.RefreshToken == "" {
+		return nil
+	}
+	if time.Now().Add(lead).After(sessionData.AccessTokenExpiresAt) {
 		log.Println("Trying to use a Refresh Token")
-		newToken, err := consumeRefreshToken(sessionData.Token.RefreshToken)
+		newToken, err := consumeRefreshToken(sessionData.Token.RefreshToken, sessionData.dpopKey())
 		if err != nil {
 			return err
 		}
+		// Ein neuer ID Token muss zum selben Benutzer gehören
+		// (https://openid.net/specs/openid-connect-core-1_0.html#RefreshTokenResponse).
+		// Ohne neuen ID Token wird der bisherige behalten
+		if newToken.IdToken != "" {
+			claims, err := validateIdTokenWithoutNonce(newToken.IdToken)
+			if err != nil {
+				return err
+			}
+			if claims.Subject != sessionData.User.Subject {
+				return fmt.Errorf("refreshed id token belongs to a different subject")
+			}
+			sessionData.User = claims.User()
+		} else {
+			newToken.IdToken = sessionData.Token.IdToken
+		}
+		// Ohne neuen Refresh Token bleibt der bisherige gültig (RFC 6749 Abschnitt 6)
+		if newToken.RefreshToken == "" {
+			newToken.RefreshToken = sessionData.Token.RefreshToken
+			newToken.RefreshExpiresIn = sessionData.Token.RefreshExpiresIn
+		}
 		sessionData.Token = *newToken
-		sessionData.AccessTokenExpiresAt = time.Now().Add(250 * time.Second)
+		sessionData.AccessTokenExpiresAt = accessTokenExpiry(*newToken, time.Now())
 		log.Println("Refresh Successful")
 	}
 	return nil
 }
 
 // Diese Funktion verwendet ein Refresh-Token, um einen neuen OAuth-Access-Token zu erhalten.
-// Ein POST-Request wird an den Token-Endpunkt gesendet, wobei das Refresh-Token, der Client-Id, der Client-Secret und die Redirect-URI übermittelt werden.
+// Ein POST-Request wird an den Token-Endpunkt gesendet, wobei das Refresh-Token, der Client-Id und das Client-Secret übermittelt werden.
 // Wenn die Antwort erfolgreich ist, wird der neue Access-Token zurückgegeben, andernfalls wird ein Fehler ausgegeben.
-func consumeRefreshToken(refreshToken string) (*OAuthToken, error) {
+// Lehnt der Authorization Server den Refresh Token ab, ist der Fehler ein *OAuthError (siehe isInvalidGrant).
+// Ist der Refresh Token an einen DPoP-Schlüssel gebunden, wird mit dpopKey ein Proof gesendet.
+func consumeRefreshToken(refreshToken string, dpopKey *DPoPKey) (*OAuthToken, error) {
     // Baut die Anfrage
 	data := url.Values{}
 	data.Set("grant_type", "refresh_token")
 	data.Set("refresh_token", refreshToken)
-	data.Set("client_id", ClientId)
-	data.Set("client_secret", ClientSecret)
+	addResource(data)
 	//data.Set("redirect_uri", RedirectUrl)
 
-	req, err := http.NewRequest("POST", TokenUrl, bytes.NewBufferString(data.Encode()))
+    // Sendet die Anfrage mit Client-Authentifizierung (siehe client-auth.go)
+	resp, err := postClientRequest(tokenEndpoint(), data, dpopKey)
 	if err != nil {
-		return nil, fmt.Errorf("creating request: %v", err)
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-    // Sendet die Anfrage mit dem TLS-CLient
-	resp, err := Client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("making request: %v", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	// Ein *OAuthError mit invalid_grant heißt, der Refresh Token ist endgültig ungültig (abgelaufen, widerrufen
+	// oder schon benutzt). Alle anderen Fehler (Netzwerk, 5xx, ...) können vorübergehend sein
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("bad response status: %s, body: %s", resp.Status, string(bodyBytes))
+		return nil, readOAuthError(resp)
 	}
 
 	var tokenResp OAuthToken
@@ -312,4 +575,14 @@ func sessionStoreCleanupRoutine() {
 		time.Sleep(5 * time.Minute)
 		Sessions.CleanUp()
 	}
-}
\ No newline at end of file
+}
+
+// refreshStatsRoutine schreibt alle 5 Minuten die Zähler des Refreshs im Hintergrund ins Log.
+
+func refreshStatsRoutine() {
+	for {
+		time.Sleep(5 * time.Minute)
+		stats := Refresher.Stats()
+		log.Printf("background refresh: %d successful, %d failed, %d idle sessions skipped\n", stats.Successes, stats.Failures, stats.Idle)
+	}
+}
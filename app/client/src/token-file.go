@@ -0,0 +1,179 @@
+// ##############################################################################################
+// Hier steht die verschlüsselte Token-Datei der Kommandozeile (siehe notes-cli.go). Die Session
+// (Access-, Refresh- und ID Token, DPoP-Schlüssel) wird als JSON mit AES-256-GCM verschlüsselt und
+// nur für den Besitzer lesbar gespeichert. Der Schlüssel kommt aus TOKEN_KEY oder aus einer eigenen
+// Schlüsseldatei, die beim ersten Login angelegt wird. Liegt die Schlüsseldatei an einem anderen Ort
+// als die Token-Datei (z.B. auf einem USB-Stick), reicht eine Kopie der Token-Datei allein nicht aus.
+// ##############################################################################################
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Länge des Schlüssels für AES-256
+const tokenKeySize = 32
+
+// Zusätzliche Daten für GCM: eine Datei mit einem anderen Format oder einer anderen Version lässt sich nicht entschlüsseln
+var tokenFileAad = []byte("go-notes token file v1")
+
+// TokenFile speichert eine Session verschlüsselt unter path.
+type TokenFile struct {
+	path string
+	aead cipher.AEAD
+}
+
+// NewTokenFile erstellt eine Token-Datei mit dem 32 Byte langen Schlüssel key.
+func NewTokenFile(path string, key []byte) (*TokenFile, error) {
+	if len(key) != tokenKeySize {
+		return nil, fmt.Errorf("token key must be %d bytes, got %d", tokenKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenFile{path: path, aead: aead}, nil
+}
+
+// Load liest und entschlüsselt die Session. Gibt es noch keine Token-Datei, ist exists false.
+// Eine veränderte Datei oder ein falscher Schlüssel ist ein Fehler.
+func (f *TokenFile) Load() (data *SessionTokenData, exists bool, err error) {
+	raw, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	nonceSize := f.aead.NonceSize()
+	if len(raw) < nonceSize {
+		return nil, true, fmt.Errorf("token file %s is truncated", f.path)
+	}
+	plain, err := f.aead.Open(nil, raw[:nonceSize], raw[nonceSize:], tokenFileAad)
+	if err != nil {
+		// Der Inhalt wird nie ausgegeben, nur dass er nicht zum Schlüssel passt
+		return nil, true, fmt.Errorf("cannot decrypt token file %s: wrong key or modified file", f.path)
+	}
+
+	var session SessionTokenData
+	if err := json.Unmarshal(plain, &session); err != nil {
+		return nil, true, fmt.Errorf("decoding token file: %v", err)
+	}
+	return &session, true, nil
+}
+
+// Save verschlüsselt die Session mit einer neuen Nonce und ersetzt die Token-Datei. Es wird zuerst in eine
+// temporäre Datei im selben Verzeichnis geschrieben, damit bei einem Abbruch die alte Datei erhalten bleibt.
+func (f *TokenFile) Save(data SessionTokenData) error {
+	plain, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, f.aead.NonceSize())
+	if _, err := cryptorand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := f.aead.Seal(nonce, nonce, plain, tokenFileAad)
+	return writePrivateFile(f.path, sealed)
+}
+
+// Remove löscht die Token-Datei. Eine fehlende Datei ist kein Fehler.
+func (f *TokenFile) Remove() error {
+	if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ##############################################################################################
+// loadTokenKey gibt den Schlüssel der Token-Datei zurück: TokenKey (base64), falls gesetzt, sonst den Inhalt
+// von keyFile. Gibt es die Schlüsseldatei noch nicht, wird sie mit einem zufälligen Schlüssel angelegt.
+// ##############################################################################################
+
+func loadTokenKey(encoded string, keyFile string) ([]byte, error) {
+	if encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != tokenKeySize {
+			return nil, fmt.Errorf("token_key must be %d base64 encoded bytes", tokenKeySize)
+		}
+		return key, nil
+	}
+
+	raw, err := os.ReadFile(keyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, tokenKeySize)
+		if _, err := cryptorand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := writePrivateFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n")); err != nil {
+			return nil, fmt.Errorf("creating token key file: %v", err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(key) != tokenKeySize {
+		return nil, fmt.Errorf("token key file %s must contain %d base64 encoded bytes", keyFile, tokenKeySize)
+	}
+	return key, nil
+}
+
+// defaultTokenKeyFile ist der Schlüssel der Token-Datei im Konfigurationsverzeichnis des Benutzers
+// (z.B. ~/.config/notes-cli/token.key). Läge er neben der Token-Datei, hätte jeder, der ./data kopiert,
+// auch den Schlüssel. Ohne Konfigurationsverzeichnis (kein $HOME) bleibt nur ./data.
+func defaultTokenKeyFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "./data/notes-cli.key"
+	}
+	return filepath.Join(dir, "notes-cli", "token.key")
+}
+
+// writePrivateFile schreibt content atomar nach path, nur für den Besitzer les- und schreibbar (0600).
+// Fehlende Verzeichnisse werden mit 0700 angelegt.
+func writePrivateFile(path string, content []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// CreateTemp legt die Datei schon mit 0600 an, Chmod auch für Systeme mit anderer Voreinstellung
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
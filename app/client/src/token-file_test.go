@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ##############################################################################################
+// Tests für die verschlüsselte Token-Datei
+// ##############################################################################################
+
+func TestTokenFile(t *testing.T) {
+	dir := t.TempDir()
+	key, err := loadTokenKey("", filepath.Join(dir, "keys", "token.key"))
+	if err != nil {
+		t.Fatalf("Expected a new key, got %v", err)
+	}
+	tokenFile, err := NewTokenFile(filepath.Join(dir, "token"), key)
+	if err != nil {
+		t.Fatalf("Failed to create token file: %v", err)
+	}
+
+	if _, exists, err := tokenFile.Load(); exists || err != nil {
+		t.Fatalf("Expected no session before the first save, got %v, %v", exists, err)
+	}
+
+	session := SessionTokenData{
+		Token:            OAuthToken{AccessToken: "secret-access", RefreshToken: "secret-refresh", TokenType: "Bearer"},
+		User:             UserInfo{Subject: "user-1"},
+		SessionExpiresAt: time.Now().Add(time.Hour).Round(0),
+	}
+	if err := tokenFile.Save(session); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	// Die Tokens stehen nicht im Klartext in der Datei, die Datei ist nur für den Besitzer lesbar
+	raw, err := os.ReadFile(filepath.Join(dir, "token"))
+	if err != nil {
+		t.Fatalf("Failed to read token file: %v", err)
+	}
+	if bytes.Contains(raw, []byte("secret-refresh")) {
+		t.Errorf("Expected an encrypted token file")
+	}
+	for _, name := range []string{"token", filepath.Join("keys", "token.key")} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("Expected %s with mode 0600, got %v, %v", name, info.Mode().Perm(), err)
+		}
+	}
+
+	loaded, exists, err := tokenFile.Load()
+	if err != nil || !exists || loaded.Token.RefreshToken != "secret-refresh" || loaded.User.Subject != "user-1" || !loaded.SessionExpiresAt.Equal(session.SessionExpiresAt) {
+		t.Fatalf("Expected the saved session, got %+v, %v", loaded, err)
+	}
+
+	// Mit demselben Schlüssel aus der Schlüsseldatei lässt sich die Datei wieder öffnen
+	sameKey, err := loadTokenKey("", filepath.Join(dir, "keys", "token.key"))
+	if err != nil || !bytes.Equal(sameKey, key) {
+		t.Errorf("Expected the stored key, got %v", err)
+	}
+
+	// Ein anderer Schlüssel oder eine veränderte Datei werden erkannt, ohne den Inhalt auszugeben
+	otherKey, _ := loadTokenKey("", filepath.Join(dir, "other.key"))
+	other, _ := NewTokenFile(filepath.Join(dir, "token"), otherKey)
+	if _, _, err := other.Load(); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("Expected a decryption error with another key, got %v", err)
+	}
+	raw[len(raw)-1] ^= 1
+	if err := os.WriteFile(filepath.Join(dir, "token"), raw, 0600); err != nil {
+		t.Fatalf("Failed to modify token file: %v", err)
+	}
+	if _, _, err := tokenFile.Load(); err == nil {
+		t.Errorf("Expected an error for a modified token file")
+	}
+
+	if err := tokenFile.Remove(); err != nil {
+		t.Errorf("Failed to remove token file: %v", err)
+	}
+	if _, exists, _ := tokenFile.Load(); exists {
+		t.Errorf("Expected no session after remove")
+	}
+}
+
+func TestTokenKey(t *testing.T) {
+	// 32 Byte base64, z.B. aus "openssl rand -base64 32"
+	if key, err := loadTokenKey("MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE=", ""); err != nil || string(key) != "01234567890123456789012345678901" {
+		t.Errorf("Expected the configured key, got %v", err)
+	}
+	if _, err := loadTokenKey("c2hvcnQ=", ""); err == nil {
+		t.Errorf("Expected an error for a short key")
+	}
+}
+
+func TestDefaultTokenKeyFile(t *testing.T) {
+	// Der Schlüssel liegt im Konfigurationsverzeichnis des Benutzers, nicht neben der Token-Datei
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	keyFile := defaultTokenKeyFile()
+	if !strings.HasPrefix(keyFile, dir) || filepath.Dir(keyFile) == filepath.Dir(TokenFilePath) {
+		t.Errorf("Expected the key file in the user config directory %s, got %s", dir, keyFile)
+	}
+}
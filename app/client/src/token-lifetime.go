@@ -0,0 +1,85 @@
+// ##############################################################################################
+// Hier werden die Ablaufzeiten von Access Token und Session aus der Antwort des Token-Endpunkts
+// berechnet (expires_in, RFC 6749 Abschnitt 5.1, bzw. der exp Claim, wenn der Token ein JWT ist).
+// Im Demo-Modus werden die Lebensdauern künstlich verkürzt, um den Refresh zu zeigen.
+// ##############################################################################################
+
+package main
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// tokenLifetime bestimmt die Lebensdauer eines Tokens ab now: aus expiresIn (Sekunden) und, falls der
+// Token ein JWT ist, aus dem exp Claim. Sind beide vorhanden, gilt die kürzere.
+// Gibt false zurück, wenn keine Lebensdauer bekannt ist.
+func tokenLifetime(expiresIn int, raw string, now time.Time) (time.Duration, bool) {
+	var lifetime time.Duration
+	known := false
+	if expiresIn > 0 {
+		lifetime = time.Duration(expiresIn) * time.Second
+		known = true
+	}
+	if exp, ok := jwtExpiry(raw); ok {
+		if fromExp := exp.Sub(now); !known || fromExp < lifetime {
+			lifetime = fromExp
+			known = true
+		}
+	}
+	return lifetime, known
+}
+
+// jwtExpiry liest den exp Claim eines JWT ohne die Signatur zu prüfen. Der Client benutzt den Wert
+// nur, um zu entscheiden wann er erneuert, die Prüfung des Tokens ist Aufgabe des Resource Servers.
+func jwtExpiry(raw string) (time.Time, bool) {
+	if raw == "" {
+		return time.Time{}, false
+	}
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(raw, &claims); err != nil || claims.ExpiresAt == nil {
+		return time.Time{}, false
+	}
+	return claims.ExpiresAt.Time, true
+}
+
+// accessTokenExpiry berechnet, ab wann der Access Token erneuert werden soll: Lebensdauer minus
+// AccessTokenMargin (höchstens die Hälfte der Lebensdauer). Ohne bekannte Lebensdauer gilt DefaultAccessTokenLifetime.
+func accessTokenExpiry(token OAuthToken, now time.Time) time.Time {
+	lifetime, known := tokenLifetime(token.ExpiresIn, token.AccessToken, now)
+	if !known {
+		lifetime = DefaultAccessTokenLifetime
+	}
+	margin := AccessTokenMargin
+	if margin > lifetime/2 {
+		margin = lifetime / 2
+	}
+	if DemoMode && lifetime > DemoAccessTokenLifetime {
+		return now.Add(DemoAccessTokenLifetime)
+	}
+	return now.Add(lifetime - margin)
+}
+
+// sessionExpiry berechnet, wie lange die Session gültig ist. Mit Refresh Token entspricht das dessen
+// Lebensdauer (refresh_expires_in, exp Claim oder fallback, wenn der Provider nichts angibt),
+// ohne Refresh Token endet die Session mit dem Access Token.
+func sessionExpiry(token OAuthToken, now time.Time, fallback time.Duration) time.Time {
+	var lifetime time.Duration
+	var known bool
+	if token.RefreshToken != "" {
+		lifetime, known = tokenLifetime(token.RefreshExpiresIn, token.RefreshToken, now)
+		if !known {
+			lifetime = fallback
+		}
+	} else {
+		lifetime, known = tokenLifetime(token.ExpiresIn, token.AccessToken, now)
+		if !known {
+			lifetime = DefaultAccessTokenLifetime
+		}
+	}
+	if DemoMode && lifetime > DemoSessionLifetime {
+		lifetime = DemoSessionLifetime
+	}
+	return now.Add(lifetime)
+}
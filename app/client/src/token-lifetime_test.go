@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ##############################################################################################
+// Tests für die Berechnung der Ablaufzeiten
+// ##############################################################################################
+
+// Erstellt einen JWT mit exp. Die Signatur ist egal, der Client prüft sie für die Ablaufzeit nicht
+func mockJwtExpiringIn(lifetime time.Duration, now time.Time) string {
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
+	}).SignedString([]byte("secret"))
+	return token
+}
+
+func TestAccessTokenExpiry(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	tests := []struct {
+		name     string
+		token    OAuthToken
+		demo     bool
+		expected time.Duration
+	}{
+		{"expires_in minus margin", OAuthToken{AccessToken: "opaque", ExpiresIn: 300}, false, 300*time.Second - AccessTokenMargin},
+		{"exp claim of jwt", OAuthToken{AccessToken: mockJwtExpiringIn(10*time.Minute, now)}, false, 10*time.Minute - AccessTokenMargin},
+		{"shorter of expires_in and exp", OAuthToken{AccessToken: mockJwtExpiringIn(2*time.Minute, now), ExpiresIn: 600}, false, 2*time.Minute - AccessTokenMargin},
+		{"margin at most half the lifetime", OAuthToken{AccessToken: "opaque", ExpiresIn: 20}, false, 10 * time.Second},
+		{"default without lifetime", OAuthToken{AccessToken: "opaque"}, false, DefaultAccessTokenLifetime - AccessTokenMargin},
+		{"demo mode shortens", OAuthToken{AccessToken: "opaque", ExpiresIn: 300}, true, DemoAccessTokenLifetime},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DemoMode = tt.demo
+			defer func() { DemoMode = false }()
+			if got := accessTokenExpiry(tt.token, now).Sub(now); got != tt.expected {
+				t.Errorf("Expected access token to expire in %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	fallback := 24 * time.Hour
+	tests := []struct {
+		name     string
+		token    OAuthToken
+		demo     bool
+		expected time.Duration
+	}{
+		{"refresh_expires_in", OAuthToken{AccessToken: "opaque", ExpiresIn: 300, RefreshToken: "refresh", RefreshExpiresIn: 3600}, false, time.Hour},
+		{"exp claim of refresh token", OAuthToken{AccessToken: "opaque", RefreshToken: mockJwtExpiringIn(2*time.Hour, now)}, false, 2 * time.Hour},
+		{"fallback for opaque refresh token", OAuthToken{AccessToken: "opaque", ExpiresIn: 300, RefreshToken: "refresh"}, false, fallback},
+		{"access token lifetime without refresh token", OAuthToken{AccessToken: "opaque", ExpiresIn: 300}, false, 300 * time.Second},
+		{"demo mode shortens", OAuthToken{AccessToken: "opaque", RefreshToken: "refresh"}, true, DemoSessionLifetime},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DemoMode = tt.demo
+			defer func() { DemoMode = false }()
+			if got := sessionExpiry(tt.token, now, fallback).Sub(now); got != tt.expected {
+				t.Errorf("Expected session to expire in %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSessionCookieExpiry(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	server := mockIdTokenProvider(t, key)
+	defer server.Close()
+
+	// Beim Login gilt der Refresh Token 2 Stunden, nach der Rotation 4 Stunden
+	idToken := createMockIdToken(t, key, "test-key", nil)
+	server.Config.Handler.(*http.ServeMux).HandleFunc("/token/", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") == "refresh_token" {
+			json.NewEncoder(w).Encode(OAuthToken{AccessToken: "access-2", TokenType: "Bearer", ExpiresIn: 300, RefreshToken: "refresh-2", RefreshExpiresIn: 4 * 3600})
+			return
+		}
+		json.NewEncoder(w).Encode(OAuthToken{AccessToken: "access-1", TokenType: "Bearer", ExpiresIn: 300, RefreshToken: "refresh-1", RefreshExpiresIn: 2 * 3600, IdToken: idToken})
+	})
+	defer func(previous *SessionTokenStore) { Sessions = previous }(Sessions)
+	Sessions = NewSessionTokenStoreWithBackend(NewMemoryStoreBackend(), 24*time.Hour)
+	LoginStates = NewLoginStateStore(time.Minute)
+	LoginStates.AddLoginState("cookie-state", LoginState{CodeVerifier: "verifier", Nonce: "test-nonce", Issuer: Provider.Metadata().Issuer})
+
+	// Das Cookie läuft mit der Session ab, nicht nach festen 24 Stunden
+	rec := httptest.NewRecorder()
+	handleCallback(rec, httptest.NewRequest(http.MethodGet, "/oa/callback?code=abc&state=cookie-state", nil))
+	cookies := rec.Result().Cookies()
+	if rec.Code != http.StatusFound || len(cookies) != 1 {
+		t.Fatalf("Expected a redirect with the session cookie, got %v %v", rec.Code, cookies)
+	}
+	session, _ := Sessions.GetData(cookies[0].Value)
+	if !cookies[0].Expires.Equal(session.SessionExpiresAt.Truncate(time.Second)) || time.Until(cookies[0].Expires) < 119*time.Minute {
+		t.Errorf("Expected the cookie to expire with the session at %v, got %v", session.SessionExpiresAt, cookies[0].Expires)
+	}
+
+	// Ein rotierter Refresh Token verlängert Session und Cookie
+	session.AccessTokenExpiresAt = time.Now().Add(-time.Second)
+	if err := Sessions.put(cookies[0].Value, *session); err != nil {
+		t.Fatalf("Failed to store session: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/notes/toggle", strings.NewReader("csrf_token="+session.CSRFToken.Source))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	refreshed, ok := formSession(rec, req)
+	renewed := rec.Result().Cookies()
+	if !ok || refreshed.Token.RefreshToken != "refresh-2" || len(renewed) != 1 {
+		t.Fatalf("Expected a refreshed session with a renewed cookie, got %v %v", ok, renewed)
+	}
+	if time.Until(renewed[0].Expires) < 239*time.Minute || renewed[0].Value != cookies[0].Value {
+		t.Errorf("Expected the cookie to be extended to the new session expiry, got %v", renewed[0].Expires)
+	}
+}
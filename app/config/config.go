@@ -0,0 +1,307 @@
+// ##############################################################################################
+// Das config Paket lädt die Konfiguration von Client und Resource Server.
+// Die Dienste registrieren ihre Variablen (wie beim flag Paket), die Standardwerte sind die Werte
+// der Variablen. Danach werden die Werte in dieser Reihenfolge überschrieben (spätere gewinnen):
+//
+//  1. Standardwerte im Code
+//  2. Konfigurationsdatei (YAML oder JSON), angegeben mit -config oder CONFIG_FILE
+//  3. Umgebungsvariablen (z.B. PORT aus der docker-compose.yml)
+//  4. Flags auf der Kommandozeile (z.B. -client-id=...)
+//
+// Nach dem Laden wird die Konfiguration geprüft. Geheimnisse werden bei der Ausgabe nie angezeigt.
+// ##############################################################################################
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Herkunft eines Wertes, wird bei der Ausgabe angezeigt
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Option ist eine registrierte Einstellung. Die Methoden können verkettet werden:
+// cfg.String(&ClientSecret, "client_secret", "CLIENT_SECRET", "...").Secret().Required()
+type Option struct {
+	key      string
+	env      string
+	usage    string
+	secret   bool
+	required bool
+	value    value
+	source   string
+}
+
+// Secret markiert die Option als Geheimnis: der Wert wird bei der Ausgabe nicht angezeigt.
+func (option *Option) Secret() *Option {
+	option.secret = true
+	return option
+}
+
+// Required verlangt, dass die Option nach dem Laden nicht leer ist.
+func (option *Option) Required() *Option {
+	option.required = true
+	return option
+}
+
+// flagName ist der Name des Flags zur Option: aus "client_id" wird "-client-id"
+func (option *Option) flagName() string {
+	return strings.ReplaceAll(option.key, "_", "-")
+}
+
+// Config sammelt die Optionen eines Dienstes.
+type Config struct {
+	name       string
+	options    []*Option
+	byKey      map[string]*Option
+	validators []func() error
+
+	// Zugriff auf die Umgebung, in Tests austauschbar
+	LookupEnv func(string) (string, bool)
+}
+
+// New erstellt eine leere Konfiguration für den Dienst name.
+func New(name string) *Config {
+	return &Config{
+		name:      name,
+		byKey:     make(map[string]*Option),
+		LookupEnv: os.LookupEnv,
+	}
+}
+
+// add registriert eine Option. key ist der Name in der Datei, env die Umgebungsvariable (leer für keine).
+func (cfg *Config) add(key string, env string, usage string, v value) *Option {
+	if _, exists := cfg.byKey[key]; exists {
+		panic(fmt.Sprintf("config: option %q registered twice", key))
+	}
+	option := &Option{key: key, env: env, usage: usage, value: v, source: SourceDefault}
+	cfg.options = append(cfg.options, option)
+	cfg.byKey[key] = option
+	return option
+}
+
+// String registriert eine Text-Option, der aktuelle Wert von *p ist der Standardwert.
+func (cfg *Config) String(p *string, key string, env string, usage string) *Option {
+	return cfg.add(key, env, usage, stringValue{p})
+}
+
+// Int registriert eine ganzzahlige Option.
+func (cfg *Config) Int(p *int, key string, env string, usage string) *Option {
+	return cfg.add(key, env, usage, intValue{p})
+}
+
+// Bool registriert eine boolesche Option.
+func (cfg *Config) Bool(p *bool, key string, env string, usage string) *Option {
+	return cfg.add(key, env, usage, boolValue{p})
+}
+
+// Duration registriert eine Zeitdauer (z.B. "30s", "15m").
+func (cfg *Config) Duration(p *time.Duration, key string, env string, usage string) *Option {
+	return cfg.add(key, env, usage, durationValue{p})
+}
+
+// Strings registriert eine Liste (in der Datei eine Liste, sonst durch Kommas getrennt).
+func (cfg *Config) Strings(p *[]string, key string, env string, usage string) *Option {
+	return cfg.add(key, env, usage, stringsValue{p})
+}
+
+// Validate fügt eine Prüfung hinzu, die nach dem Laden ausgeführt wird.
+func (cfg *Config) Validate(validator func() error) {
+	cfg.validators = append(cfg.validators, validator)
+}
+
+// IsSet prüft, ob die Option nicht mehr ihren Standardwert hat (aus Datei, Umgebung oder Flag gesetzt).
+func (cfg *Config) IsSet(key string) bool {
+	option, exists := cfg.byKey[key]
+	return exists && option.source != SourceDefault
+}
+
+// Load lädt die Konfiguration aus Datei, Umgebung und den Flags in args (ohne Programmname) und prüft sie.
+// Zurückgegeben werden die übrigen Argumente nach den Flags (z.B. ein Unterbefehl).
+// Bei -h oder -help wird flag.ErrHelp zurückgegeben.
+func (cfg *Config) Load(args []string) ([]string, error) {
+	// Die Flags werden zuerst gelesen (für -config), aber erst ganz am Ende angewendet
+	flags := flag.NewFlagSet(cfg.name, flag.ContinueOnError)
+	configFile := flags.String("config", "", "path to a YAML or JSON config file (or CONFIG_FILE)")
+	setFlags := make(map[string]string)
+	for _, option := range cfg.options {
+		option := option
+		usage := option.usage
+		if option.env != "" {
+			usage += " (env " + option.env + ")"
+		}
+		flags.Var(&deferredFlag{option: option, set: setFlags}, option.flagName(), usage)
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage of %s:\n", cfg.name)
+		fmt.Fprintf(flags.Output(), "Precedence (later wins): defaults < config file < environment < flags\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// 2. Konfigurationsdatei
+	if *configFile == "" {
+		*configFile, _ = cfg.LookupEnv("CONFIG_FILE")
+	}
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, fmt.Errorf("config file %s: %v", *configFile, err)
+		}
+	}
+
+	// 3. Umgebungsvariablen
+	for _, option := range cfg.options {
+		if option.env == "" {
+			continue
+		}
+		if raw, exists := cfg.LookupEnv(option.env); exists {
+			if err := option.value.Set(raw); err != nil {
+				return nil, fmt.Errorf("environment variable %s: %v", option.env, err)
+			}
+			option.source = SourceEnv
+		}
+	}
+
+	// 4. Flags
+	for _, option := range cfg.options {
+		if raw, exists := setFlags[option.key]; exists {
+			if err := option.value.Set(raw); err != nil {
+				return nil, fmt.Errorf("flag -%s: %v", option.flagName(), err)
+			}
+			option.source = SourceFlag
+		}
+	}
+
+	return flags.Args(), cfg.validate()
+}
+
+// loadFile liest eine YAML- oder JSON-Datei mit den Optionen als Schlüssel. Unbekannte Schlüssel sind
+// ein Fehler, damit Tippfehler nicht unbemerkt bleiben.
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("unsupported file type, expected .yaml, .yml or .json")
+	}
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		option, exists := cfg.byKey[key]
+		if !exists {
+			return fmt.Errorf("unknown option %q", key)
+		}
+		if err := option.value.Set(fileValueString(values[key])); err != nil {
+			return fmt.Errorf("option %s: %v", key, err)
+		}
+		option.source = SourceFile
+	}
+	return nil
+}
+
+// fileValueString wandelt einen Wert aus der Datei in Text um, Listen werden durch Kommas getrennt
+func fileValueString(raw interface{}) string {
+	switch v := raw.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	case float64:
+		// JSON kennt nur Fließkommazahlen, 8080 soll als "8080" ankommen
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// validate prüft die Pflicht-Optionen und führt die Prüfungen des Dienstes aus. Alle Fehler werden gesammelt.
+func (cfg *Config) validate() error {
+	var errs []error
+	for _, option := range cfg.options {
+		if option.required && option.value.isZero() {
+			errs = append(errs, fmt.Errorf("%s is required", option.key))
+		}
+	}
+	for _, validator := range cfg.validators {
+		if err := validator(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Print gibt die wirksame Konfiguration mit der Herkunft jedes Wertes aus. Geheimnisse werden ersetzt.
+func (cfg *Config) Print(w io.Writer) {
+	fmt.Fprintf(w, "Effective configuration of %s:\n", cfg.name)
+	for _, option := range cfg.options {
+		shown := option.value.String()
+		if option.secret && shown != "" {
+			shown = "[REDACTED]"
+		}
+		fmt.Fprintf(w, "  %-30s = %-40s (%s)\n", option.key, shown, option.source)
+	}
+}
+
+// deferredFlag merkt sich den Wert eines Flags, angewendet wird er erst nach Datei und Umgebung
+type deferredFlag struct {
+	option *Option
+	set    map[string]string
+}
+
+func (f *deferredFlag) Set(raw string) error {
+	f.set[f.option.key] = raw
+	return nil
+}
+
+func (f *deferredFlag) String() string {
+	if f.option == nil {
+		return ""
+	}
+	if f.option.secret {
+		return ""
+	}
+	return f.option.value.String()
+}
+
+// IsBoolFlag erlaubt -demo-mode statt -demo-mode=true
+func (f *deferredFlag) IsBoolFlag() bool {
+	_, isBool := f.option.value.(boolValue)
+	return isBool
+}
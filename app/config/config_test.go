@@ -0,0 +1,144 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ##############################################################################################
+// Tests für das Laden der Konfiguration
+// ##############################################################################################
+
+// testSettings sind die Variablen eines Beispiel-Dienstes
+type testSettings struct {
+	ClientId   string
+	Secret     string
+	Port       string
+	Timeout    time.Duration
+	Demo       bool
+	Workers    int
+	Algorithms []string
+}
+
+func newTestConfig(settings *testSettings, env map[string]string) *Config {
+	cfg := New("test")
+	cfg.LookupEnv = func(key string) (string, bool) {
+		value, exists := env[key]
+		return value, exists
+	}
+	cfg.String(&settings.ClientId, "client_id", "CLIENT_ID", "client id").Required()
+	cfg.String(&settings.Secret, "client_secret", "CLIENT_SECRET", "client secret").Secret()
+	cfg.String(&settings.Port, "port", "PORT", "port")
+	cfg.Duration(&settings.Timeout, "timeout", "", "timeout")
+	cfg.Bool(&settings.Demo, "demo_mode", "DEMO_MODE", "demo mode")
+	cfg.Int(&settings.Workers, "workers", "WORKERS", "workers")
+	cfg.Strings(&settings.Algorithms, "algorithms", "ALGORITHMS", "algorithms")
+	return cfg
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+client_id: from-file
+port: "1000"
+timeout: 15s
+workers: 2
+algorithms: [RS256, ES256]
+`)
+	settings := testSettings{ClientId: "default", Port: "8089", Timeout: time.Second}
+	cfg := newTestConfig(&settings, map[string]string{"PORT": "2000", "CONFIG_FILE": file})
+
+	rest, err := cfg.Load([]string{"-port=3000", "-demo-mode", "migrate", "up"})
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	expected := testSettings{ClientId: "from-file", Port: "3000", Timeout: 15 * time.Second, Demo: true, Workers: 2, Algorithms: []string{"RS256", "ES256"}}
+	if settings.ClientId != expected.ClientId || settings.Port != expected.Port || settings.Timeout != expected.Timeout ||
+		settings.Demo != expected.Demo || settings.Workers != expected.Workers || strings.Join(settings.Algorithms, ",") != "RS256,ES256" {
+		t.Errorf("Expected %+v, got %+v", expected, settings)
+	}
+	if strings.Join(rest, " ") != "migrate up" {
+		t.Errorf("Expected remaining args 'migrate up', got %v", rest)
+	}
+	if !cfg.IsSet("port") || cfg.IsSet("client_secret") {
+		t.Errorf("Expected only changed options to be set")
+	}
+}
+
+func TestLoadJsonFileAndEnv(t *testing.T) {
+	file := writeFile(t, "config.json", `{"client_id": "from-json", "workers": 8080}`)
+	settings := testSettings{}
+	cfg := newTestConfig(&settings, map[string]string{"ALGORITHMS": "RS256, EdDSA", "WORKERS": "4"})
+
+	if _, err := cfg.Load([]string{"-config", file}); err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if settings.ClientId != "from-json" || settings.Workers != 4 || strings.Join(settings.Algorithms, ",") != "RS256,EdDSA" {
+		t.Errorf("Unexpected settings %+v", settings)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		env      map[string]string
+		args     []string
+		expected string
+	}{
+		{"unknown key in file", "client_id: x\nclinet_secret: y\n", nil, nil, `unknown option "clinet_secret"`},
+		{"invalid duration", "client_id: x\ntimeout: soon\n", nil, nil, "timeout"},
+		{"invalid env value", "client_id: x\n", map[string]string{"DEMO_MODE": "maybe"}, nil, "DEMO_MODE"},
+		{"required option missing", "port: \"1\"\n", nil, nil, "client_id is required"},
+		{"validator", "client_id: x\nworkers: -1\n", nil, nil, "workers must not be negative"},
+		{"unknown flag", "client_id: x\n", nil, []string{"-nope"}, "not defined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := testSettings{}
+			cfg := newTestConfig(&settings, tt.env)
+			cfg.Validate(func() error {
+				if settings.Workers < 0 {
+					return errors.New("workers must not be negative")
+				}
+				return nil
+			})
+			args := append([]string{"-config", writeFile(t, "config.yaml", tt.file)}, tt.args...)
+			_, err := cfg.Load(args)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	settings := testSettings{ClientId: "client", Secret: "very-secret"}
+	cfg := newTestConfig(&settings, map[string]string{"CLIENT_SECRET": "env-secret"})
+	if _, err := cfg.Load(nil); err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	var output bytes.Buffer
+	cfg.Print(&output)
+	if strings.Contains(output.String(), "env-secret") {
+		t.Errorf("Expected secret to be redacted, got:\n%s", output.String())
+	}
+	if !strings.Contains(output.String(), "[REDACTED]") || !strings.Contains(output.String(), "client") {
+		t.Errorf("Expected redacted secret and plain client id, got:\n%s", output.String())
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ##############################################################################################
+// Die Typen der Optionen. Jeder Typ schreibt direkt in die Variable des Dienstes,
+// Werte aus Datei, Umgebung und Flags kommen alle als Text über Set an.
+// ##############################################################################################
+
+// value ist ein Wert, der aus Text gesetzt und als Text ausgegeben werden kann (wie flag.Value)
+type value interface {
+	Set(string) error
+	String() string
+	isZero() bool
+}
+
+type stringValue struct{ p *string }
+
+func (v stringValue) Set(s string) error { *v.p = s; return nil }
+func (v stringValue) String() string     { return *v.p }
+func (v stringValue) isZero() bool       { return *v.p == "" }
+
+type intValue struct{ p *int }
+
+func (v intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", s)
+	}
+	*v.p = n
+	return nil
+}
+func (v intValue) String() string { return strconv.Itoa(*v.p) }
+func (v intValue) isZero() bool   { return *v.p == 0 }
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", s)
+	}
+	*v.p = b
+	return nil
+}
+func (v boolValue) String() string { return strconv.FormatBool(*v.p) }
+func (v boolValue) isZero() bool   { return !*v.p }
+
+type durationValue struct{ p *time.Duration }
+
+func (v durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration (e.g. 30s, 15m)", s)
+	}
+	*v.p = d
+	return nil
+}
+func (v durationValue) String() string { return v.p.String() }
+func (v durationValue) isZero() bool   { return *v.p == 0 }
+
+// stringsValue ist eine Liste, in Umgebung und Flags durch Kommas getrennt
+type stringsValue struct{ p *[]string }
+
+func (v stringsValue) Set(s string) error {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*v.p = list
+	return nil
+}
+func (v stringsValue) String() string { return strings.Join(*v.p, ",") }
+func (v stringsValue) isZero() bool   { return len(*v.p) == 0 }
@@ -1,9 +1,14 @@
 CREATE ROLE notes_user WITH LOGIN CREATEDB PASSWORD '123';
 CREATE SCHEMA notes_user AUTHORIZATION notes_user;
+-- Ausgangsschema. Alle weiteren Änderungen sind Migrationen des Resource Servers (notes/migrations)
 CREATE TABLE notes_user.notes (
     date DATE,
     owner VARCHAR(1555),
     text VARCHAR(1555),
     done BIT
 );
-ALTER TABLE notes_user.notes OWNER TO notes_user;
\ No newline at end of file
+ALTER TABLE notes_user.notes OWNER TO notes_user;
+-- Eigene Rolle und eigenes Schema für die Sessions des Clients (SessionStorage "postgres").
+-- Die Tabellen legt der Client selbst an, auf die Notizen hat er keinen Zugriff
+CREATE ROLE client_user WITH LOGIN PASSWORD '123';
+CREATE SCHEMA client_user AUTHORIZATION client_user;
@@ -0,0 +1,3 @@
+module jwk
+
+go 1.21
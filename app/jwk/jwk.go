@@ -0,0 +1,153 @@
+// ##############################################################################################
+// Das jwk Paket stellt öffentliche Schlüssel im JSON Web Key Format (https://datatracker.ietf.org/doc/html/rfc7517)
+// für Client und Resource Server dar: Umwandlung in und aus Go-Schlüsseln (RSA, ECDSA, Ed25519) und der
+// JWK Thumbprint (https://datatracker.ietf.org/doc/html/rfc7638). Laden und Zwischenspeichern der Key Sets
+// bleibt Sache der Dienste.
+// ##############################################################################################
+
+package jwk
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// Key repräsentiert einen öffentlichen Schlüssel im JWK Format.
+type Key struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// Set repräsentiert ein JWK Set, wie es unter einer jwks_uri ausgeliefert wird.
+type Set struct {
+	Keys []Key `json:"keys"`
+}
+
+// New wandelt einen öffentlichen Schlüssel (RSA, ECDSA oder Ed25519) in einen JWK um.
+// Die kid ist der Thumbprint des Schlüssels (siehe Thumbprint).
+func New(publicKey interface{}, alg string) (Key, error) {
+	var key Key
+	switch public := publicKey.(type) {
+	case *rsa.PublicKey:
+		key = Key{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		key = Key{
+			Kty: "EC",
+			Crv: public.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size))),
+		}
+	case ed25519.PublicKey:
+		key = Key{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(public),
+		}
+	default:
+		return Key{}, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+	key.Use = "sig"
+	key.Alg = alg
+	key.Kid = key.Thumbprint()
+	return key, nil
+}
+
+// ##############################################################################################
+// PublicKey wandelt den JWK in einen öffentlichen Schlüssel um, den die JWT Bibliothek versteht.
+// Unterstützt werden RSA, ECDSA (P-256, P-384, P-521) und EdDSA (Ed25519).
+// ##############################################################################################
+
+func (k *Key) PublicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("decoding modulus: %v", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("decoding exponent: %v", err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("decoding x: %v", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("decoding y: %v", err)
+		}
+		key := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("decoding x: %v", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key length: %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
+
+// Thumbprint berechnet den JWK Thumbprint: SHA-256 über die Pflichtfelder des Schlüssels in
+// lexikographischer Reihenfolge, base64url kodiert.
+func (k *Key) Thumbprint() string {
+	var canonical string
+	switch k.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, k.E, k.N)
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, k.Crv, k.X, k.Y)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, k.Crv, k.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,95 @@
+package jwk
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// ##############################################################################################
+// Tests für die Umwandlung der JWKs und den Thumbprint
+// ##############################################################################################
+
+func TestThumbprint(t *testing.T) {
+	// Beispiel aus RFC 7638 Abschnitt 3.1
+	var key Key
+	err := json.Unmarshal([]byte(`{
+		"kty": "RSA",
+		"n": "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		"e": "AQAB",
+		"alg": "RS256",
+		"kid": "2011-04-29"
+	}`), &key)
+	if err != nil {
+		t.Fatalf("Failed to parse key: %v", err)
+	}
+	if thumbprint := key.Thumbprint(); thumbprint != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("Expected the RFC 7638 thumbprint, got %v", thumbprint)
+	}
+}
+
+func TestNewAndPublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+
+	for _, publicKey := range []interface{}{&rsaKey.PublicKey, &ecKey.PublicKey, edKey} {
+		key, err := New(publicKey, "test")
+		if err != nil {
+			t.Fatalf("Expected a JWK for %T, got %v", publicKey, err)
+		}
+		if key.Kid != key.Thumbprint() || key.Use != "sig" || key.Alg != "test" {
+			t.Errorf("Expected kid = thumbprint, use and alg, got %+v", key)
+		}
+		parsed, err := key.PublicKey()
+		if err != nil || !reflect.DeepEqual(parsed, publicKey) {
+			t.Errorf("Expected the same public key for %T, got %v", publicKey, err)
+		}
+	}
+
+	if _, err := New("no key", ""); err == nil {
+		t.Errorf("Expected an error for an unsupported key type")
+	}
+}
+
+func TestPublicKeyErrors(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	valid, _ := New(&ecKey.PublicKey, "ES256")
+
+	offCurve := valid
+	offCurve.Y = valid.X
+	tests := []struct {
+		name string
+		key  Key
+	}{
+		{"point not on curve", offCurve},
+		{"unsupported curve", Key{Kty: "EC", Crv: "P-192", X: valid.X, Y: valid.Y}},
+		{"invalid base64", Key{Kty: "RSA", N: "not base64!", E: "AQAB"}},
+		{"short Ed25519 key", Key{Kty: "OKP", Crv: "Ed25519", X: "AQAB"}},
+		{"unsupported key type", Key{Kty: "oct"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.key.PublicKey(); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// ##############################################################################################
+// Hier steht die Prüfung von zertifikatsgebundenen Access Tokens (https://datatracker.ietf.org/doc/html/rfc8705#section-3).
+// Hat sich der Client am Token-Endpunkt mit mTLS authentifiziert, kann der Provider den Hash seines
+// Zertifikats als cnf.x5t#S256 in den Token schreiben. Der Token ist dann nur zusammen mit diesem
+// Zertifikat gültig, ein gestohlener Token nützt über eine andere TLS-Verbindung nichts.
+// ##############################################################################################
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+)
+
+// Confirmation ist der cnf Claim (https://datatracker.ietf.org/doc/html/rfc7800#section-3.1):
+// der Hash eines Client-Zertifikats (mTLS) oder der Thumbprint eines DPoP-Schlüssels (siehe dpop.go).
+type Confirmation struct {
+	X5tS256 string `json:"x5t#S256,omitempty"`
+	Jkt     string `json:"jkt,omitempty"`
+}
+
+// certificateThumbprint berechnet x5t#S256: SHA-256 über das DER-kodierte Zertifikat, base64url ohne Padding.
+func certificateThumbprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// checkCertificateBinding prüft, ob der Token an das Zertifikat gebunden ist, das der Aufrufer in der
+// TLS-Verbindung state vorgelegt hat. Tokens ohne Bindung werden nur mit RequireBoundTokens abgelehnt.
+func checkCertificateBinding(claims *AccessTokenClaims, state *tls.ConnectionState) error {
+	if claims.Confirmation == nil || claims.Confirmation.X5tS256 == "" {
+		if RequireBoundTokens {
+			return invalidToken("the token is not bound to a client certificate", nil)
+		}
+		return nil
+	}
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return invalidToken("the token is bound to a client certificate, but none was presented", nil)
+	}
+	if certificateThumbprint(state.PeerCertificates[0].Raw) != claims.Confirmation.X5tS256 {
+		return invalidToken("the token is bound to a different client certificate", errors.New("x5t#S256 mismatch"))
+	}
+	return nil
+}
+
+// serverTLSConfig fordert Client-Zertifikate an, ohne sie gegen eine CA zu prüfen: auch selbst signierte
+// Zertifikate (self_signed_tls_client_auth) sind erlaubt, die Bindung entsteht über den Hash im Token.
+// Clients ohne Zertifikat können sich weiterhin verbinden.
+func serverTLSConfig() *tls.Config {
+	return &tls.Config{
+		ClientAuth: tls.RequestClientCert,
+	}
+}
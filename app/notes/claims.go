@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// AccessTokenClaims repräsentiert die Claims eines Access Tokens (JWT).
+// Neben den registrierten Claims (iss, sub, aud, exp, nbf, iat) werden der Authentik-spezifische
+// "notes"-Claim und der scope gelesen. Fehlende Claims führen so zu leeren Werten statt zu einem panic.
+type AccessTokenClaims struct {
+	jwt.RegisteredClaims
+	Notes    string `json:"notes,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	ClientId string `json:"client_id,omitempty"`
+
+	// Bindung an ein Client-Zertifikat (RFC 8705, siehe cert-binding.go)
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+}
+
+// Scopes gibt die Scopes des Tokens als Liste zurück (der scope Claim ist durch Leerzeichen getrennt).
+func (claims *AccessTokenClaims) Scopes() []string {
+	return strings.Fields(claims.Scope)
+}
+
+// HasScope prüft, ob das Token den gegebenen Scope enthält.
+func (claims *AccessTokenClaims) HasScope(scope string) bool {
+	for _, s := range claims.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Fehlercodes für Bearer Tokens nach https://datatracker.ietf.org/doc/html/rfc6750#section-3.1
+const (
+	ErrorInvalidRequest    = "invalid_request"
+	ErrorInvalidToken      = "invalid_token"
+	ErrorInsufficientScope = "insufficient_scope"
+)
+
+// ##############################################################################################
+// TokenError beschreibt, warum ein Token abgelehnt wurde.
+// Code ist einer der Fehlercodes aus RFC 6750, Description eine kurze Begründung für den Client.
+// ##############################################################################################
+
+type TokenError struct {
+	Code        string
+	Description string
+	Err         error
+
+	// Schema der Challenge, in der der Fehler gemeldet wird (leer für "Bearer", "DPoP" für Proof-Fehler)
+	Scheme string
+
+	// Bei insufficient_scope der fehlende Scope (leer: RequiredScope)
+	Scope string
+}
+
+func (e *TokenError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Description, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+// invalidToken erstellt einen TokenError mit dem Code invalid_token.
+func invalidToken(description string, err error) *TokenError {
+	return &TokenError{Code: ErrorInvalidToken, Description: description, Err: err}
+}
+
+// Status gibt den HTTP-Status zum Fehlercode zurück.
+func (e *TokenError) Status() int {
+	switch e.Code {
+	case ErrorInvalidRequest:
+		return http.StatusBadRequest
+	case ErrorInsufficientScope:
+		return http.StatusForbidden
+	default:
+		return http.StatusUnauthorized
+	}
+}
+
+// withScheme setzt das Schema eines TokenErrors, wenn der Token mit dem Schema DPoP gesendet wurde.
+func withScheme(err error, scheme string) error {
+	var tokenErr *TokenError
+	if scheme == "DPoP" && errors.As(err, &tokenErr) && tokenErr.Scheme == "" {
+		tokenErr.Scheme = scheme
+	}
+	return err
+}
+
+// acceptedSchemes sind die Schemata, für die eine Challenge gesendet wird (abhängig von DPoPMode).
+func acceptedSchemes() []string {
+	switch DPoPMode {
+	case DPoPOff:
+		return []string{"Bearer"}
+	case DPoPRequired:
+		return []string{"DPoP"}
+	default:
+		return []string{"Bearer", "DPoP"}
+	}
+}
+
+// ##############################################################################################
+// writeAuthError beantwortet eine Anfrage mit abgelehntem oder fehlendem Token.
+// Nach https://datatracker.ietf.org/doc/html/rfc6750#section-3 wird ein WWW-Authenticate Header gesetzt,
+// mit DPoP eine Challenge pro angenommenem Schema (https://datatracker.ietf.org/doc/html/rfc9449#section-7.1).
+// Der Fehlercode steht nur in der Challenge des Schemas, mit dem der Token gesendet wurde.
+// Ohne TokenError (z.B. fehlender Authorization Header) wird kein Fehlercode mitgesendet.
+// ##############################################################################################
+
+func writeAuthError(w http.ResponseWriter, err error) {
+	schemes := acceptedSchemes()
+	status := http.StatusUnauthorized
+	message := err.Error()
+	errorScheme, errorParams := "", ""
+
+	var tokenErr *TokenError
+	if errors.As(err, &tokenErr) {
+		errorScheme = tokenErr.Scheme
+		if errorScheme == "" {
+			errorScheme = "Bearer"
+		}
+		// Der Fehler kommt immer in eine der gesendeten Challenges
+		if DPoPMode == DPoPRequired {
+			errorScheme = "DPoP"
+		} else if DPoPMode == DPoPOff {
+			errorScheme = "Bearer"
+		}
+		errorParams = fmt.Sprintf(`, error="%s", error_description="%s"`,
+			tokenErr.Code, strings.ReplaceAll(tokenErr.Description, `"`, `'`))
+		scope := tokenErr.Scope
+		if scope == "" {
+			scope = RequiredScope
+		}
+		if tokenErr.Code == ErrorInsufficientScope && scope != "" {
+			errorParams += fmt.Sprintf(`, scope="%s"`, scope)
+		}
+		status = tokenErr.Status()
+		message = tokenErr.Description
+	}
+
+	for _, scheme := range schemes {
+		challenge := fmt.Sprintf(`%s realm="%s"`, scheme, Realm)
+		if scheme == "DPoP" {
+			challenge += fmt.Sprintf(`, algs="%s"`, strings.Join(DPoPAlgorithms, " "))
+		}
+		if scheme == errorScheme {
+			challenge += errorParams
+		}
+		w.Header().Add("WWW-Authenticate", challenge)
+	}
+	http.Error(w, message, status)
+}
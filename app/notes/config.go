@@ -0,0 +1,115 @@
+// ##############################################################################################
+// Hier wird die Konfiguration des Resource Servers geladen (siehe Paket config): Die Variablen aus
+// main.go sind die Standardwerte, sie werden von Konfigurationsdatei, Umgebungsvariablen und Flags überschrieben.
+// ##############################################################################################
+
+package main
+
+import (
+	"config"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// loadConfig registriert alle Einstellungen des Resource Servers, lädt sie und gibt die wirksame
+// Konfiguration aus. Zurückgegeben werden die übrigen Argumente (z.B. "migrate up").
+func loadConfig(args []string) ([]string, error) {
+	cfg := config.New("notes")
+
+	cfg.String(&Port, "port", "PORT", "port the resource server listens on").Required()
+	cfg.String(&CertFile, "cert_file", "CERT_FILE", "tls certificate of the resource server").Required()
+	cfg.String(&KeyFile, "key_file", "KEY_FILE", "tls private key of the resource server").Required()
+	cfg.String(&CaCertFile, "ca_cert_file", "CA_CERT_FILE", "ca certificate of the provider").Required()
+
+	// Speicher
+	cfg.String(&StorageBackend, "storage_backend", "STORAGE_BACKEND", "postgres, sqlite or memory")
+	cfg.String(&SqlitePath, "sqlite_path", "SQLITE_PATH", "database file of the sqlite backend")
+	cfg.String(&DbHost, "db_host", "DB_HOST", "postgres host")
+	cfg.String(&DbPort, "db_port", "DB_PORT", "postgres port")
+	cfg.String(&DbUser, "db_user", "DB_USER", "postgres user")
+	cfg.String(&DbPassword, "db_password", "DB_PASSWORD", "postgres password").Secret()
+	cfg.String(&DbName, "db_name", "DB_NAME", "postgres database")
+	cfg.Bool(&AutoMigrate, "auto_migrate", "AUTO_MIGRATE", "apply pending migrations on startup")
+
+	// Validierung der Access Tokens
+	cfg.String(&ClientId, "client_id", "CLIENT_ID", "OAuth client id of the notes application").Required()
+	cfg.String(&ResourceIndicator, "resource_indicator", "RESOURCE_INDICATOR", "resource indicator of the notes api, expected in the aud claim")
+	cfg.Bool(&LegacyNotesClaim, "legacy_notes_claim", "LEGACY_NOTES_CLAIM", "also accept tokens with aud = audience and the notes claim")
+	cfg.String(&ResourceId, "resource_id", "RESOURCE_ID", "expected value of the notes claim (legacy_notes_claim)")
+	cfg.String(&Issuer, "issuer", "ISSUER", "expected iss claim").Required()
+	cfg.String(&Audience, "audience", "AUDIENCE", "expected aud claim with legacy_notes_claim (defaults to client_id)")
+	cfg.Duration(&ClockSkew, "clock_skew", "CLOCK_SKEW", "allowed clock skew for exp, nbf and iat")
+	cfg.String(&Realm, "realm", "REALM", "realm in the WWW-Authenticate header")
+	cfg.Strings(&AllowedAlgorithms, "allowed_algorithms", "ALLOWED_ALGORITHMS", "accepted signature algorithms").Required()
+	cfg.String(&RequiredScope, "required_scope", "REQUIRED_SCOPE", "scope every token must contain")
+	cfg.String(&JwksUrl, "jwks_url", "JWKS_URL", "jwks of the issuer, must be under the issuer (default: jwks_uri from the issuer metadata)")
+	cfg.String(&JwksFile, "jwks_file", "JWKS_FILE", "read the jwks from this file instead of jwks_url")
+	cfg.Duration(&JwksTTL, "jwks_ttl", "JWKS_TTL", "reload the jwks after this time")
+	cfg.Duration(&JwksRefetch, "jwks_refetch", "JWKS_REFETCH", "minimum time between reloads for unknown kids")
+	cfg.String(&DPoPMode, "dpop_mode", "DPOP_MODE", "DPoP proof of possession: off, optional or required")
+	cfg.Strings(&DPoPAlgorithms, "dpop_algorithms", "DPOP_ALGORITHMS", "accepted signature algorithms of DPoP proofs").Required()
+	cfg.Duration(&DPoPProofWindow, "dpop_proof_window", "DPOP_PROOF_WINDOW", "maximum age of a DPoP proof")
+	cfg.Bool(&ServicePrincipalMode, "service_principal_mode", "SERVICE_PRINCIPAL_MODE", "accept client credentials tokens of service_principals")
+	cfg.Strings(&ServicePrincipals, "service_principals", "SERVICE_PRINCIPALS", "client ids allowed as service principals")
+	cfg.String(&ServiceReadScope, "service_read_scope", "SERVICE_READ_SCOPE", "scope a service principal needs to read notes")
+	cfg.String(&ServiceWriteScope, "service_write_scope", "SERVICE_WRITE_SCOPE", "scope a service principal needs to change notes")
+	cfg.Bool(&RequireBoundTokens, "require_bound_tokens", "REQUIRE_BOUND_TOKENS", "reject tokens without cnf.x5t#S256 certificate binding")
+
+	cfg.Validate(validateConfig)
+
+	rest, err := cfg.Load(args)
+	if err != nil {
+		return nil, err
+	}
+	// Die erwartete Audience ist die Client Id, solange sie nicht extra gesetzt ist
+	if cfg.IsSet("client_id") && !cfg.IsSet("audience") {
+		Audience = ClientId
+	}
+	cfg.Print(os.Stdout)
+	return rest, nil
+}
+
+// validateConfig prüft die Werte, die nicht nur gesetzt sein müssen.
+func validateConfig() error {
+	var errs []error
+	switch StorageBackend {
+	case "postgres", "sqlite", "memory":
+	default:
+		errs = append(errs, fmt.Errorf("storage_backend must be postgres, sqlite or memory, got %q", StorageBackend))
+	}
+	switch DPoPMode {
+	case DPoPOff, DPoPOptional, DPoPRequired:
+	default:
+		errs = append(errs, fmt.Errorf("dpop_mode must be off, optional or required, got %q", DPoPMode))
+	}
+	if DPoPProofWindow <= 0 {
+		errs = append(errs, fmt.Errorf("dpop_proof_window must be positive"))
+	}
+	if LegacyNotesClaim && ResourceId == "" {
+		errs = append(errs, fmt.Errorf("resource_id is required with legacy_notes_claim"))
+	}
+	if !LegacyNotesClaim && ResourceIndicator == "" {
+		errs = append(errs, fmt.Errorf("resource_indicator is required without legacy_notes_claim"))
+	}
+	if ServicePrincipalMode && (len(ServicePrincipals) == 0 || ServiceReadScope == "" || ServiceWriteScope == "") {
+		errs = append(errs, fmt.Errorf("service_principal_mode requires service_principals, service_read_scope and service_write_scope"))
+	}
+	if JwksUrl != "" && !jwksUrlUnderIssuer(JwksUrl, Issuer) {
+		errs = append(errs, fmt.Errorf("jwks_url must be under the issuer %q, got %q", Issuer, JwksUrl))
+	}
+	for _, algorithm := range AllowedAlgorithms {
+		if algorithm == "none" || algorithm[0] == 'H' {
+			errs = append(errs, fmt.Errorf("allowed_algorithms must not contain %q", algorithm))
+		}
+	}
+	for _, algorithm := range DPoPAlgorithms {
+		if algorithm == "none" || algorithm[0] == 'H' {
+			errs = append(errs, fmt.Errorf("dpop_algorithms must not contain %q", algorithm))
+		}
+	}
+	if ClockSkew < 0 || JwksTTL <= 0 {
+		errs = append(errs, fmt.Errorf("clock_skew must not be negative and jwks_ttl must be positive"))
+	}
+	return errors.Join(errs...)
+}
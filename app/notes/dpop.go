@@ -0,0 +1,208 @@
+// ##############################################################################################
+// Hier steht die Prüfung von DPoP Proofs (https://datatracker.ietf.org/doc/html/rfc9449#section-4.3).
+// Ein DPoP-gebundener Access Token enthält den Thumbprint des Schlüssels des Clients (cnf.jkt). Er wird
+// mit dem Schema "DPoP" gesendet, zusammen mit einem Proof: einem mit diesem Schlüssel signierten JWT
+// über Methode, URL und Hash des Tokens. Ohne den privaten Schlüssel ist ein gestohlener Token wertlos.
+// ##############################################################################################
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"jwk"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Modi für DPoP
+const (
+	DPoPOff      = "off"      // nur Bearer Tokens, das Schema DPoP wird nicht angenommen
+	DPoPOptional = "optional" // Bearer und DPoP Tokens, gebundene Tokens aber nur mit gültigem Proof
+	DPoPRequired = "required" // nur DPoP-gebundene Tokens
+)
+
+// Fehlercode für ungültige Proofs (RFC 9449 Abschnitt 7.1)
+const ErrorInvalidDPoPProof = "invalid_dpop_proof"
+
+// invalidDPoPProof erstellt einen TokenError mit dem Code invalid_dpop_proof.
+func invalidDPoPProof(description string, err error) *TokenError {
+	return &TokenError{Code: ErrorInvalidDPoPProof, Description: description, Err: err, Scheme: "DPoP"}
+}
+
+// DPoPProofClaims sind die Claims eines Proofs (RFC 9449 Abschnitt 4.2).
+type DPoPProofClaims struct {
+	Jti   string `json:"jti"`
+	Htm   string `json:"htm"`
+	Htu   string `json:"htu"`
+	Iat   int64  `json:"iat"`
+	Ath   string `json:"ath"`
+	Nonce string `json:"nonce,omitempty"`
+}
+
+func (claims *DPoPProofClaims) Valid() error {
+	return nil
+}
+
+// ##############################################################################################
+// checkDPoPBinding prüft die Bindung des Access Tokens an einen DPoP-Schlüssel, passend zum Schema
+// des Authorization Headers und zu DPoPMode.
+// ##############################################################################################
+
+func checkDPoPBinding(r *http.Request, scheme string, accessToken string, claims *AccessTokenClaims) error {
+	jkt := ""
+	if claims.Confirmation != nil {
+		jkt = claims.Confirmation.Jkt
+	}
+
+	if scheme != "DPoP" {
+		// Ein gebundener Token darf nie als Bearer Token benutzt werden (RFC 9449 Abschnitt 7.2)
+		if jkt != "" {
+			return &TokenError{Code: ErrorInvalidToken, Description: "the token is DPoP-bound and must be sent with the DPoP scheme", Scheme: "DPoP"}
+		}
+		if DPoPMode == DPoPRequired {
+			return &TokenError{Code: ErrorInvalidToken, Description: "only DPoP-bound tokens are accepted", Scheme: "DPoP"}
+		}
+		return nil
+	}
+
+	thumbprint, err := validateDPoPProof(r, accessToken, time.Now())
+	if err != nil {
+		return err
+	}
+	if jkt == "" {
+		return &TokenError{Code: ErrorInvalidToken, Description: "the token is not DPoP-bound", Scheme: "DPoP"}
+	}
+	if jkt != thumbprint {
+		return &TokenError{Code: ErrorInvalidToken, Description: "the token is bound to a different DPoP key", Scheme: "DPoP"}
+	}
+	return nil
+}
+
+// ##############################################################################################
+// validateDPoPProof prüft den Proof im DPoP Header der Anfrage nach RFC 9449 Abschnitt 4.3 und gibt
+// den Thumbprint seines Schlüssels zurück. Geprüft werden typ, alg, jwk, Signatur, htm, htu, iat,
+// ath und dass die jti nicht schon benutzt wurde.
+// ##############################################################################################
+
+func validateDPoPProof(r *http.Request, accessToken string, now time.Time) (string, error) {
+	proofs := r.Header.Values("DPoP")
+	if len(proofs) != 1 {
+		return "", invalidDPoPProof("exactly one DPoP proof is required", nil)
+	}
+
+	parser := jwt.NewParser(jwt.WithValidMethods(DPoPAlgorithms))
+	var claims DPoPProofClaims
+	var publicJwk jwk.Key
+	_, err := parser.ParseWithClaims(proofs[0], &claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, fmt.Errorf("typ must be dpop+jwt")
+		}
+		raw, err := json.Marshal(token.Header["jwk"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwk")
+		}
+		// Der Header darf nur den öffentlichen Schlüssel enthalten
+		var fields map[string]interface{}
+		if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+			return nil, fmt.Errorf("jwk header missing")
+		}
+		if _, private := fields["d"]; private {
+			return nil, fmt.Errorf("jwk header contains a private key")
+		}
+		if err := json.Unmarshal(raw, &publicJwk); err != nil {
+			return nil, fmt.Errorf("invalid jwk")
+		}
+		return publicJwk.PublicKey()
+	})
+	if err != nil {
+		return "", invalidDPoPProof("the DPoP proof signature is invalid or the proof is malformed", err)
+	}
+
+	if claims.Htm != r.Method {
+		return "", invalidDPoPProof("htm does not match the request method", nil)
+	}
+	if !sameHtu(claims.Htu, requestUrl(r)) {
+		return "", invalidDPoPProof("htu does not match the request url", nil)
+	}
+	issuedAt := time.Unix(claims.Iat, 0)
+	if issuedAt.Before(now.Add(-DPoPProofWindow-ClockSkew)) || issuedAt.After(now.Add(ClockSkew)) {
+		return "", invalidDPoPProof("the DPoP proof is too old or issued in the future", nil)
+	}
+	sum := sha256.Sum256([]byte(accessToken))
+	if claims.Ath != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		return "", invalidDPoPProof("ath does not match the access token", nil)
+	}
+	if claims.Jti == "" || !DPoPReplays.Add(claims.Jti, issuedAt.Add(DPoPProofWindow+2*ClockSkew), now) {
+		return "", invalidDPoPProof("the DPoP proof was already used", nil)
+	}
+	return publicJwk.Thumbprint(), nil
+}
+
+// requestUrl ist die URL der Anfrage ohne Query und Fragment, wie sie der Client in htu einsetzt.
+func requestUrl(r *http.Request) string {
+	scheme := "https"
+	if r.TLS == nil {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+// sameHtu vergleicht htu mit der URL der Anfrage (Schema und Host ohne Groß-/Kleinschreibung,
+// Query und Fragment werden ignoriert, RFC 9449 Abschnitt 4.3).
+func sameHtu(htu string, expected string) bool {
+	got, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	want, err := url.Parse(expected)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(got.Scheme, want.Scheme) && strings.EqualFold(got.Host, want.Host) && got.EscapedPath() == want.EscapedPath()
+}
+
+// ##############################################################################################
+// ReplayCache merkt sich die jti der Proofs, bis sie ohnehin zu alt wären. Er liegt im Speicher,
+// bei mehreren Instanzen des Resource Servers hat jede ihren eigenen.
+// ##############################################################################################
+
+type ReplayCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewReplayCache erstellt einen leeren ReplayCache.
+func NewReplayCache() *ReplayCache {
+	return &ReplayCache{seen: make(map[string]time.Time)}
+}
+
+// Add trägt jti bis expiresAt ein und gibt false zurück, wenn sie schon bekannt ist.
+func (c *ReplayCache) Add(jti string, expiresAt time.Time, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Abgelaufene Einträge werden höchstens einmal pro Sekunde entfernt
+	if now.Sub(c.lastSweep) > time.Second {
+		for key, expiry := range c.seen {
+			if now.After(expiry) {
+				delete(c.seen, key)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if expiry, exists := c.seen[jti]; exists && !now.After(expiry) {
+		return false
+	}
+	c.seen[jti] = expiresAt
+	return true
+}
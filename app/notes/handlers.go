@@ -1,111 +1,203 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
+	"regexp"
 	"strings"
-	"encoding/json"
-	_ "github.com/lib/pq"
 )
 
+// ##############################################################################################
+// newRouter legt die HTTP-Handler für die verschiedenen Endpunkte fest.
+// Alle /notes Routen laufen durch die Authentifizierungs-Middleware.
+// ##############################################################################################
+
+func newRouter() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("/notes", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			handleGetNotes(w, r) // Handhabt GET-Anfragen zum Abrufen (und Suchen) von Notizen
+		case http.MethodPost:
+			handleCreateNote(w, r) // Handhabt POST-Anfragen zum Erstellen von Notizen
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	// Veraltet: Löschen anhand des Textes, nur noch zur Kompatibilität
+	mux.Handle("/notes/delete", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			handleDeleteNoteByText(w, r) // Handhabt DELETE-Anfragen zum Löschen von Notizen
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	// Einzelne Notizen: /notes/{id}
+	mux.Handle("/notes/", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := noteIdFromPath(r.URL.Path)
+		if !ok {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			handleGetNote(w, r, id) // Handhabt GET-Anfragen zum Abrufen einer Notiz
+		case http.MethodPut:
+			handleReplaceNote(w, r, id) // Handhabt PUT-Anfragen zum Ersetzen einer Notiz
+		case http.MethodPatch:
+			handlePatchNote(w, r, id) // Handhabt PATCH-Anfragen zum Ändern einzelner Felder
+		case http.MethodDelete:
+			handleDeleteNote(w, r, id) // Handhabt DELETE-Anfragen zum Löschen einer Notiz
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	return mux
+}
+
 // ##############################################################################################
 // handleGetNotes verarbeitet GET-Anfragen, um Notizen abzurufen.
-// Überprüft das Authorization-Header, validiert das JWT und gibt die Notizen des Benutzers zurück.
+// Der Benutzer kommt aus dem Principal, den die Middleware (requireAuth) in den Kontext gelegt hat.
+// Mit dem Query-Parameter q werden nur Notizen zurückgegeben, deren Text q enthält.
 // ##############################################################################################
 
 func handleGetNotes(w http.ResponseWriter, r *http.Request) {
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, "Authorization header missing", http.StatusUnauthorized)
-		return
-	}
-	accessToken := strings.TrimPrefix(authHeader, "Bearer ")
-	owner, valid := validateJwt(accessToken, PublicKey)
-	if !valid {
-		http.Error(w, "Token invalid", http.StatusUnauthorized)
-	}
+	principal, _ := PrincipalFromContext(r.Context())
 
-	// Abfrage der Notizen des Benutzers aus der Datenbank
-	rows, err := Db.Query("SELECT date, text, done, owner FROM notes_user.notes WHERE owner = $1", owner)
+	var notes []Note
+	var err error
+	if query := r.URL.Query().Get("q"); query != "" {
+		notes, err = Notes.Search(r.Context(), principal.Owner(), query)
+	} else {
+		notes, err = Notes.List(r.Context(), principal.Owner())
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	var notes []Note
-	for rows.Next() {
-		var note Note
-		var doneByte byte
-		if err := rows.Scan(&note.Date, &note.Text, &doneByte, &note.Owner); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		note.Done = doneByte == 1
-		notes = append(notes, note)
+	writeJson(w, http.StatusOK, notes)
+}
+
+
+// ##############################################################################################
+// handleCreateNote verarbeitet POST-Anfragen, um neue Notizen zu erstellen.
+// Der Benutzer kommt aus dem Principal im Kontext. Speichert eine neue Notiz
+// und gibt sie mit der vergebenen Id zurück.
+// ##############################################################################################
+
+func handleCreateNote(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+
+	var note Note
+	if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	note.Owner = principal.Owner()
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(notes); err != nil {
+	created, err := Notes.Create(r.Context(), note)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+
+	w.Header().Set("Location", "/notes/"+created.Id)
+	writeJson(w, http.StatusCreated, created)
 }
 
 
 // ##############################################################################################
-// handleCreateNote verarbeitet POST-Anfragen, um neue Notizen zu erstellen.
-// Überprüft das Authorization-Header, validiert das JWT und fügt eine neue Notiz in die Datenbank ein.
+// handleGetNote verarbeitet GET /notes/{id} und gibt eine einzelne Notiz des Benutzers zurück.
 // ##############################################################################################
 
-func handleCreateNote(w http.ResponseWriter, r *http.Request) {
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, "Authorization header missing", http.StatusUnauthorized)
+func handleGetNote(w http.ResponseWriter, r *http.Request, id string) {
+	principal, _ := PrincipalFromContext(r.Context())
+
+	note, err := Notes.Get(r.Context(), principal.Owner(), id)
+	if err != nil {
+		writeRepositoryError(w, err)
 		return
 	}
-	accessToken := strings.TrimPrefix(authHeader, "Bearer ")
-	owner, valid := validateJwt(accessToken, PublicKey)
-	if !valid {
-		http.Error(w, "Token invalid", http.StatusUnauthorized)
-	}
 
+	writeJson(w, http.StatusOK, note)
+}
+
+
+// ##############################################################################################
+// handleReplaceNote verarbeitet PUT /notes/{id} und ersetzt Datum, Text und Status einer Notiz.
+// ##############################################################################################
+
+func handleReplaceNote(w http.ResponseWriter, r *http.Request, id string) {
 	var note Note
 	if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	note.Owner = owner
-	doneByte := 0
-	if note.Done {
-		doneByte = 1
+	updateNote(w, r, id, NotePatch{Date: &note.Date, Text: &note.Text, Done: &note.Done})
+}
+
+
+// ##############################################################################################
+// handlePatchNote verarbeitet PATCH /notes/{id} und ändert nur die übergebenen Felder einer Notiz.
+// ##############################################################################################
+
+func handlePatchNote(w http.ResponseWriter, r *http.Request, id string) {
+	var patch NotePatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	updateNote(w, r, id, patch)
+}
 
-	// Einfügen der Notiz in die Datenbank
-	_, err := Db.Exec("INSERT INTO notes_user.notes (date, text, done, owner) VALUES ($1, $2, $3, $4)", note.Date, note.Text, doneByte, note.Owner)
+// updateNote speichert die gesetzten Felder des Patches und gibt die geänderte Notiz zurück.
+// Nicht gesetzte Felder (nil) behalten ihren Wert.
+func updateNote(w http.ResponseWriter, r *http.Request, id string, patch NotePatch) {
+	principal, _ := PrincipalFromContext(r.Context())
+
+	note, err := Notes.Update(r.Context(), principal.Owner(), id, patch)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeRepositoryError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	writeJson(w, http.StatusOK, note)
 }
 
 
 // ##############################################################################################
-// handleDeleteNote verarbeitet DELETE-Anfragen, um Notizen zu löschen.
-// Überprüft das Authorization-Header, validiert das JWT und löscht die Notiz aus der Datenbank.
+// handleDeleteNote verarbeitet DELETE /notes/{id} und löscht genau eine Notiz des Benutzers.
 // ##############################################################################################
 
-func handleDeleteNote(w http.ResponseWriter, r *http.Request) {
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, "Authorization header missing", http.StatusUnauthorized)
+func handleDeleteNote(w http.ResponseWriter, r *http.Request, id string) {
+	principal, _ := PrincipalFromContext(r.Context())
+
+	if err := Notes.Delete(r.Context(), principal.Owner(), id); err != nil {
+		writeRepositoryError(w, err)
 		return
 	}
-	accessToken := strings.TrimPrefix(authHeader, "Bearer ")
-	owner, valid := validateJwt(accessToken, PublicKey)
-	if !valid {
-		http.Error(w, "Token invalid", http.StatusUnauthorized)
-	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+
+// ##############################################################################################
+// handleDeleteNoteByText verarbeitet DELETE /notes/delete?text=..., um Notizen anhand des Textes zu löschen.
+// Veraltet: löscht alle Notizen mit diesem Text. Bleibt nur zur Kompatibilität, neue Clients
+// benutzen DELETE /notes/{id}.
+// ##############################################################################################
+
+func handleDeleteNoteByText(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+
+	// Kennzeichnet die Route für Clients als veraltet
+	w.Header().Set("Deprecation", "true")
 
 	text := r.URL.Query().Get("text")
 	if text == "" {
@@ -113,12 +205,43 @@ func handleDeleteNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Löschen der Notiz aus der Datenbank
-	_, err := Db.Exec("DELETE FROM notes_user.notes WHERE text = $1 AND owner = $2", text, owner)
-	if err != nil {
+	if err := Notes.DeleteByText(r.Context(), principal.Owner(), text); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
-}
\ No newline at end of file
+}
+
+
+// ##############################################################################################
+// Hilfsfunktionen für die Handler
+// ##############################################################################################
+
+// noteIdPattern beschreibt eine UUID, wie sie als Id der Notizen vergeben wird.
+var noteIdPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// noteIdFromPath liest die Id aus einem Pfad der Form /notes/{id}.
+func noteIdFromPath(path string) (string, bool) {
+	id := strings.TrimPrefix(path, "/notes/")
+	if id == path || !noteIdPattern.MatchString(id) {
+		return "", false
+	}
+	return strings.ToLower(id), true
+}
+
+// writeRepositoryError beantwortet eine Anfrage mit 404, wenn die Notiz nicht existiert, sonst mit 500.
+func writeRepositoryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNoteNotFound) {
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// writeJson schreibt die Antwort als JSON mit dem gegebenen Status.
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ##############################################################################################
+// Tests für die REST-Routen mit dem In-Memory Repository
+// ##############################################################################################
+
+// Hilfsfunktion für eine authentifizierte Anfrage an den Router
+func doRequest(t *testing.T, router http.Handler, token string, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	var reader bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&reader).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &reader)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestNotesRoutes(t *testing.T) {
+	privateKey, err := createPrivateKey()
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	Keys = StaticKey{&privateKey.PublicKey}
+	Notes = NewMemoryNoteRepository()
+	router := newRouter()
+	alice := createMockToken(ResourceId, "alice", privateKey)
+	bob := createMockToken(ResourceId, "bob", privateKey)
+
+	// Erstellen
+	rec := doRequest(t, router, alice, http.MethodPost, "/notes", Note{Date: time.Now(), Text: "Buy milk"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %v", rec.Code)
+	}
+	var created Note
+	json.NewDecoder(rec.Body).Decode(&created)
+	if created.Id == "" || created.Owner != "alice" || rec.Header().Get("Location") != "/notes/"+created.Id {
+		t.Fatalf("Expected created note with id and location, got %+v", created)
+	}
+
+	// Lesen, auch für andere Benutzer nicht sichtbar
+	if rec := doRequest(t, router, alice, http.MethodGet, "/notes/"+created.Id, nil); rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %v", rec.Code)
+	}
+	if rec := doRequest(t, router, bob, http.MethodGet, "/notes/"+created.Id, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for other owner, got %v", rec.Code)
+	}
+
+	// PATCH ändert nur die übergebenen Felder
+	rec = doRequest(t, router, alice, http.MethodPatch, "/notes/"+created.Id, map[string]interface{}{"done": true})
+	var patched Note
+	json.NewDecoder(rec.Body).Decode(&patched)
+	if rec.Code != http.StatusOK || !patched.Done || patched.Text != "Buy milk" {
+		t.Errorf("Expected patched note, got %v %+v", rec.Code, patched)
+	}
+
+	// PUT ersetzt alle Felder
+	rec = doRequest(t, router, alice, http.MethodPut, "/notes/"+created.Id, Note{Date: time.Now(), Text: "Buy bread"})
+	var replaced Note
+	json.NewDecoder(rec.Body).Decode(&replaced)
+	if rec.Code != http.StatusOK || replaced.Done || replaced.Text != "Buy bread" {
+		t.Errorf("Expected replaced note, got %v %+v", rec.Code, replaced)
+	}
+
+	// Suche
+	rec = doRequest(t, router, alice, http.MethodGet, "/notes?q=bread", nil)
+	var found []Note
+	json.NewDecoder(rec.Body).Decode(&found)
+	if len(found) != 1 {
+		t.Errorf("Expected 1 search result, got %v", len(found))
+	}
+
+	// Ungültige Ids und falsche Methoden
+	if rec := doRequest(t, router, alice, http.MethodGet, "/notes/not-a-uuid", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for invalid id, got %v", rec.Code)
+	}
+	if rec := doRequest(t, router, alice, http.MethodPost, "/notes/"+created.Id, nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %v", rec.Code)
+	}
+
+	// Löschen
+	if rec := doRequest(t, router, bob, http.MethodDelete, "/notes/"+created.Id, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 when deleting foreign note, got %v", rec.Code)
+	}
+	if rec := doRequest(t, router, alice, http.MethodDelete, "/notes/"+created.Id, nil); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %v", rec.Code)
+	}
+
+	// Veraltete Route zum Löschen anhand des Textes
+	doRequest(t, router, alice, http.MethodPost, "/notes", Note{Date: time.Now(), Text: "Old client"})
+	rec = doRequest(t, router, alice, http.MethodDelete, "/notes/delete?text=Old+client", nil)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Deprecation") == "" {
+		t.Errorf("Expected status 204 with Deprecation header, got %v", rec.Code)
+	}
+	if notes, _ := Notes.List(nil, "alice"); len(notes) != 0 {
+		t.Errorf("Expected no notes left, got %v", len(notes))
+	}
+}
+
+func TestServicePrincipalNotes(t *testing.T) {
+	defer func(mode bool, principals []string) { ServicePrincipalMode, ServicePrincipals = mode, principals }(ServicePrincipalMode, ServicePrincipals)
+	ServicePrincipalMode = true
+	ServicePrincipals = []string{"batch-job"}
+
+	privateKey, err := createPrivateKey()
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	Keys = StaticKey{&privateKey.PublicKey}
+	Notes = NewMemoryNoteRepository()
+	router := newRouter()
+
+	serviceToken := func(clientId string, scope string) string {
+		return createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) {
+			c["sub"], c["client_id"], c["scope"] = clientId, clientId, scope
+		})
+	}
+	service := serviceToken("batch-job", "notes:read notes:write")
+	readOnly := serviceToken("batch-job", "notes:read")
+	unknown := serviceToken("other-job", "notes:read notes:write")
+	// Ein Benutzer mit demselben Subject wie der Dienst
+	user := createMockToken(ResourceId, "batch-job", privateKey)
+	spoofed := createMockToken(ResourceId, ServiceOwnerPrefix+"batch-job", privateKey)
+
+	rec := doRequest(t, router, user, http.MethodPost, "/notes", Note{Date: time.Now(), Text: "user note"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %v", rec.Code)
+	}
+	var userNote Note
+	json.NewDecoder(rec.Body).Decode(&userNote)
+
+	// Der Dienst schreibt unter seinem eigenen Besitzer
+	rec = doRequest(t, router, service, http.MethodPost, "/notes", Note{Date: time.Now(), Text: "service note"})
+	var serviceNote Note
+	json.NewDecoder(rec.Body).Decode(&serviceNote)
+	if rec.Code != http.StatusCreated || serviceNote.Owner != ServiceOwnerPrefix+"batch-job" {
+		t.Fatalf("Expected a service-owned note, got %v %+v", rec.Code, serviceNote)
+	}
+
+	// Benutzer und Dienst sehen nur ihre eigenen Notizen
+	for _, tt := range []struct {
+		token    string
+		expected string
+	}{{service, "service note"}, {readOnly, "service note"}, {user, "user note"}} {
+		var notes []Note
+		rec := doRequest(t, router, tt.token, http.MethodGet, "/notes", nil)
+		json.NewDecoder(rec.Body).Decode(&notes)
+		if rec.Code != http.StatusOK || len(notes) != 1 || notes[0].Text != tt.expected {
+			t.Errorf("Expected only %q, got %v %+v", tt.expected, rec.Code, notes)
+		}
+	}
+	if rec := doRequest(t, router, service, http.MethodGet, "/notes/"+userNote.Id, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a user note, got %v", rec.Code)
+	}
+
+	// Ohne Schreib-Scope, mit unbekannter Client Id oder mit reserviertem Subject gibt es keinen Zugriff
+	rec = doRequest(t, router, readOnly, http.MethodDelete, "/notes/"+serviceNote.Id, nil)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Header().Get("WWW-Authenticate"), `scope="notes:write"`) {
+		t.Errorf("Expected insufficient_scope for notes:write, got %v %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+	if rec := doRequest(t, router, unknown, http.MethodGet, "/notes", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for an unknown service principal, got %v", rec.Code)
+	}
+	if rec := doRequest(t, router, spoofed, http.MethodGet, "/notes", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a reserved subject, got %v", rec.Code)
+	}
+
+	// Ohne Service-Principal-Modus werden Maschinen-Tokens abgelehnt
+	ServicePrincipalMode = false
+	if rec := doRequest(t, router, service, http.MethodGet, "/notes", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 without service principal mode, got %v", rec.Code)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"jwk"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ##############################################################################################
+// KeySource liefert den öffentlichen Schlüssel zur JWT-Validierung anhand der Key-Id (kid) und des
+// Algorithmus (alg) aus dem JWT-Header.
+// ##############################################################################################
+
+type KeySource interface {
+	Key(kid string, alg string) (interface{}, error)
+}
+
+// cachedKey ist ein geparster Schlüssel zusammen mit dem Algorithmus, für den er freigegeben ist.
+type cachedKey struct {
+	key interface{}
+	alg string
+}
+
+// ##############################################################################################
+// JwksCache hält die Schlüssel des Issuers im Speicher.
+// Die Schlüssel werden entweder von einer URL (jwks_uri) oder aus einer lokalen Datei geladen.
+// Nach Ablauf der TTL wird das Set neu geladen. Bei einer unbekannten kid wird sofort neu geladen,
+// aber höchstens einmal pro minRefetch, damit Tokens mit erfundenen kids den Issuer nicht fluten.
+// Geladen wird ohne den Mutex, damit eine langsame Antwort des Issuers die Prüfung von Tokens mit
+// bekannter kid nicht blockiert. Es läuft immer höchstens ein Ladevorgang, weitere Aufrufer warten darauf.
+// ##############################################################################################
+
+type JwksCache struct {
+	url        string
+	file       string
+	keys       map[string]cachedKey
+	fetchedAt  time.Time
+	ttl        time.Duration
+	minRefetch time.Duration
+	loading    *jwksLoad
+	mu         sync.Mutex
+}
+
+// jwksLoad ist ein laufender Ladevorgang, done wird nach dem Laden geschlossen.
+type jwksLoad struct {
+	done chan struct{}
+	err  error
+}
+
+// NewRemoteJwksCache erstellt einen JwksCache, der das Key Set von der gegebenen URL lädt.
+func NewRemoteJwksCache(url string, ttl time.Duration, minRefetch time.Duration) *JwksCache {
+	return &JwksCache{
+		url:        url,
+		keys:       make(map[string]cachedKey),
+		ttl:        ttl,
+		minRefetch: minRefetch,
+	}
+}
+
+// NewFileJwksCache erstellt einen JwksCache, der das Key Set aus einer lokalen Datei lädt (für Offline-Setups).
+func NewFileJwksCache(file string, ttl time.Duration, minRefetch time.Duration) *JwksCache {
+	return &JwksCache{
+		file:       file,
+		keys:       make(map[string]cachedKey),
+		ttl:        ttl,
+		minRefetch: minRefetch,
+	}
+}
+
+// Key gibt den Schlüssel zur kid zurück und prüft, ob er für den Algorithmus des Tokens freigegeben ist.
+func (c *JwksCache) Key(kid string, alg string) (interface{}, error) {
+	// Abgelaufenes Key Set neu laden. Schlägt das fehl, werden die alten Schlüssel weiter benutzt
+	c.mu.Lock()
+	expired := time.Since(c.fetchedAt) > c.ttl
+	c.mu.Unlock()
+	if expired {
+		if err := c.refresh(); err != nil {
+			log.Printf("Error refreshing jwks: %v", err)
+		}
+	}
+
+	c.mu.Lock()
+	entry, ok := c.lookup(kid)
+	refetch := !ok && time.Since(c.fetchedAt) > c.minRefetch
+	c.mu.Unlock()
+	if refetch {
+		// Unbekannte kid: der Issuer hat vermutlich einen neuen Schlüssel
+		if err := c.refresh(); err != nil {
+			log.Printf("Error refreshing jwks: %v", err)
+		}
+		c.mu.Lock()
+		entry, ok = c.lookup(kid)
+		c.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id '%s'", kid)
+	}
+	if entry.alg != "" && entry.alg != alg {
+		return nil, fmt.Errorf("key '%s' is not allowed for algorithm %s", kid, alg)
+	}
+	return entry.key, nil
+}
+
+// Load lädt das Key Set sofort. Wird beim Start benutzt, damit Konfigurationsfehler früh auffallen.
+func (c *JwksCache) Load() error {
+	return c.refresh()
+}
+
+// lookup sucht den Schlüssel im Cache. Fehlt die kid im Token, wird nur ein einzelner Schlüssel akzeptiert.
+// Der Aufrufer muss den Mutex halten.
+func (c *JwksCache) lookup(kid string) (cachedKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, entry := range c.keys {
+			return entry, true
+		}
+	}
+	entry, ok := c.keys[kid]
+	return entry, ok
+}
+
+// refresh lädt das Key Set von der Quelle und ersetzt den Cache. Läuft schon ein Ladevorgang, wird auf
+// dessen Ergebnis gewartet statt eine zweite Anfrage zu senden. Der Mutex wird während des Ladens nicht gehalten.
+func (c *JwksCache) refresh() error {
+	c.mu.Lock()
+	if load := c.loading; load != nil {
+		c.mu.Unlock()
+		<-load.done
+		return load.err
+	}
+	load := &jwksLoad{done: make(chan struct{})}
+	c.loading = load
+	// Auch ein fehlgeschlagener Versuch zählt für minRefetch
+	c.fetchedAt = time.Now()
+	c.mu.Unlock()
+
+	keys, err := c.load()
+
+	c.mu.Lock()
+	if err == nil {
+		c.keys = keys
+	}
+	c.loading = nil
+	c.mu.Unlock()
+
+	load.err = err
+	close(load.done)
+	return err
+}
+
+// load liest das Key Set aus der Datei oder von der URL und parst die Signaturschlüssel.
+func (c *JwksCache) load() (map[string]cachedKey, error) {
+	var keySet jwk.Set
+	var err error
+	if c.file != "" {
+		keySet, err = readJwksFile(c.file)
+	} else {
+		keySet, err = fetchJwks(c.url)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]cachedKey)
+	for _, webKey := range keySet.Keys {
+		// Nur Signaturschlüssel sind relevant
+		if webKey.Use != "" && webKey.Use != "sig" {
+			continue
+		}
+		key, err := webKey.PublicKey()
+		if err != nil {
+			log.Printf("Skipping jwk '%s': %v", webKey.Kid, err)
+			continue
+		}
+		keys[webKey.Kid] = cachedKey{key: key, alg: webKey.Alg}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+// fetchJwks lädt ein Key Set mit dem TLS-Client von der gegebenen URL.
+func fetchJwks(url string) (jwk.Set, error) {
+	var keySet jwk.Set
+	resp, err := Client.Get(url)
+	if err != nil {
+		return keySet, fmt.Errorf("fetching jwks: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return keySet, fmt.Errorf("bad response status from %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&keySet); err != nil {
+		return keySet, fmt.Errorf("decoding jwks: %v", err)
+	}
+	return keySet, nil
+}
+
+// discoverJwksUrl lädt die OpenID-Metadaten des Issuers und gibt deren jwks_uri zurück. Der Issuer in den
+// Metadaten muss exakt dem konfigurierten entsprechen
+// (https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationValidation).
+func discoverJwksUrl(issuer string) (string, error) {
+	url := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := Client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("fetching provider metadata: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad response status from %s: %s", url, resp.Status)
+	}
+	var metadata struct {
+		Issuer  string `json:"issuer"`
+		JwksUri string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return "", fmt.Errorf("decoding provider metadata: %v", err)
+	}
+	if metadata.Issuer != issuer {
+		return "", fmt.Errorf("issuer mismatch: expected '%s', provider reports '%s'", issuer, metadata.Issuer)
+	}
+	if metadata.JwksUri == "" {
+		return "", fmt.Errorf("provider metadata has no jwks_uri")
+	}
+	return metadata.JwksUri, nil
+}
+
+// jwksUrlUnderIssuer prüft, ob eine fest eingestellte jwks_url unter dem Issuer liegt (gleiches Schema,
+// gleicher Host, Pfad unterhalb des Issuers). So zeigt sie nach einem Wechsel des Issuers nicht unbemerkt
+// auf den alten Provider.
+func jwksUrlUnderIssuer(jwksUrl string, issuer string) bool {
+	jwks, err := neturl.Parse(jwksUrl)
+	if err != nil {
+		return false
+	}
+	iss, err := neturl.Parse(issuer)
+	if err != nil {
+		return false
+	}
+	prefix := strings.TrimSuffix(iss.Path, "/") + "/"
+	return jwks.Scheme == iss.Scheme && jwks.Host == iss.Host && strings.HasPrefix(jwks.Path, prefix)
+}
+
+// readJwksFile liest ein Key Set aus einer lokalen JSON-Datei.
+func readJwksFile(file string) (jwk.Set, error) {
+	var keySet jwk.Set
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return keySet, err
+	}
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		return keySet, fmt.Errorf("decoding jwks file: %v", err)
+	}
+	return keySet, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"jwk"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ##############################################################################################
+// Unit Tests für den JwksCache
+// ##############################################################################################
+
+// Hilfsfunktionen um Schlüssel als JWK darzustellen
+func ecJwk(kid string, key *ecdsa.PrivateKey) jwk.Key {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return jwk.Key{
+		Kty: "EC",
+		Kid: kid,
+		Crv: key.Curve.Params().Name,
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+func edJwk(kid string, key ed25519.PrivateKey) jwk.Key {
+	return jwk.Key{
+		Kty: "OKP",
+		Kid: kid,
+		Crv: "Ed25519",
+		X:   base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+	}
+}
+
+// Hilfsfunktion um Mock Access Tokens mit kid und beliebigem Algorithmus zu erstellen
+func createMockTokenWithKid(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string {
+	token := jwt.NewWithClaims(method, mockClaims(ResourceId, "test-sub"))
+	token.Header["kid"] = kid
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return tokenString
+}
+
+func TestJwksCacheSelectsKeyByKid(t *testing.T) {
+	rsaKey, err := createPrivateKey()
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+
+	keySet := jwk.Set{Keys: []jwk.Key{
+		{
+			Kty: "RSA",
+			Kid: "rsa",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(rsaKey.PublicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.PublicKey.E)).Bytes()),
+		},
+		ecJwk("ec", ecKey),
+		edJwk("ed", edKey),
+	}}
+
+	// Lokale JWKS Datei (Offline-Setup)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	data, _ := json.Marshal(keySet)
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatalf("Failed to write jwks file: %v", err)
+	}
+	cache := NewFileJwksCache(file, time.Hour, time.Minute)
+	if err := cache.Load(); err != nil {
+		t.Fatalf("Failed to load jwks: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		expectedOk bool
+	}{
+		{"rsa key", createMockTokenWithKid(t, jwt.SigningMethodRS256, "rsa", rsaKey), true},
+		{"ecdsa key", createMockTokenWithKid(t, jwt.SigningMethodES256, "ec", ecKey), true},
+		{"eddsa key", createMockTokenWithKid(t, jwt.SigningMethodEdDSA, "ed", edKey), true},
+		{"wrong kid", createMockTokenWithKid(t, jwt.SigningMethodES256, "ed", ecKey), false},
+		{"alg not allowed for key", createMockTokenWithKid(t, jwt.SigningMethodRS512, "rsa", rsaKey), false},
+		{"unknown kid", createMockTokenWithKid(t, jwt.SigningMethodRS256, "unknown", rsaKey), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateJwt(tt.token, cache)
+			if (err == nil) != tt.expectedOk {
+				t.Errorf("validateJwt() error = %v, expectedOk %v", err, tt.expectedOk)
+			}
+		})
+	}
+}
+
+func TestJwksCacheRefetchesOnUnknownKid(t *testing.T) {
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var rotated atomic.Bool
+	var requests atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		keySet := jwk.Set{Keys: []jwk.Key{ecJwk("old", oldKey)}}
+		if rotated.Load() {
+			keySet.Keys = append(keySet.Keys, ecJwk("new", newKey))
+		}
+		json.NewEncoder(w).Encode(keySet)
+	}))
+	defer server.Close()
+	Client = *server.Client()
+
+	cache := NewRemoteJwksCache(server.URL, time.Hour, 0)
+	if err := cache.Load(); err != nil {
+		t.Fatalf("Failed to load jwks: %v", err)
+	}
+
+	// Der Issuer rotiert den Schlüssel: die neue kid muss ohne Neustart gefunden werden
+	rotated.Store(true)
+	if _, err := validateJwt(createMockTokenWithKid(t, jwt.SigningMethodES256, "new", newKey), cache); err != nil {
+		t.Errorf("Expected token signed with rotated key to be valid, got %v", err)
+	}
+
+	// Bekannte kids lösen keine weiteren Anfragen aus
+	before := requests.Load()
+	validateJwt(createMockTokenWithKid(t, jwt.SigningMethodES256, "old", oldKey), cache)
+	if requests.Load() != before {
+		t.Errorf("Expected no jwks request for a cached kid")
+	}
+}
+
+func TestJwksCacheRateLimitsRefetch(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var requests atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		json.NewEncoder(w).Encode(jwk.Set{Keys: []jwk.Key{ecJwk("known", key)}})
+	}))
+	defer server.Close()
+	Client = *server.Client()
+
+	cache := NewRemoteJwksCache(server.URL, time.Hour, time.Minute)
+	if err := cache.Load(); err != nil {
+		t.Fatalf("Failed to load jwks: %v", err)
+	}
+
+	// Viele Tokens mit erfundenen kids dürfen den Issuer nicht fluten
+	for i := 0; i < 10; i++ {
+		validateJwt(createMockTokenWithKid(t, jwt.SigningMethodES256, "made-up", key), cache)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("Expected 1 jwks request, got %d", requests.Load())
+	}
+}
+
+func TestJwksCacheRefetchWithoutLock(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var requests atomic.Int32
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Ab der zweiten Anfrage antwortet der Issuer erst nach release
+		if requests.Add(1) > 1 {
+			arrived <- struct{}{}
+			<-release
+		}
+		json.NewEncoder(w).Encode(jwk.Set{Keys: []jwk.Key{ecJwk("known", key)}})
+	}))
+	defer server.Close()
+	Client = *server.Client()
+
+	cache := NewRemoteJwksCache(server.URL, time.Hour, time.Minute)
+	if err := cache.Load(); err != nil {
+		t.Fatalf("Failed to load jwks: %v", err)
+	}
+	cache.mu.Lock()
+	cache.fetchedAt = time.Now().Add(-2 * time.Minute)
+	cache.mu.Unlock()
+
+	// Mehrere Tokens mit unbekannter kid lösen zusammen eine einzige Anfrage aus
+	done := make(chan struct{})
+	for i := 0; i < 5; i++ {
+		go func() {
+			cache.Key("made-up", "ES256")
+			done <- struct{}{}
+		}()
+	}
+	<-arrived
+
+	// Während der Issuer langsam antwortet, werden bekannte kids ohne Warten gefunden
+	found := make(chan error, 1)
+	go func() {
+		_, err := cache.Key("known", "ES256")
+		found <- err
+	}()
+	select {
+	case err := <-found:
+		if err != nil {
+			t.Errorf("Expected the cached key, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected the cached key while the jwks is being fetched")
+	}
+
+	close(release)
+	for i := 0; i < 5; i++ {
+		<-done
+	}
+	if requests.Load() != 2 {
+		t.Errorf("Expected 2 jwks requests, got %d", requests.Load())
+	}
+}
+
+func TestDiscoverJwksUrl(t *testing.T) {
+	var issuer string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/application/o/notes/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "jwks/"})
+	}))
+	defer server.Close()
+	Client = *server.Client()
+	issuer = server.URL + "/application/o/notes/"
+
+	jwksUrl, err := discoverJwksUrl(issuer)
+	if err != nil || jwksUrl != issuer+"jwks/" {
+		t.Errorf("Expected the jwks_uri of the issuer, got %q, %v", jwksUrl, err)
+	}
+	// Die Metadaten müssen zum konfigurierten Issuer gehören
+	if _, err := discoverJwksUrl(server.URL + "/application/o/notes"); err == nil {
+		t.Errorf("Expected an error for an issuer mismatch")
+	}
+
+	tests := []struct {
+		jwksUrl  string
+		expected bool
+	}{
+		{"https://idp.example/application/o/notes/jwks/", true},
+		{"https://old-idp.example/application/o/notes/jwks/", false},
+		{"http://idp.example/application/o/notes/jwks/", false},
+		{"https://idp.example/application/o/other/jwks/", false},
+	}
+	for _, tt := range tests {
+		if ok := jwksUrlUnderIssuer(tt.jwksUrl, "https://idp.example/application/o/notes/"); ok != tt.expected {
+			t.Errorf("jwksUrlUnderIssuer(%q) = %v, expected %v", tt.jwksUrl, ok, tt.expected)
+		}
+	}
+}
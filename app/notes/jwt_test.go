@@ -3,8 +3,10 @@ package main
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
 	"log"
 	"testing"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
@@ -48,25 +50,198 @@ func TestValidateJwt(t *testing.T) {
 			expectedSub: "",
 			expectedOk:  false,
 		},
+		{
+			name:        "missing notes claim",
+			token:       createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) { delete(c, "notes") }),
+			expectedSub: "",
+			expectedOk:  false,
+		},
+		{
+			name:        "missing sub claim",
+			token:       createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) { delete(c, "sub") }),
+			expectedSub: "",
+			expectedOk:  false,
+		},
+		{
+			name:        "wrong issuer",
+			token:       createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) { c["iss"] = "https://evil.example/" }),
+			expectedSub: "",
+			expectedOk:  false,
+		},
+		{
+			name:        "missing issuer",
+			token:       createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) { delete(c, "iss") }),
+			expectedSub: "",
+			expectedOk:  false,
+		},
+		{
+			name:        "wrong audience",
+			token:       createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) { c["aud"] = "other-client" }),
+			expectedSub: "",
+			expectedOk:  false,
+		},
+		{
+			name:        "audience list containing this resource server",
+			token:       createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) { c["aud"] = []string{"other-client", Audience} }),
+			expectedSub: "test-sub",
+			expectedOk:  true,
+		},
+		{
+			name:        "expired",
+			token:       createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }),
+			expectedSub: "",
+			expectedOk:  false,
+		},
+		{
+			name:        "expired within clock skew",
+			token:       createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-ClockSkew / 2).Unix() }),
+			expectedSub: "test-sub",
+			expectedOk:  true,
+		},
+		{
+			name:        "missing expiration",
+			token:       createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) { delete(c, "exp") }),
+			expectedSub: "",
+			expectedOk:  false,
+		},
+		{
+			name:        "not valid yet",
+			token:       createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(time.Minute).Unix() }),
+			expectedSub: "",
+			expectedOk:  false,
+		},
+		{
+			name:        "issued in the future",
+			token:       createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Minute).Unix() }),
+			expectedSub: "",
+			expectedOk:  false,
+		},
+		{
+			name:        "algorithm not allowed",
+			token:       createMockHmacToken(),
+			expectedSub: "",
+			expectedOk:  false,
+		},
 	}
 
 	// Die Tabelle iterieren um die Tests auszuführen
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			sub, ok := validateJwt(tt.token, publicKey)
-			if sub != tt.expectedSub || ok != tt.expectedOk {
-				t.Errorf("validateJwt() = (%v, %v), want (%v, %v)", sub, ok, tt.expectedSub, tt.expectedOk)
+			claims, err := validateJwt(tt.token, StaticKey{publicKey})
+			sub := ""
+			if claims != nil {
+				sub = claims.Subject
+			}
+			if sub != tt.expectedSub || (err == nil) != tt.expectedOk {
+				t.Errorf("validateJwt() = (%v, %v), want (%v, %v)", sub, err, tt.expectedSub, tt.expectedOk)
 			}
 		})
 	}
 }
 
-// Hilsfunktion um Mock Access Tokens zu erstellen
-func createMockToken(note, sub string, key *rsa.PrivateKey) string {
-	claims := jwt.MapClaims{
+func TestValidateJwtErrorCodes(t *testing.T) {
+	privateKey, err := createPrivateKey()
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+
+	_, err = validateJwt(createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) { delete(c, "exp") }), StaticKey{&privateKey.PublicKey})
+	var tokenErr *TokenError
+	if !errors.As(err, &tokenErr) || tokenErr.Code != ErrorInvalidToken {
+		t.Errorf("Expected invalid_token error, got %v", err)
+	}
+
+	// Fehlender Scope führt zu insufficient_scope, wenn ein Scope verlangt wird
+	RequiredScope = "notes"
+	defer func() { RequiredScope = "" }()
+	_, err = validateJwt(createMockToken(ResourceId, "test-sub", privateKey), StaticKey{&privateKey.PublicKey})
+	if !errors.As(err, &tokenErr) || tokenErr.Code != ErrorInsufficientScope {
+		t.Errorf("Expected insufficient_scope error, got %v", err)
+	}
+	_, err = validateJwt(createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) { c["scope"] = "openid notes" }), StaticKey{&privateKey.PublicKey})
+	if err != nil {
+		t.Errorf("Expected token with notes scope to be valid, got %v", err)
+	}
+}
+
+func TestValidateJwtResourceIndicator(t *testing.T) {
+	defer func(previous bool) { LegacyNotesClaim = previous }(LegacyNotesClaim)
+	privateKey, err := createPrivateKey()
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	resourceToken := createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) {
+		c["aud"] = []string{ResourceIndicator}
+		delete(c, "notes")
+	})
+	otherResourceToken := createMockTokenWithClaims(privateKey, func(c jwt.MapClaims) {
+		c["aud"] = "https://other.example/api"
+		delete(c, "notes")
+	})
+	legacyToken := createMockToken(ResourceId, "test-sub", privateKey)
+
+	tests := []struct {
+		name       string
+		token      string
+		legacy     bool
+		expectedOk bool
+	}{
+		{"resource indicator in aud", resourceToken, true, true},
+		{"resource indicator in aud without legacy fallback", resourceToken, false, true},
+		{"other resource in aud", otherResourceToken, true, false},
+		{"legacy notes claim", legacyToken, true, true},
+		{"legacy notes claim without legacy fallback", legacyToken, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			LegacyNotesClaim = tt.legacy
+			_, err := validateJwt(tt.token, StaticKey{&privateKey.PublicKey})
+			if (err == nil) != tt.expectedOk {
+				t.Errorf("validateJwt() error = %v, want ok = %v", err, tt.expectedOk)
+			}
+		})
+	}
+}
+
+// Hilfsfunktion für die Claims eines gültigen Access Tokens
+func mockClaims(note, sub string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   Issuer,
+		"aud":   Audience,
+		"exp":   now.Add(time.Minute).Unix(),
+		"iat":   now.Unix(),
 		"notes": note,
 		"sub":   sub,
 	}
+}
+
+// Hilfsfunktion um Mock Access Tokens mit veränderten Claims zu erstellen
+func createMockTokenWithClaims(key *rsa.PrivateKey, modify func(jwt.MapClaims)) string {
+	claims := mockClaims(ResourceId, "test-sub")
+	modify(claims)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		log.Fatalf("Failed to sign token: %v", err)
+	}
+	return tokenString
+}
+
+// Hilfsfunktion um einen mit HMAC signierten Token zu erstellen (darf nie akzeptiert werden)
+func createMockHmacToken() string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, mockClaims(ResourceId, "test-sub"))
+	tokenString, err := token.SignedString([]byte("secret"))
+	if err != nil {
+		log.Fatalf("Failed to sign token: %v", err)
+	}
+	return tokenString
+}
+
+// Hilsfunktion um Mock Access Tokens zu erstellen
+func createMockToken(note, sub string, key *rsa.PrivateKey) string {
+	claims := mockClaims(note, sub)
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
 	tokenString, err := token.SignedString(key)
 	if err != nil {
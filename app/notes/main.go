@@ -2,25 +2,40 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"flag"
 	"net/http"
 	"fmt"
 	"log"
+	"os"
 	"time"
-	"crypto/rsa"
 )
 
-// Note repräsentiert eine Notiz mit Datum, Text, Status (erledigt oder nicht) und Besitzer.
+// Note repräsentiert eine Notiz mit Id, Datum, Text, Status (erledigt oder nicht) und Besitzer.
 type Note struct {
+	Id    string    `json:"id"`
 	Date  time.Time `json:"date"`
 	Text  string    `json:"text"`
 	Done  bool      `json:"done"`
 	Owner string    `json:"owner"`
 }
 
+// NotePatch repräsentiert eine teilweise Änderung einer Notiz (PATCH). Nicht gesetzte Felder bleiben unverändert.
+type NotePatch struct {
+	Date *time.Time `json:"date,omitempty"`
+	Text *string    `json:"text,omitempty"`
+	Done *bool      `json:"done,omitempty"`
+}
+
 var (
-	Db        *sql.DB           // Globale Variable für die Datenbankverbindung
+	Db        *sql.DB           // Globale Variable für die Datenbankverbindung (nur beim Postgres Backend)
+	Notes     NoteRepository    // Globale Variable für die Speicherung der Notizen
 	Client    http.Client       // Globale Variable für den HTTP-Client
-	PublicKey *rsa.PublicKey    // Globale Variable für den öffentlichen Schlüssel zur JWT-Validierung
+	Keys      KeySource         // Globale Variable für die öffentlichen Schlüssel des Issuers zur JWT-Validierung
+
+	// Storage Backend: "postgres", "sqlite" (eine Datei, für den lokalen Betrieb) oder "memory" (nur für Tests/Demos)
+	StorageBackend string = "postgres"
+	SqlitePath     string = "./notes.db"
 
 	// Datenbankkonfigurationsvariablen
 	DbUser       string = "notes_user"
@@ -28,6 +43,10 @@ var (
 	DbName       string = "postgres"
 	DbHost       string = "postgres" // Aus dem Docker Compose Netz
 	DbPort       string = "5432"
+	AutoMigrate  bool   = true // Migrationen beim Start anwenden (sonst über "migrate up")
+
+	// Port des Resource Servers
+	Port         string = "8080"
 
 	// OAuth2-Konfigurationsvariablen
 	ClientId     string = "HQrsYMEXnEksFCMQ1klvt85RIT3Jt8KHSd5uArn0"
@@ -35,46 +54,118 @@ var (
 	KeyFile      string = "./certs/server.key"
 	CaCertFile   string = "./certs/certificate.crt"
 	ResourceId   string = "ytAwQxEH4lRu48Ae9JjI2epogcJLhSfP" // Wert im JWT bei Anwendung des notes-scopes.
+
+	// Erwartete Werte für die Validierung der Access Tokens
+	Issuer       string = "https://37.27.87.77:9443/application/o/notes/" // iss Claim
+	Audience     string = ClientId                                        // aud Claim, Authentik setzt hier die Client Id
+	ClockSkew    time.Duration = 30 * time.Second                         // Toleranz für exp, nbf und iat
+	Realm        string = "notes"                                         // realm im WWW-Authenticate Header
+
+	// Resource Indicator der notes-Api (RFC 8707): der Client fordert Tokens mit resource=ResourceIndicator an,
+	// die aud solcher Tokens enthält ihn. Authentik ignoriert den Parameter, deswegen werden mit LegacyNotesClaim
+	// auch Tokens mit aud = Audience und passendem "notes"-Claim akzeptiert
+	ResourceIndicator string = "https://37.27.87.77:8080/notes"
+	LegacyNotesClaim  bool   = true
+
+	// Nur diese Signatur-Algorithmen werden akzeptiert (insbesondere nie "none" oder HMAC)
+	AllowedAlgorithms []string = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "EdDSA"}
+
+	// Optionaler Scope, der im scope Claim enthalten sein muss. Authentik kodiert den notes-Scope
+	// stattdessen im "notes"-Claim, deswegen ist die Prüfung standardmäßig aus
+	RequiredScope string = ""
+
+	// JWKS des Issuers. Ist JwksFile gesetzt, wird das Key Set aus der Datei gelesen (Offline-Setup),
+	// sonst von JwksUrl geladen. Ohne JwksUrl kommt die jwks_uri aus den Metadaten des Issuers (Discovery)
+	JwksUrl      string = ""
+	JwksFile     string = ""
+	JwksTTL      time.Duration = 15 * time.Minute // Nach dieser Zeit wird das Key Set neu geladen
+	JwksRefetch  time.Duration = 30 * time.Second // Mindestabstand für Neuladen bei unbekannter kid
+
+	// Zertifikatsgebundene Tokens (RFC 8705): cnf.x5t#S256 wird immer gegen das Client-Zertifikat geprüft.
+	// Mit RequireBoundTokens werden Tokens ohne Bindung abgelehnt (Authentik bindet Tokens bisher nicht)
+	RequireBoundTokens bool = false
+
+	// DPoP (RFC 9449): "off" (nur Bearer), "optional" (Bearer und DPoP) oder "required" (nur DPoP).
+	// Ein Proof ist DPoPProofWindow lang gültig, seine jti wird so lange gegen Wiederholung gespeichert
+	DPoPMode        string        = "optional"
+	DPoPProofWindow time.Duration = 60 * time.Second
+	DPoPReplays     *ReplayCache  = NewReplayCache()
+
+	// Signatur-Algorithmen für DPoP Proofs, unabhängig von AllowedAlgorithms: die Proofs signiert der Client
+	// mit seinem eigenen Schlüssel, deswegen kommen nur asymmetrische Algorithmen in Frage
+	DPoPAlgorithms []string = []string{"ES256", "ES384", "RS256", "RS384", "RS512", "EdDSA"}
+
+	// Service Principals (Client Credentials Grant): Maschinen-Tokens der ServicePrincipals bekommen eigene
+	// Notizen, getrennt von denen der Benutzer. Zum Lesen ist ServiceReadScope nötig, sonst ServiceWriteScope
+	ServicePrincipalMode bool     = false
+	ServicePrincipals    []string = nil
+	ServiceReadScope     string   = "notes:read"
+	ServiceWriteScope    string   = "notes:write"
 )
 
 func main() {
-	// Laden des öffentlichen Schlüssels zur JWT-Validierung
-	var err error
-	PublicKey, err = LoadPublicKey(CaCertFile)
+	// Konfiguration aus Datei, Umgebung und Flags (siehe config.go)
+	args, err := loadConfig(os.Args[1:])
 	if err != nil {
-		log.Fatal(err.Error())
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	// Initialisierung der Datenbankverbindung und des HTTP-Clients
-	initDB()
+	// Initialisierung des Storage Backends und des HTTP-Clients
+	Notes, err = openRepository(StorageBackend)
+	if err != nil {
+		log.Fatalf("Failed to open storage backend: %v", err)
+	}
 	InitHTTPClient()
-	defer Db.Close()
-
-	// Festlegen der HTTP-Handler für verschiedene Endpunkte
-	http.HandleFunc("/notes", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			handleGetNotes(w, r) // Handhabt GET-Anfragen zum Abrufen von Notizen
-		case http.MethodPost:
-			handleCreateNote(w, r) // Handhabt POST-Anfragen zum Erstellen von Notizen
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	// Unterbefehl "migrate up|down|status": nur Migrationen ausführen, kein Server
+	if len(args) > 0 && args[0] == "migrate" {
+		if Db == nil {
+			log.Fatalf("Migrations are only available for the postgres backend")
+		}
+		if err := runMigrateCommand(args[1:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	// Ausstehende Migrationen beim Start anwenden (nur Postgres, SQLite legt das Schema selbst an)
+	if Db != nil && AutoMigrate {
+		migrator, err := NewMigrator(Db)
+		if err != nil {
+			log.Fatalf("Failed to load migrations: %v", err)
+		}
+		if err := migrator.Up(); err != nil {
+			log.Fatalf("Migration failed: %v", err)
 		}
-	})
+	}
 
-	http.HandleFunc("/notes/delete", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodDelete {
-			handleDeleteNote(w, r) // Handhabt DELETE-Anfragen zum Löschen von Notizen
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// Laden der öffentlichen Schlüssel des Issuers zur JWT-Validierung
+	var jwks *JwksCache
+	if JwksFile != "" {
+		jwks = NewFileJwksCache(JwksFile, JwksTTL, JwksRefetch)
+	} else {
+		if JwksUrl == "" {
+			if JwksUrl, err = discoverJwksUrl(Issuer); err != nil {
+				log.Fatalf("Failed to discover jwks: %v", err)
+			}
 		}
-	})
+		jwks = NewRemoteJwksCache(JwksUrl, JwksTTL, JwksRefetch)
+	}
+	if err := jwks.Load(); err != nil {
+		log.Fatalf("Failed to load jwks: %v", err)
+	}
+	Keys = jwks
 
-	// Starten des HTTPS-Servers auf Port 8080
+	// Starten des HTTPS-Servers (Standard: Port 8080)
 	server := &http.Server{
-		Addr: ":8080",
+		Addr:      ":" + Port,
+		Handler:   newRouter(),
+		TLSConfig: serverTLSConfig(),
 	}
 
-	fmt.Println("Api listening on localhost:8080!")
-	log.Fatal(server.ListenAndServeTLS("./certs/server.crt", "./certs/server.key"))
+	fmt.Printf("Api listening on localhost:%s!\n", Port)
+	log.Fatal(server.ListenAndServeTLS(CertFile, KeyFile))
 }
\ No newline at end of file
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Principal repräsentiert den authentifizierten Aufrufer einer Anfrage.
+// Wird von der Middleware aus dem validierten Access Token erstellt und in den Request-Kontext gelegt.
+type Principal struct {
+	Subject   string
+	Issuer    string
+	Scopes    []string
+	ClientId  string
+	Service   bool // Maschinen-Token eines Service Principals (siehe service-principal.go)
+	Claims    *AccessTokenClaims
+	RawClaims jwt.MapClaims
+}
+
+// principalContextKey ist der Schlüssel des Principals im context.Context.
+type principalContextKey struct{}
+
+// PrincipalFromContext gibt den Principal der Anfrage zurück, falls die Middleware ihn gesetzt hat.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return principal, ok
+}
+
+// ##############################################################################################
+// requireAuth ist die Authentifizierungs-Middleware für die /notes Routen.
+// Sie liest den Bearer Token aus dem Authorization-Header, validiert das JWT und legt den Principal
+// in den Kontext. Ungültige Anfragen werden abgelehnt, bevor der Handler läuft.
+// ##############################################################################################
+
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessToken, scheme, err := authorizationToken(r)
+		if err != nil {
+			writeAuthError(w, err)
+			return
+		}
+
+		claims, err := validateJwt(accessToken, Keys)
+		if err != nil {
+			writeAuthError(w, withScheme(err, scheme))
+			return
+		}
+
+		// DPoP-gebundene Tokens gelten nur mit einem Proof des Schlüssels (siehe dpop.go)
+		if err := checkDPoPBinding(r, scheme, accessToken, claims); err != nil {
+			writeAuthError(w, err)
+			return
+		}
+
+		// Zertifikatsgebundene Tokens gelten nur mit dem Zertifikat der TLS-Verbindung
+		if err := checkCertificateBinding(claims, r.TLS); err != nil {
+			writeAuthError(w, err)
+			return
+		}
+
+		// Maschinen-Tokens bekommen nur als Service Principal Zugriff, getrennt von den Benutzern
+		if err := authorizeServicePrincipal(r, claims); err != nil {
+			writeAuthError(w, withScheme(err, scheme))
+			return
+		}
+
+		// Die Signatur ist bereits geprüft, hier werden nur die Claims als Map gelesen
+		rawClaims := jwt.MapClaims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(accessToken, rawClaims); err != nil {
+			writeAuthError(w, invalidToken("the token is malformed", err))
+			return
+		}
+
+		principal := &Principal{
+			Subject:   claims.Subject,
+			Issuer:    claims.Issuer,
+			Scopes:    claims.Scopes(),
+			ClientId:  claims.ClientId,
+			Service:   isServiceToken(claims),
+			Claims:    claims,
+			RawClaims: rawClaims,
+		}
+		ctx := context.WithValue(r.Context(), principalContextKey{}, principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ##############################################################################################
+// authorizationToken liest den Access Token und das Schema ("Bearer" oder "DPoP") aus dem Authorization-Header
+// (https://datatracker.ietf.org/doc/html/rfc6750#section-2.1, https://datatracker.ietf.org/doc/html/rfc9449#section-7.1).
+// Das Schema ist nicht case-sensitiv, welche Schemata angenommen werden, hängt von DPoPMode ab. Fehlt der
+// Header oder wird ein anderes Schema benutzt, wird ein Fehler ohne Fehlercode zurückgegeben, ein
+// fehlerhafter Header ist ein invalid_request.
+// ##############################################################################################
+
+func authorizationToken(r *http.Request) (string, string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", "", fmt.Errorf("Authorization header missing")
+	}
+
+	scheme, token, found := strings.Cut(authHeader, " ")
+	accepted := ""
+	for _, s := range acceptedSchemes() {
+		if strings.EqualFold(scheme, s) {
+			accepted = s
+		}
+	}
+	if accepted == "" {
+		return "", "", fmt.Errorf("Authorization header does not use the %s scheme", strings.Join(acceptedSchemes(), " or "))
+	}
+
+	token = strings.TrimSpace(token)
+	if !found || token == "" || strings.ContainsAny(token, " \t") {
+		return "", "", &TokenError{Code: ErrorInvalidRequest, Description: "malformed " + accepted + " authorization header", Scheme: accepted}
+	}
+	return token, accepted, nil
+}
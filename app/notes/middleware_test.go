@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"jwk"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ##############################################################################################
+// Unit Tests für die Authentifizierungs-Middleware
+// ##############################################################################################
+
+func TestRequireAuth(t *testing.T) {
+	privateKey, err := createPrivateKey()
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	Keys = StaticKey{&privateKey.PublicKey}
+	validToken := createMockToken(ResourceId, "test-sub", privateKey)
+
+	tests := []struct {
+		name            string
+		header          string
+		expectedStatus  int
+		expectedError   string
+		expectedHandler bool
+	}{
+		{"valid token", "Bearer " + validToken, http.StatusOK, "", true},
+		{"lowercase scheme", "bearer " + validToken, http.StatusOK, "", true},
+		{"missing header", "", http.StatusUnauthorized, "", false},
+		{"other scheme", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, "", false},
+		{"token without scheme", validToken, http.StatusUnauthorized, "", false},
+		{"scheme without token", "Bearer", http.StatusBadRequest, ErrorInvalidRequest, false},
+		{"scheme with empty token", "Bearer   ", http.StatusBadRequest, ErrorInvalidRequest, false},
+		{"token with spaces", "Bearer a b", http.StatusBadRequest, ErrorInvalidRequest, false},
+		{"invalid token", "Bearer invalid.token.signature", http.StatusUnauthorized, ErrorInvalidToken, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerCalled := false
+			handler := requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerCalled = true
+				principal, ok := PrincipalFromContext(r.Context())
+				if !ok || principal.Subject != "test-sub" || principal.Issuer != Issuer {
+					t.Errorf("Expected principal for test-sub in context, got %+v", principal)
+				}
+				if principal.RawClaims["notes"] != ResourceId {
+					t.Errorf("Expected raw notes claim %v, got %v", ResourceId, principal.RawClaims["notes"])
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/notes", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %v, got %v", tt.expectedStatus, rec.Code)
+			}
+			if handlerCalled != tt.expectedHandler {
+				t.Errorf("Expected handler called = %v, got %v", tt.expectedHandler, handlerCalled)
+			}
+
+			challenge := rec.Header().Get("WWW-Authenticate")
+			if tt.expectedStatus != http.StatusOK && !strings.HasPrefix(challenge, "Bearer") {
+				t.Errorf("Expected Bearer challenge, got '%v'", challenge)
+			}
+			if tt.expectedError != "" && !strings.Contains(challenge, `error="`+tt.expectedError+`"`) {
+				t.Errorf("Expected error %v in challenge, got '%v'", tt.expectedError, challenge)
+			}
+			if tt.expectedError == "" && strings.Contains(challenge, "error=") {
+				t.Errorf("Expected challenge without error code, got '%v'", challenge)
+			}
+		})
+	}
+}
+
+func TestCertificateBoundTokens(t *testing.T) {
+	defer func(previous bool) { RequireBoundTokens = previous }(RequireBoundTokens)
+	privateKey, err := createPrivateKey()
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	Keys = StaticKey{&privateKey.PublicKey}
+
+	// Zwei verschiedene Zertifikate: das eigene und das des Providers
+	pair, err := tls.LoadX509KeyPair(CertFile, KeyFile)
+	if err != nil {
+		t.Fatalf("Failed to load key pair: %v", err)
+	}
+	clientCert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	otherPem, _ := os.ReadFile(CaCertFile)
+	otherBlock, _ := pem.Decode(otherPem)
+	otherCert, err := x509.ParseCertificate(otherBlock.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+
+	boundToken := createMockTokenWithClaims(privateKey, func(claims jwt.MapClaims) {
+		claims["cnf"] = map[string]interface{}{"x5t#S256": certificateThumbprint(clientCert.Raw)}
+	})
+	unboundToken := createMockToken(ResourceId, "test-sub", privateKey)
+
+	tests := []struct {
+		name           string
+		token          string
+		cert           *x509.Certificate
+		requireBound   bool
+		expectedStatus int
+	}{
+		{"bound token with its certificate", boundToken, clientCert, false, http.StatusOK},
+		{"bound token with another certificate", boundToken, otherCert, false, http.StatusUnauthorized},
+		{"bound token without certificate", boundToken, nil, false, http.StatusUnauthorized},
+		{"unbound token", unboundToken, nil, false, http.StatusOK},
+		{"unbound token when binding is required", unboundToken, clientCert, true, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RequireBoundTokens = tt.requireBound
+			handler := requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodGet, "/notes", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			if tt.cert != nil {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %v, got %v", tt.expectedStatus, rec.Code)
+			}
+			if tt.expectedStatus != http.StatusOK && !strings.Contains(rec.Header().Get("WWW-Authenticate"), ErrorInvalidToken) {
+				t.Errorf("Expected invalid_token, got '%v'", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestDPoPBoundTokens(t *testing.T) {
+	defer func(previous string) { DPoPMode = previous }(DPoPMode)
+	// Access Tokens nur mit RS256, die Proofs des Clients sind trotzdem mit ES256 signiert
+	defer func(previous []string) { AllowedAlgorithms = previous }(AllowedAlgorithms)
+	AllowedAlgorithms = []string{"RS256"}
+	privateKey, err := createPrivateKey()
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	Keys = StaticKey{&privateKey.PublicKey}
+
+	// Der Schlüssel des Clients, an den der Token gebunden ist, und ein fremder Schlüssel
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	clientJwk := mockDPoPJwk(clientKey)
+	boundToken := createMockTokenWithClaims(privateKey, func(claims jwt.MapClaims) {
+		claims["cnf"] = map[string]interface{}{"jkt": clientJwk.Thumbprint()}
+	})
+	unboundToken := createMockToken(ResourceId, "test-sub", privateKey)
+
+	const target = "https://notes.example/notes"
+	proof := func(key *ecdsa.PrivateKey, modify func(jwt.MapClaims)) string {
+		return createMockDPoPProof(t, key, boundToken, modify)
+	}
+	replayed := proof(clientKey, nil)
+
+	tests := []struct {
+		name           string
+		mode           string
+		scheme         string
+		token          string
+		proof          string
+		expectedStatus int
+		expectedError  string
+	}{
+		{"bound token with proof", DPoPOptional, "DPoP", boundToken, proof(clientKey, nil), http.StatusOK, ""},
+		{"bound token with proof in required mode", DPoPRequired, "DPoP", boundToken, proof(clientKey, nil), http.StatusOK, ""},
+		{"first use of a proof", DPoPOptional, "DPoP", boundToken, replayed, http.StatusOK, ""},
+		{"replayed proof", DPoPOptional, "DPoP", boundToken, replayed, http.StatusUnauthorized, ErrorInvalidDPoPProof},
+		{"proof of another key", DPoPOptional, "DPoP", boundToken, proof(otherKey, nil), http.StatusUnauthorized, ErrorInvalidToken},
+		{"missing proof", DPoPOptional, "DPoP", boundToken, "", http.StatusUnauthorized, ErrorInvalidDPoPProof},
+		{"wrong method", DPoPOptional, "DPoP", boundToken, proof(clientKey, func(c jwt.MapClaims) { c["htm"] = "POST" }), http.StatusUnauthorized, ErrorInvalidDPoPProof},
+		{"wrong url", DPoPOptional, "DPoP", boundToken, proof(clientKey, func(c jwt.MapClaims) { c["htu"] = "https://other.example/notes" }), http.StatusUnauthorized, ErrorInvalidDPoPProof},
+		{"wrong token hash", DPoPOptional, "DPoP", boundToken, proof(clientKey, func(c jwt.MapClaims) { c["ath"] = "invalid" }), http.StatusUnauthorized, ErrorInvalidDPoPProof},
+		{"old proof", DPoPOptional, "DPoP", boundToken, proof(clientKey, func(c jwt.MapClaims) { c["iat"] = time.Now().Add(-time.Hour).Unix() }), http.StatusUnauthorized, ErrorInvalidDPoPProof},
+		{"bound token as bearer token", DPoPOptional, "Bearer", boundToken, "", http.StatusUnauthorized, ErrorInvalidToken},
+		{"bound token as bearer token with dpop off", DPoPOff, "Bearer", boundToken, "", http.StatusUnauthorized, ErrorInvalidToken},
+		{"unbound token with proof", DPoPOptional, "DPoP", unboundToken, proof(clientKey, nil), http.StatusUnauthorized, ""},
+		{"unbound bearer token", DPoPOptional, "Bearer", unboundToken, "", http.StatusOK, ""},
+		{"unbound bearer token in required mode", DPoPRequired, "Bearer", unboundToken, "", http.StatusUnauthorized, ""},
+		{"dpop scheme with dpop off", DPoPOff, "DPoP", boundToken, proof(clientKey, nil), http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DPoPMode = tt.mode
+			handler := requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.TLS = &tls.ConnectionState{}
+			req.Header.Set("Authorization", tt.scheme+" "+tt.token)
+			if tt.proof != "" {
+				req.Header.Set("DPoP", tt.proof)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %v, got %v (%v)", tt.expectedStatus, rec.Code, rec.Header().Values("WWW-Authenticate"))
+			}
+			challenges := strings.Join(rec.Header().Values("WWW-Authenticate"), "; ")
+			if tt.expectedError != "" && !strings.Contains(challenges, `error="`+tt.expectedError+`"`) {
+				t.Errorf("Expected error %v in challenges, got '%v'", tt.expectedError, challenges)
+			}
+			if tt.expectedStatus != http.StatusOK && tt.mode != DPoPOff && !strings.Contains(challenges, "DPoP realm=") {
+				t.Errorf("Expected a DPoP challenge, got '%v'", challenges)
+			}
+			if tt.expectedStatus != http.StatusOK && tt.mode != DPoPOff && !strings.Contains(challenges, `algs="`+strings.Join(DPoPAlgorithms, " ")+`"`) {
+				t.Errorf("Expected the DPoP algorithms in the challenge, got '%v'", challenges)
+			}
+		})
+	}
+}
+
+// Hilfsfunktion für den öffentlichen JWK eines DPoP-Schlüssels
+func mockDPoPJwk(key *ecdsa.PrivateKey) jwk.Key {
+	return jwk.Key{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// Hilfsfunktion um einen DPoP Proof für GET https://notes.example/notes zu erstellen
+func createMockDPoPProof(t *testing.T, key *ecdsa.PrivateKey, accessToken string, modify func(jwt.MapClaims)) string {
+	jti := make([]byte, 16)
+	rand.Read(jti)
+	sum := sha256.Sum256([]byte(accessToken))
+	claims := jwt.MapClaims{
+		"jti": base64.RawURLEncoding.EncodeToString(jti),
+		"htm": "GET",
+		"htu": "https://notes.example/notes",
+		"iat": time.Now().Unix(),
+		"ath": base64.RawURLEncoding.EncodeToString(sum[:]),
+	}
+	if modify != nil {
+		modify(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = mockDPoPJwk(key)
+	proof, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign proof: %v", err)
+	}
+	return proof
+}
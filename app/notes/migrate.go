@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Die Migrationen werden in die Binary eingebettet. Dateinamen: <version>_<name>.up.sql und <version>_<name>.down.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Schlüssel für pg_advisory_lock. Solange eine Instanz migriert, warten alle anderen Replikas
+const migrationLockKey int64 = 7305146210
+
+// Migration repräsentiert eine Schemaänderung mit Version, Name und den SQL-Skripten für up und down.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus beschreibt, ob und wann eine Migration angewendet wurde.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// ##############################################################################################
+// parseMigrations liest alle Migrationen aus dem Dateisystem und sortiert sie nach Version.
+// Jede Version muss genau ein up-Skript haben, das down-Skript ist optional.
+// ##############################################################################################
+
+func parseMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %v", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, exists := byVersion[version]
+		if !exists {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names: %s, %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d (%s) has no up script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// ##############################################################################################
+// Migrator wendet die eingebetteten Migrationen auf die Datenbank an.
+// Die angewendeten Versionen stehen in der Tabelle notes_user.schema_migrations.
+// Alle Operationen laufen auf einer eigenen Verbindung, die den Advisory Lock hält.
+// ##############################################################################################
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator erstellt einen Migrator mit den in die Binary eingebetteten Migrationen.
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := parseMigrations(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Up wendet alle noch nicht angewendeten Migrationen in aufsteigender Reihenfolge an.
+func (m *Migrator) Up() error {
+	return m.withLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := runMigration(conn, migration.Up,
+				"INSERT INTO notes_user.schema_migrations (version, name) VALUES ($1, $2)", migration.Version, migration.Name); err != nil {
+				return fmt.Errorf("migration %d (%s) up: %v", migration.Version, migration.Name, err)
+			}
+			fmt.Printf("Applied migration %d (%s)\n", migration.Version, migration.Name)
+		}
+		return nil
+	})
+}
+
+// Down macht die letzten steps angewendeten Migrationen in absteigender Reihenfolge rückgängig.
+func (m *Migrator) Down(steps int) error {
+	return m.withLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d (%s) cannot be reverted: no down script", migration.Version, migration.Name)
+			}
+			if err := runMigration(conn, migration.Down,
+				"DELETE FROM notes_user.schema_migrations WHERE version = $1", migration.Version); err != nil {
+				return fmt.Errorf("migration %d (%s) down: %v", migration.Version, migration.Name, err)
+			}
+			fmt.Printf("Reverted migration %d (%s)\n", migration.Version, migration.Name)
+			steps--
+		}
+		return nil
+	})
+}
+
+// Status gibt für jede bekannte Migration zurück, ob sie angewendet ist.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	var status []MigrationStatus
+	err := m.withLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			appliedAt, ok := applied[migration.Version]
+			status = append(status, MigrationStatus{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+		}
+		return nil
+	})
+	return status, err
+}
+
+// withLock holt eine eigene Verbindung, nimmt den Advisory Lock und legt die Tabelle schema_migrations an.
+// Advisory Locks gelten pro Verbindung, deswegen darf fn nur conn benutzen.
+func (m *Migrator) withLock(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("acquiring migration lock: %v", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS notes_user.schema_migrations (
+		version    BIGINT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating schema_migrations: %v", err)
+	}
+	return fn(conn)
+}
+
+// appliedMigrations liest die angewendeten Versionen mit Zeitpunkt aus schema_migrations.
+func appliedMigrations(conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(context.Background(), "SELECT version, applied_at FROM notes_user.schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// runMigration führt ein Skript und die Aktualisierung von schema_migrations in einer Transaktion aus.
+func runMigration(conn *sql.Conn, script string, bookkeeping string, args ...interface{}) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ##############################################################################################
+// runMigrateCommand führt den Unterbefehl "migrate up|down [n]|status" aus.
+// ##############################################################################################
+
+func runMigrateCommand(args []string) error {
+	migrator, err := NewMigrator(Db)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [steps]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		return migrator.Up()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+		}
+		return migrator.Down(steps)
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			if s.Applied {
+				fmt.Printf("%04d %-40s applied %s\n", s.Version, s.Name, s.AppliedAt.Format(time.RFC3339))
+			} else {
+				fmt.Printf("%04d %-40s pending\n", s.Version, s.Name)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+}
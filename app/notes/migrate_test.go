@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+// ##############################################################################################
+// Unit Tests für das Einlesen der Migrationen
+// ##############################################################################################
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := parseMigrations(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatalf("Failed to parse embedded migrations: %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].Version != 1 {
+		t.Fatalf("Expected first migration to have version 1, got %+v", migrations)
+	}
+	for _, migration := range migrations {
+		if migration.Down == "" {
+			t.Errorf("Expected migration %d to have a down script", migration.Version)
+		}
+	}
+}
+
+func TestParseMigrations(t *testing.T) {
+	tests := []struct {
+		name             string
+		files            fstest.MapFS
+		expectedVersions []int64
+		expectedOk       bool
+	}{
+		{
+			name: "sorted by version",
+			files: fstest.MapFS{
+				"m/0010_later.up.sql":   {Data: []byte("SELECT 10")},
+				"m/0002_second.up.sql":  {Data: []byte("SELECT 2")},
+				"m/0001_first.up.sql":   {Data: []byte("SELECT 1")},
+				"m/0001_first.down.sql": {Data: []byte("SELECT -1")},
+			},
+			expectedVersions: []int64{1, 2, 10},
+			expectedOk:       true,
+		},
+		{
+			name: "missing up script",
+			files: fstest.MapFS{
+				"m/0001_first.down.sql": {Data: []byte("SELECT -1")},
+			},
+			expectedOk: false,
+		},
+		{
+			name: "invalid file name",
+			files: fstest.MapFS{
+				"m/first.sql": {Data: []byte("SELECT 1")},
+			},
+			expectedOk: false,
+		},
+		{
+			name: "conflicting names",
+			files: fstest.MapFS{
+				"m/0001_first.up.sql":   {Data: []byte("SELECT 1")},
+				"m/0001_other.down.sql": {Data: []byte("SELECT -1")},
+			},
+			expectedOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := parseMigrations(tt.files, "m")
+			if (err == nil) != tt.expectedOk {
+				t.Fatalf("parseMigrations() error = %v, expectedOk %v", err, tt.expectedOk)
+			}
+			if len(migrations) != len(tt.expectedVersions) {
+				t.Fatalf("Expected %d migrations, got %d", len(tt.expectedVersions), len(migrations))
+			}
+			for i, version := range tt.expectedVersions {
+				if migrations[i].Version != version {
+					t.Errorf("Expected version %d at position %d, got %d", version, i, migrations[i].Version)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,5 @@
+ALTER TABLE notes_user.notes
+    DROP COLUMN IF EXISTS id;
+
+ALTER TABLE notes_user.notes
+    ALTER COLUMN done TYPE BIT USING (CASE WHEN done THEN B'1' ELSE B'0' END);
@@ -0,0 +1,17 @@
+-- done wird von BIT auf BOOLEAN umgestellt
+ALTER TABLE notes_user.notes
+    ALTER COLUMN done TYPE BOOLEAN USING (done = B'1');
+
+-- Jede Notiz bekommt eine UUID als Primärschlüssel. Bestehende Zeilen erhalten jeweils eine eigene Id
+ALTER TABLE notes_user.notes
+    ADD COLUMN IF NOT EXISTS id UUID NOT NULL DEFAULT gen_random_uuid();
+
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_constraint
+        WHERE conrelid = 'notes_user.notes'::regclass AND contype = 'p'
+    ) THEN
+        ALTER TABLE notes_user.notes ADD PRIMARY KEY (id);
+    END IF;
+END $$;
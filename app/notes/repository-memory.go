@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ##############################################################################################
+// MemoryNoteRepository hält die Notizen nur im Speicher (für Unit Tests und Demos).
+// List und Search sortieren wie die Datenbanken nach Datum und Id.
+// ##############################################################################################
+
+type MemoryNoteRepository struct {
+	notes map[string]Note
+	mu    sync.RWMutex
+}
+
+// NewMemoryNoteRepository erstellt ein leeres In-Memory Repository.
+func NewMemoryNoteRepository() *MemoryNoteRepository {
+	return &MemoryNoteRepository{
+		notes: make(map[string]Note),
+	}
+}
+
+func (repo *MemoryNoteRepository) List(ctx context.Context, owner string) ([]Note, error) {
+	return repo.filter(func(note Note) bool {
+		return note.Owner == owner
+	}), nil
+}
+
+func (repo *MemoryNoteRepository) Get(ctx context.Context, owner string, id string) (*Note, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	note, exists := repo.notes[id]
+	if !exists || note.Owner != owner {
+		return nil, ErrNoteNotFound
+	}
+	return &note, nil
+}
+
+func (repo *MemoryNoteRepository) Create(ctx context.Context, note Note) (*Note, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	note.Id = newNoteId()
+	repo.notes[note.Id] = note
+	return &note, nil
+}
+
+func (repo *MemoryNoteRepository) Update(ctx context.Context, owner string, id string, patch NotePatch) (*Note, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	note, exists := repo.notes[id]
+	if !exists || note.Owner != owner {
+		return nil, ErrNoteNotFound
+	}
+	if patch.Date != nil {
+		note.Date = *patch.Date
+	}
+	if patch.Text != nil {
+		note.Text = *patch.Text
+	}
+	if patch.Done != nil {
+		note.Done = *patch.Done
+	}
+	repo.notes[id] = note
+	return &note, nil
+}
+
+func (repo *MemoryNoteRepository) Delete(ctx context.Context, owner string, id string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	note, exists := repo.notes[id]
+	if !exists || note.Owner != owner {
+		return ErrNoteNotFound
+	}
+	delete(repo.notes, id)
+	return nil
+}
+
+func (repo *MemoryNoteRepository) DeleteByText(ctx context.Context, owner string, text string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for id, note := range repo.notes {
+		if note.Owner == owner && note.Text == text {
+			delete(repo.notes, id)
+		}
+	}
+	return nil
+}
+
+func (repo *MemoryNoteRepository) Search(ctx context.Context, owner string, query string) ([]Note, error) {
+	query = strings.ToLower(query)
+	return repo.filter(func(note Note) bool {
+		return note.Owner == owner && strings.Contains(strings.ToLower(note.Text), query)
+	}), nil
+}
+
+// filter gibt alle Notizen nach Datum und Id sortiert zurück, für die keep true ist.
+func (repo *MemoryNoteRepository) filter(keep func(Note) bool) []Note {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	var notes []Note
+	for _, note := range repo.notes {
+		if keep(note) {
+			notes = append(notes, note)
+		}
+	}
+	sort.Slice(notes, func(i, j int) bool {
+		if !notes[i].Date.Equal(notes[j].Date) {
+			return notes[i].Date.Before(notes[j].Date)
+		}
+		return notes[i].Id < notes[j].Id
+	})
+	return notes
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+// ##############################################################################################
+// PostgresNoteRepository speichert die Notizen in der Tabelle notes_user.notes.
+// Das Schema wird von den Migrationen (migrate.go) verwaltet.
+// ##############################################################################################
+
+type PostgresNoteRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresNoteRepository erstellt ein Repository auf der gegebenen Datenbankverbindung.
+func NewPostgresNoteRepository(db *sql.DB) *PostgresNoteRepository {
+	return &PostgresNoteRepository{db: db}
+}
+
+func (repo *PostgresNoteRepository) List(ctx context.Context, owner string) ([]Note, error) {
+	rows, err := repo.db.QueryContext(ctx, "SELECT id, date, text, done, owner FROM notes_user.notes WHERE owner = $1 ORDER BY date, id", owner)
+	if err != nil {
+		return nil, err
+	}
+	return scanNotes(rows)
+}
+
+func (repo *PostgresNoteRepository) Get(ctx context.Context, owner string, id string) (*Note, error) {
+	row := repo.db.QueryRowContext(ctx, "SELECT id, date, text, done, owner FROM notes_user.notes WHERE id = $1 AND owner = $2", id, owner)
+	return scanNote(row)
+}
+
+func (repo *PostgresNoteRepository) Create(ctx context.Context, note Note) (*Note, error) {
+	// Die Id vergibt Postgres
+	err := repo.db.QueryRowContext(ctx, "INSERT INTO notes_user.notes (date, text, done, owner) VALUES ($1, $2, $3, $4) RETURNING id",
+		note.Date, note.Text, note.Done, note.Owner).Scan(&note.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+func (repo *PostgresNoteRepository) Update(ctx context.Context, owner string, id string, patch NotePatch) (*Note, error) {
+	row := repo.db.QueryRowContext(ctx, `UPDATE notes_user.notes
+		SET date = COALESCE($1::date, date), text = COALESCE($2, text), done = COALESCE($3::boolean, done)
+		WHERE id = $4 AND owner = $5
+		RETURNING id, date, text, done, owner`,
+		patch.Date, patch.Text, patch.Done, id, owner)
+	return scanNote(row)
+}
+
+func (repo *PostgresNoteRepository) Delete(ctx context.Context, owner string, id string) error {
+	result, err := repo.db.ExecContext(ctx, "DELETE FROM notes_user.notes WHERE id = $1 AND owner = $2", id, owner)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+func (repo *PostgresNoteRepository) DeleteByText(ctx context.Context, owner string, text string) error {
+	_, err := repo.db.ExecContext(ctx, "DELETE FROM notes_user.notes WHERE text = $1 AND owner = $2", text, owner)
+	return err
+}
+
+func (repo *PostgresNoteRepository) Search(ctx context.Context, owner string, query string) ([]Note, error) {
+	// strpos statt LIKE, damit % und _ in der Suche keine Platzhalter sind
+	rows, err := repo.db.QueryContext(ctx,
+		"SELECT id, date, text, done, owner FROM notes_user.notes WHERE owner = $1 AND strpos(lower(text), lower($2)) > 0 ORDER BY date, id", owner, query)
+	if err != nil {
+		return nil, err
+	}
+	return scanNotes(rows)
+}
+
+// ##############################################################################################
+// Hilfsfunktionen für die SQL-Backends
+// ##############################################################################################
+
+// rowScanner ist die gemeinsame Schnittstelle von *sql.Row und *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanNote liest eine Notiz aus einer Zeile (id, date, text, done, owner).
+func scanNote(row rowScanner) (*Note, error) {
+	var note Note
+	if err := row.Scan(&note.Id, &note.Date, &note.Text, &note.Done, &note.Owner); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoteNotFound
+		}
+		return nil, err
+	}
+	return &note, nil
+}
+
+// scanNotes liest alle Zeilen und schließt danach rows.
+func scanNotes(rows *sql.Rows) ([]Note, error) {
+	defer rows.Close()
+	var notes []Note
+	for rows.Next() {
+		note, err := scanNote(rows)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, *note)
+	}
+	return notes, rows.Err()
+}
+
+// requireAffected gibt ErrNoteNotFound zurück, wenn keine Zeile betroffen war.
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNoteNotFound
+	}
+	return nil
+}
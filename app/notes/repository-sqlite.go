@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Format der date-Spalte in SQLite. Wie bei Postgres (DATE) wird nur das Datum gespeichert
+const sqliteDateFormat = "2006-01-02"
+
+// ##############################################################################################
+// SqliteNoteRepository speichert die Notizen in einer eingebetteten SQLite-Datenbank.
+// Gedacht für den lokalen Betrieb als eine einzige Binary ohne Postgres. Das Schema wird beim Öffnen angelegt.
+// ##############################################################################################
+
+type SqliteNoteRepository struct {
+	db *sql.DB
+}
+
+// NewSqliteNoteRepository öffnet (oder erstellt) die SQLite-Datenbank unter path.
+func NewSqliteNoteRepository(path string) (*SqliteNoteRepository, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite erlaubt nur einen Schreiber, so gibt es keine "database is locked" Fehler
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS notes (
+		id    TEXT PRIMARY KEY,
+		date  TEXT NOT NULL,
+		owner TEXT NOT NULL,
+		text  TEXT NOT NULL,
+		done  BOOLEAN NOT NULL DEFAULT 0
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SqliteNoteRepository{db: db}, nil
+}
+
+func (repo *SqliteNoteRepository) List(ctx context.Context, owner string) ([]Note, error) {
+	rows, err := repo.db.QueryContext(ctx, "SELECT id, date, text, done, owner FROM notes WHERE owner = ? ORDER BY date, id", owner)
+	if err != nil {
+		return nil, err
+	}
+	return scanSqliteNotes(rows)
+}
+
+func (repo *SqliteNoteRepository) Get(ctx context.Context, owner string, id string) (*Note, error) {
+	row := repo.db.QueryRowContext(ctx, "SELECT id, date, text, done, owner FROM notes WHERE id = ? AND owner = ?", id, owner)
+	return scanSqliteNote(row)
+}
+
+func (repo *SqliteNoteRepository) Create(ctx context.Context, note Note) (*Note, error) {
+	note.Id = newNoteId()
+	_, err := repo.db.ExecContext(ctx, "INSERT INTO notes (id, date, text, done, owner) VALUES (?, ?, ?, ?, ?)",
+		note.Id, note.Date.Format(sqliteDateFormat), note.Text, note.Done, note.Owner)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Get(ctx, note.Owner, note.Id)
+}
+
+func (repo *SqliteNoteRepository) Update(ctx context.Context, owner string, id string, patch NotePatch) (*Note, error) {
+	var date interface{}
+	if patch.Date != nil {
+		date = patch.Date.Format(sqliteDateFormat)
+	}
+	row := repo.db.QueryRowContext(ctx, `UPDATE notes
+		SET date = COALESCE(?, date), text = COALESCE(?, text), done = COALESCE(?, done)
+		WHERE id = ? AND owner = ?
+		RETURNING id, date, text, done, owner`,
+		date, patch.Text, patch.Done, id, owner)
+	return scanSqliteNote(row)
+}
+
+func (repo *SqliteNoteRepository) Delete(ctx context.Context, owner string, id string) error {
+	result, err := repo.db.ExecContext(ctx, "DELETE FROM notes WHERE id = ? AND owner = ?", id, owner)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+func (repo *SqliteNoteRepository) DeleteByText(ctx context.Context, owner string, text string) error {
+	_, err := repo.db.ExecContext(ctx, "DELETE FROM notes WHERE text = ? AND owner = ?", text, owner)
+	return err
+}
+
+func (repo *SqliteNoteRepository) Search(ctx context.Context, owner string, query string) ([]Note, error) {
+	// instr statt LIKE, damit % und _ in der Suche keine Platzhalter sind
+	rows, err := repo.db.QueryContext(ctx,
+		"SELECT id, date, text, done, owner FROM notes WHERE owner = ? AND instr(lower(text), lower(?)) > 0 ORDER BY date, id", owner, query)
+	if err != nil {
+		return nil, err
+	}
+	return scanSqliteNotes(rows)
+}
+
+// Close schließt die Datenbank.
+func (repo *SqliteNoteRepository) Close() error {
+	return repo.db.Close()
+}
+
+// scanSqliteNote liest eine Notiz aus einer Zeile, das Datum ist als Text gespeichert.
+func scanSqliteNote(row rowScanner) (*Note, error) {
+	var note Note
+	var date string
+	if err := row.Scan(&note.Id, &date, &note.Text, &note.Done, &note.Owner); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoteNotFound
+		}
+		return nil, err
+	}
+	parsed, err := time.Parse(sqliteDateFormat, date)
+	if err != nil {
+		return nil, err
+	}
+	note.Date = parsed
+	return &note, nil
+}
+
+// scanSqliteNotes liest alle Zeilen und schließt danach rows.
+func scanSqliteNotes(rows *sql.Rows) ([]Note, error) {
+	defer rows.Close()
+	var notes []Note
+	for rows.Next() {
+		note, err := scanSqliteNote(rows)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, *note)
+	}
+	return notes, rows.Err()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrNoteNotFound wird zurückgegeben, wenn eine Notiz nicht existiert oder einem anderen Benutzer gehört.
+var ErrNoteNotFound = errors.New("note not found")
+
+// ##############################################################################################
+// NoteRepository ist die Schnittstelle zur Speicherung der Notizen.
+// Alle Operationen sind auf einen Besitzer (owner) beschränkt: Notizen anderer Benutzer sind nicht sichtbar.
+// Implementierungen: Postgres (Produktion), SQLite (lokal, eine Binary) und In-Memory (Unit Tests).
+// ##############################################################################################
+
+type NoteRepository interface {
+	// List gibt alle Notizen des Besitzers zurück, sortiert nach Datum und Id (wie Search).
+	List(ctx context.Context, owner string) ([]Note, error)
+	// Get gibt eine Notiz des Besitzers zurück oder ErrNoteNotFound.
+	Get(ctx context.Context, owner string, id string) (*Note, error)
+	// Create speichert eine neue Notiz und gibt sie mit der vergebenen Id zurück.
+	Create(ctx context.Context, note Note) (*Note, error)
+	// Update ändert die gesetzten Felder einer Notiz und gibt die geänderte Notiz zurück oder ErrNoteNotFound.
+	Update(ctx context.Context, owner string, id string, patch NotePatch) (*Note, error)
+	// Delete löscht eine Notiz oder gibt ErrNoteNotFound zurück.
+	Delete(ctx context.Context, owner string, id string) error
+	// DeleteByText löscht alle Notizen mit diesem Text (nur für die veraltete Route /notes/delete).
+	DeleteByText(ctx context.Context, owner string, text string) error
+	// Search gibt alle Notizen des Besitzers zurück, deren Text query enthält (ohne Groß-/Kleinschreibung).
+	Search(ctx context.Context, owner string, query string) ([]Note, error)
+}
+
+// ##############################################################################################
+// openRepository erstellt das konfigurierte Storage Backend ("postgres", "sqlite" oder "memory").
+// ##############################################################################################
+
+func openRepository(backend string) (NoteRepository, error) {
+	switch backend {
+	case "postgres":
+		initDB()
+		return NewPostgresNoteRepository(Db), nil
+	case "sqlite":
+		return NewSqliteNoteRepository(SqlitePath)
+	case "memory":
+		return NewMemoryNoteRepository(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
+}
+
+// newNoteId erzeugt eine zufällige UUID (Version 4) für Backends, die selbst keine Ids vergeben.
+func newNoteId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ##############################################################################################
+// Unit Tests für die NoteRepository Implementierungen.
+// Jedes Backend muss sich gleich verhalten, deswegen laufen dieselben Tests gegen alle
+// Backends, die ohne Postgres auskommen.
+// ##############################################################################################
+
+func testRepositories(t *testing.T) map[string]NoteRepository {
+	sqlite, err := NewSqliteNoteRepository(filepath.Join(t.TempDir(), "notes.db"))
+	if err != nil {
+		t.Fatalf("Failed to open sqlite repository: %v", err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+
+	return map[string]NoteRepository{
+		"memory": NewMemoryNoteRepository(),
+		"sqlite": sqlite,
+	}
+}
+
+func TestNoteRepository(t *testing.T) {
+	ctx := context.Background()
+	date := time.Date(2024, 7, 28, 0, 0, 0, 0, time.UTC)
+
+	for name, repo := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			first, err := repo.Create(ctx, Note{Date: date, Text: "Buy milk", Owner: "alice"})
+			if err != nil {
+				t.Fatalf("Create() failed: %v", err)
+			}
+			if !noteIdPattern.MatchString(first.Id) {
+				t.Errorf("Expected UUID as id, got %v", first.Id)
+			}
+			// Gleicher Text, aber eigene Id
+			second, _ := repo.Create(ctx, Note{Date: date, Text: "Buy milk", Owner: "alice"})
+			repo.Create(ctx, Note{Date: date, Text: "Secret", Owner: "bob"})
+
+			notes, err := repo.List(ctx, "alice")
+			if err != nil || len(notes) != 2 {
+				t.Fatalf("Expected 2 notes for alice, got %v (%v)", len(notes), err)
+			}
+			if !notes[0].Date.Equal(date) || notes[0].Id != first.Id && notes[0].Id != second.Id {
+				t.Errorf("Expected a note of alice with date %v, got %+v", date, notes[0])
+			}
+
+			// Notizen anderer Benutzer sind nicht sichtbar
+			if _, err := repo.Get(ctx, "bob", first.Id); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("Expected ErrNoteNotFound for other owner, got %v", err)
+			}
+
+			done := true
+			updated, err := repo.Update(ctx, "alice", first.Id, NotePatch{Done: &done})
+			if err != nil {
+				t.Fatalf("Update() failed: %v", err)
+			}
+			if !updated.Done || updated.Text != "Buy milk" {
+				t.Errorf("Expected only done to change, got %+v", updated)
+			}
+			if _, err := repo.Update(ctx, "bob", first.Id, NotePatch{Done: &done}); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("Expected ErrNoteNotFound when updating foreign note, got %v", err)
+			}
+
+			found, err := repo.Search(ctx, "alice", "MILK")
+			if err != nil || len(found) != 2 {
+				t.Errorf("Expected 2 search results, got %v (%v)", len(found), err)
+			}
+			if found, _ := repo.Search(ctx, "alice", "%"); len(found) != 0 {
+				t.Errorf("Expected '%%' to be matched literally, got %v results", len(found))
+			}
+
+			// Delete löscht genau eine Notiz, auch bei gleichem Text
+			if err := repo.Delete(ctx, "alice", second.Id); err != nil {
+				t.Fatalf("Delete() failed: %v", err)
+			}
+			if err := repo.Delete(ctx, "alice", second.Id); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("Expected ErrNoteNotFound on second delete, got %v", err)
+			}
+			if notes, _ := repo.List(ctx, "alice"); len(notes) != 1 {
+				t.Errorf("Expected 1 note after delete, got %v", len(notes))
+			}
+
+			if err := repo.DeleteByText(ctx, "alice", "Buy milk"); err != nil {
+				t.Fatalf("DeleteByText() failed: %v", err)
+			}
+			if notes, _ := repo.List(ctx, "alice"); len(notes) != 0 {
+				t.Errorf("Expected no notes after DeleteByText, got %v", len(notes))
+			}
+			if notes, _ := repo.List(ctx, "bob"); len(notes) != 1 {
+				t.Errorf("Expected bob's note to survive, got %v", len(notes))
+			}
+		})
+	}
+}
+
+func TestNoteRepositoryOrder(t *testing.T) {
+	ctx := context.Background()
+	date := time.Date(2024, 7, 28, 0, 0, 0, 0, time.UTC)
+
+	for name, repo := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			later, _ := repo.Create(ctx, Note{Date: date.AddDate(0, 0, 1), Text: "Later", Owner: "alice"})
+			a, _ := repo.Create(ctx, Note{Date: date, Text: "Today", Owner: "alice"})
+			b, _ := repo.Create(ctx, Note{Date: date, Text: "Today too", Owner: "alice"})
+			if b.Id < a.Id {
+				a, b = b, a
+			}
+			expected := []string{a.Id, b.Id, later.Id}
+
+			// Sortiert nach Datum und Id, unabhängig von Einfügen und Ändern
+			done := true
+			repo.Update(ctx, "alice", a.Id, NotePatch{Done: &done})
+			for _, list := range []func() ([]Note, error){
+				func() ([]Note, error) { return repo.List(ctx, "alice") },
+				func() ([]Note, error) { return repo.Search(ctx, "alice", "") },
+			} {
+				notes, err := list()
+				if err != nil || len(notes) != len(expected) {
+					t.Fatalf("Expected %d notes, got %v (%v)", len(expected), len(notes), err)
+				}
+				for i, note := range notes {
+					if note.Id != expected[i] {
+						t.Errorf("Expected order %v, got %v at %d", expected, note.Id, i)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// ##############################################################################################
+// Tests für die Routen /notes/{id} und die veraltete Route /notes/delete. Geprüft wird, was vor dem
+// Zugriff auf die Datenbank entschieden wird: Authentifizierung, Ids, Methoden und Parameter.
+// ##############################################################################################
+
+// routeRequest sendet eine Anfrage mit dem Access Token an den Router
+func routeRequest(router http.Handler, token, method, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestNoteIdFromPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		ok       bool
+	}{
+		{"/notes/3f2b8c1e-7a4d-4e2f-9b6a-1c0d5e8f7a21", "3f2b8c1e-7a4d-4e2f-9b6a-1c0d5e8f7a21", true},
+		{"/notes/3F2B8C1E-7A4D-4E2F-9B6A-1C0D5E8F7A21", "3f2b8c1e-7a4d-4e2f-9b6a-1c0d5e8f7a21", true},
+		{"/notes/not-a-uuid", "", false},
+		{"/notes/3f2b8c1e-7a4d-4e2f-9b6a-1c0d5e8f7a21/extra", "", false},
+		{"/notes/", "", false},
+		{"/other/3f2b8c1e-7a4d-4e2f-9b6a-1c0d5e8f7a21", "", false},
+	}
+
+	for _, tt := range tests {
+		id, ok := noteIdFromPath(tt.path)
+		if id != tt.expected || ok != tt.ok {
+			t.Errorf("noteIdFromPath(%q): expected %q %v, got %q %v", tt.path, tt.expected, tt.ok, id, ok)
+		}
+	}
+}
+
+func TestNoteResourceRouting(t *testing.T) {
+	privateKey, err := createPrivateKey()
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	Keys = StaticKey{&privateKey.PublicKey}
+	router := newRouter()
+	token := createMockToken(ResourceId, "alice", privateKey)
+	path := "/notes/3f2b8c1e-7a4d-4e2f-9b6a-1c0d5e8f7a21"
+
+	tests := []struct {
+		name           string
+		token          string
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{"without token", "", http.MethodGet, path, http.StatusUnauthorized},
+		{"invalid id", token, http.MethodGet, "/notes/not-a-uuid", http.StatusNotFound},
+		{"invalid id before method", token, http.MethodPost, "/notes/not-a-uuid", http.StatusNotFound},
+		{"POST on a note", token, http.MethodPost, path, http.StatusMethodNotAllowed},
+		{"PUT on the list", token, http.MethodPut, "/notes", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := routeRequest(router, tt.token, tt.method, tt.path); rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %v, got %v", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestDeprecatedDeleteRoute(t *testing.T) {
+	privateKey, err := createPrivateKey()
+	if err != nil {
+		t.Fatalf("Failed to create private key: %v", err)
+	}
+	Keys = StaticKey{&privateKey.PublicKey}
+	router := newRouter()
+	token := createMockToken(ResourceId, "alice", privateKey)
+
+	// Ohne text: 400, aber auch dann als veraltet gekennzeichnet
+	rec := routeRequest(router, token, http.MethodDelete, "/notes/delete")
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Deprecation") != "true" {
+		t.Errorf("Expected status 400 with Deprecation header, got %v %q", rec.Code, rec.Header().Get("Deprecation"))
+	}
+
+	// /notes/delete ist keine Id, andere Methoden werden abgelehnt
+	if rec := routeRequest(router, token, http.MethodGet, "/notes/delete?text=Old+client"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %v", rec.Code)
+	}
+	if rec := routeRequest(router, "", http.MethodDelete, "/notes/delete?text=Old+client"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without token, got %v", rec.Code)
+	}
+}
@@ -0,0 +1,70 @@
+// ##############################################################################################
+// Hier stehen die Service Principals: Batch-Jobs und Hintergrunddienste, die sich mit dem Client
+// Credentials Grant einen Token holen. In solchen Tokens ist sub die Client Id des Dienstes
+// (https://datatracker.ietf.org/doc/html/rfc9068#section-2.2), es gibt keinen Benutzer.
+// Service Principals sehen nie die Notizen eines Benutzers: ihre Notizen liegen unter einem eigenen
+// Besitzer ("service:<client_id>"), den kein Benutzer-Token erreichen kann. Lesen und Schreiben
+// verlangen jeweils einen eigenen Scope.
+// ##############################################################################################
+
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Präfix der Besitzer von Notizen der Service Principals
+const ServiceOwnerPrefix = "service:"
+
+// isServiceToken prüft, ob der Token ein Maschinen-Token ist: sub ist die Client Id, die ihn angefordert hat.
+func isServiceToken(claims *AccessTokenClaims) bool {
+	return claims.ClientId != "" && claims.Subject == claims.ClientId
+}
+
+// Owner gibt den Besitzer der Notizen des Principals zurück: das Subject eines Benutzers oder
+// "service:" und die Client Id eines Service Principals.
+func (p *Principal) Owner() string {
+	if p.Service {
+		return ServiceOwnerPrefix + p.Subject
+	}
+	return p.Subject
+}
+
+// ##############################################################################################
+// authorizeServicePrincipal prüft einen Maschinen-Token: Der Service-Principal-Modus muss an sein, die
+// Client Id in ServicePrincipals stehen und der Token den Scope für die Methode der Anfrage enthalten
+// (ServiceReadScope für GET, sonst ServiceWriteScope). Benutzer-Tokens mit einem sub, das wie ein
+// Besitzer eines Service Principals aussieht, werden abgelehnt.
+// ##############################################################################################
+
+func authorizeServicePrincipal(r *http.Request, claims *AccessTokenClaims) error {
+	if !isServiceToken(claims) {
+		if strings.HasPrefix(claims.Subject, ServiceOwnerPrefix) {
+			return invalidToken("the token subject is reserved for service principals", nil)
+		}
+		return nil
+	}
+
+	if !ServicePrincipalMode || !servicePrincipalAllowed(claims.ClientId) {
+		return &TokenError{Code: ErrorInsufficientScope, Description: "the client is not allowed to access notes as a service principal"}
+	}
+	scope := ServiceWriteScope
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		scope = ServiceReadScope
+	}
+	if !claims.HasScope(scope) {
+		return &TokenError{Code: ErrorInsufficientScope, Description: "the token does not contain the required scope", Scope: scope}
+	}
+	return nil
+}
+
+// servicePrincipalAllowed prüft, ob die Client Id als Service Principal eingetragen ist.
+func servicePrincipalAllowed(clientId string) bool {
+	for _, allowed := range ServicePrincipals {
+		if allowed == clientId {
+			return true
+		}
+	}
+	return false
+}
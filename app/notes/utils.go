@@ -3,79 +3,96 @@ package main
 import (
 	"crypto/tls"
 	"log"
-	"encoding/pem"
 	"crypto/x509"
-	"crypto/rsa"
 	"net/http"
 	"database/sql"
 	"fmt"
 	"io/ioutil"
+	"time"
 	"github.com/golang-jwt/jwt/v4"
 )
 
 // ##############################################################################################
-// validateJwt überprüft das JWT-Token und validiert es gegen den gegebenen RSA-öffentlichen Schlüssel.
-// Gibt den Benutzer (sub) zurück, wenn das Token gültig ist und die "notes"-Anspruch den erwarteten Wert hat.
+// validateJwt überprüft das JWT-Token und validiert es gegen den Schlüssel aus der KeySource.
+// Der Schlüssel wird anhand der kid im JWT-Header gewählt, nur Algorithmen aus AllowedAlgorithms sind erlaubt.
+// Danach werden iss, aud, exp, nbf, iat (mit Toleranz ClockSkew), sub und ggf. der scope geprüft.
+// Die aud muss den ResourceIndicator enthalten (RFC 8707). Ignoriert der Provider den resource Parameter
+// (wie Authentik), wird mit LegacyNotesClaim stattdessen aud = Audience und der "notes"-Claim geprüft.
+// Gibt die Claims zurück, wenn das Token gültig ist, sonst einen TokenError mit der Begründung.
 // ##############################################################################################
 
-func validateJwt(source string, key *rsa.PublicKey) (string, bool) {
-	token, err := jwt.Parse(source, func(token *jwt.Token) (interface{}, error) {
-		// Nur RSA ist unterstützt
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return key, nil
+func validateJwt(source string, keys KeySource) (*AccessTokenClaims, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(AllowedAlgorithms),
+		// Die zeitlichen Claims werden unten mit Toleranz geprüft
+		jwt.WithoutClaimsValidation(),
+	)
+
+	var claims AccessTokenClaims
+	_, err := parser.ParseWithClaims(source, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keys.Key(kid, token.Method.Alg())
 	})
-
 	if err != nil {
-		log.Printf("Error parsing token: %v", err)
-		return "", false
-	}
-
-	// Überprüfen der Ansprüche des Tokens
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		note := claims["notes"].(string)
-		sub := claims["sub"].(string)
-		if note == ResourceId {
-			return sub, true
-		} else {
-			return "", false
-		}
-	} else {
-		return "", false
+		return nil, invalidToken("the token signature is invalid or the token is malformed", err)
 	}
-}
-
-// ##############################################################################################
-// LoadPublicKey lädt den öffentlichen Schlüssel aus einer Zertifikatsdatei.
-// ##############################################################################################
 
-func LoadPublicKey(certFile string) (*rsa.PublicKey, error) {
-	// Zertifikatsdatei lesen
-	certPEM, err := ioutil.ReadFile(certFile)
-	if err != nil {
+	if claims.Issuer != Issuer {
+		return nil, invalidToken("the token was issued by an unexpected issuer", nil)
+	}
+	if err := checkAudience(&claims); err != nil {
 		return nil, err
 	}
 
-	// PEM-Daten dekodieren
-	block, _ := pem.Decode(certPEM)
-	if block == nil || block.Type != "CERTIFICATE" {
-		return nil, fmt.Errorf("failed to decode PEM block containing certificate")
+	now := time.Now()
+	if claims.ExpiresAt == nil {
+		return nil, invalidToken("the token has no expiration time", nil)
+	}
+	if now.After(claims.ExpiresAt.Add(ClockSkew)) {
+		return nil, invalidToken("the token is expired", nil)
+	}
+	if claims.NotBefore != nil && now.Add(ClockSkew).Before(claims.NotBefore.Time) {
+		return nil, invalidToken("the token is not valid yet", nil)
+	}
+	if claims.IssuedAt != nil && now.Add(ClockSkew).Before(claims.IssuedAt.Time) {
+		return nil, invalidToken("the token was issued in the future", nil)
 	}
 
-	// Zertifikat parsen
-	cert, err := x509.ParseCertificate(block.Bytes)
-	if err != nil {
-		return nil, err
+	if claims.Subject == "" {
+		return nil, invalidToken("the token has no subject", nil)
 	}
+	if RequiredScope != "" && !claims.HasScope(RequiredScope) {
+		return nil, &TokenError{Code: ErrorInsufficientScope, Description: "the token does not contain the required scope"}
+	}
+
+	return &claims, nil
+}
 
-	// Extrahieren des öffentlichen Schlüssels
-	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("certificate does not contain an RSA public key")
+// checkAudience prüft, ob der Token für diesen Resource Server bestimmt ist: über den ResourceIndicator
+// in aud oder, als Fallback für Provider ohne Resource Indicators, über Audience und den "notes"-Claim.
+func checkAudience(claims *AccessTokenClaims) error {
+	if ResourceIndicator != "" && claims.VerifyAudience(ResourceIndicator, true) {
+		return nil
 	}
+	if !LegacyNotesClaim || !claims.VerifyAudience(Audience, true) {
+		return invalidToken("the token is not intended for this resource server", nil)
+	}
+	if claims.Notes != ResourceId {
+		return invalidToken("the token does not grant access to the notes resource", nil)
+	}
+	return nil
+}
+
+// ##############################################################################################
+// StaticKey ist eine KeySource mit genau einem Schlüssel, unabhängig von der kid.
+// ##############################################################################################
+
+type StaticKey struct {
+	PublicKey interface{}
+}
 
-	return publicKey, nil
+func (k StaticKey) Key(kid string, alg string) (interface{}, error) {
+	return k.PublicKey, nil
 }
 
 // ##############################################################################################